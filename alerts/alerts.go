@@ -0,0 +1,290 @@
+// Package alerts implements spending alerts: large transactions,
+// daily and weekly spend limits per account, transactions from new
+// countries or unknown terminals and low card balance.
+package alerts
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/crypt"
+	"github.com/tuxofil/p24fetch/schema"
+	"github.com/tuxofil/p24fetch/txn"
+)
+
+// Alert kinds
+const (
+	LargeTransaction = "large_transaction"
+	DailyLimit       = "daily_limit"
+	WeeklyLimit      = "weekly_limit"
+	NewCountry       = "new_country"
+	UnknownTerminal  = "unknown_terminal"
+	LowBalance       = "low_balance"
+)
+
+// Layout of dates used as keys of the spend history
+const dayLayout = "2006-01-02"
+
+// Alert is a single alert raised.
+type Alert struct {
+	// Alert kind. One of the constants above.
+	Kind string
+	// Human readable description
+	Message string
+}
+
+// Balance is a card balance.
+type Balance struct {
+	Value    float32
+	Currency schema.Currency
+}
+
+// Evaluator evaluates alert rules against transactions.
+type Evaluator struct {
+	// Configuration used to create the instance
+	config config.Config
+	// Path to the state file
+	stateFile string
+	// History needed to evaluate rules
+	state state
+	// Transaction to stage state writes in.
+	// When nil, the state is written immediately.
+	tx *txn.Tx
+}
+
+type state struct {
+	// Set after the first evaluation. Terminals and countries are
+	// learned silently on the first evaluation.
+	Initialized bool `json:"initialized"`
+	// Terminals seen before
+	Terminals map[string]bool `json:"terminals"`
+	// Countries seen before
+	Countries map[string]bool `json:"countries"`
+	// Spend history. Mapping: day -> GnuCash Account ID -> amount.
+	Spend map[string]map[string]float32 `json:"spend"`
+	// Set when the low balance alert was raised.
+	// Cleared when the balance is restored.
+	LowBalance bool `json:"low_balance"`
+}
+
+// New creates new Evaluator instance.
+// When alerts are not configured, Evaluate never raises alerts.
+func New(cfg *config.Config) (*Evaluator, error) {
+	e := &Evaluator{config: *cfg}
+	if cfg.Alerts == nil {
+		return e, nil
+	}
+	stateFile, err := cfg.StateFile(cfg.DedupDir, "-alerts.json")
+	if err != nil {
+		return nil, err
+	}
+	e.stateFile = stateFile
+	data, err := crypt.ReadFile(stateFile, cfg.AgeIdentity)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("read state file: %w", err)
+		}
+	} else if err := json.Unmarshal(data, &e.state); err != nil {
+		return nil, fmt.Errorf("parse state: %w", err)
+	}
+	return e, nil
+}
+
+// WithTx makes the evaluator stage state writes in
+// the transaction instead of writing the state immediately.
+func (e *Evaluator) WithTx(tx *txn.Tx) *Evaluator {
+	e.tx = tx
+	return e
+}
+
+// Evaluate alert rules. All new transactions (trans) are checked for
+// amount, terminal and country; sorted transactions are checked
+// against spend limits. Accounts is a mapping from account ShortIDs
+// to GnuCash Account IDs. Balance is optional.
+func (e *Evaluator) Evaluate(
+	trans []schema.Transaction,
+	sorted []schema.Transaction,
+	accounts map[string]string,
+	balance *Balance,
+) []Alert {
+	cfg := e.config.Alerts
+	if cfg == nil {
+		return nil
+	}
+	if e.state.Terminals == nil {
+		e.state.Terminals = map[string]bool{}
+	}
+	if e.state.Countries == nil {
+		e.state.Countries = map[string]bool{}
+	}
+	if e.state.Spend == nil {
+		e.state.Spend = map[string]map[string]float32{}
+	}
+	var alerts []Alert
+	raise := func(kind, format string, args ...interface{}) {
+		alerts = append(alerts, Alert{Kind: kind, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, tran := range trans {
+		if tran.Raw != nil {
+			continue
+		}
+		if spent := -tran.SrcVal; cfg.MaxAmount > 0 && spent > cfg.MaxAmount {
+			raise(LargeTransaction, "%s: %.2f %s spent at %s (more than %.2f)",
+				tran.Date.Format("2006-01-02 15:04"), spent, tran.SrcCur,
+				tran.Dst, cfg.MaxAmount)
+		}
+		if tran.Dst != "" && !e.state.Terminals[tran.Dst] {
+			e.state.Terminals[tran.Dst] = true
+			if e.state.Initialized && cfg.UnknownTerminals {
+				raise(UnknownTerminal, "%s: %.2f %s at unknown terminal %s",
+					tran.Date.Format("2006-01-02 15:04"), -tran.SrcVal,
+					tran.SrcCur, tran.Dst)
+			}
+		}
+		if country := Country(tran.Dst); country != "" && !e.state.Countries[country] {
+			e.state.Countries[country] = true
+			if e.state.Initialized && cfg.NewCountries {
+				raise(NewCountry, "%s: %.2f %s at %s, new country: %s",
+					tran.Date.Format("2006-01-02 15:04"), -tran.SrcVal,
+					tran.SrcCur, tran.Dst, country)
+			}
+		}
+	}
+
+	daily := resolveLimits(cfg.DailyLimits, accounts)
+	weekly := resolveLimits(cfg.WeeklyLimits, accounts)
+	var latest time.Time
+	for _, tran := range sorted {
+		if tran.SrcVal >= 0 {
+			continue
+		}
+		if tran.Date.After(latest) {
+			latest = tran.Date
+		}
+		day := tran.Date.Format(dayLayout)
+		if e.state.Spend[day] == nil {
+			e.state.Spend[day] = map[string]float32{}
+		}
+		dayBefore := e.state.Spend[day][tran.Dst]
+		weekBefore := e.weekSpend(tran.Date, tran.Dst)
+		e.state.Spend[day][tran.Dst] += -tran.SrcVal
+		if limit, ok := daily[tran.Dst]; ok && dayBefore <= limit && dayBefore-tran.SrcVal > limit {
+			raise(DailyLimit, "%s: spend for %s is %.2f %s (limit %.2f)",
+				day, tran.Dst, dayBefore-tran.SrcVal, tran.SrcCur, limit)
+		}
+		if limit, ok := weekly[tran.Dst]; ok && weekBefore <= limit && weekBefore-tran.SrcVal > limit {
+			raise(WeeklyLimit, "week till %s: spend for %s is %.2f %s (limit %.2f)",
+				day, tran.Dst, weekBefore-tran.SrcVal, tran.SrcCur, limit)
+		}
+	}
+	if !latest.IsZero() {
+		e.pruneSpend(latest)
+	}
+
+	if cfg.MinBalance != nil && balance != nil {
+		if balance.Value < *cfg.MinBalance {
+			if !e.state.LowBalance {
+				raise(LowBalance, "balance is %.2f %s (less than %.2f)",
+					balance.Value, balance.Currency, *cfg.MinBalance)
+			}
+			e.state.LowBalance = true
+		} else {
+			e.state.LowBalance = false
+		}
+	}
+
+	e.state.Initialized = true
+	return alerts
+}
+
+// Save writes the evaluator state to the file.
+func (e *Evaluator) Save() error {
+	if e.config.Alerts == nil {
+		return nil
+	}
+	data, err := json.Marshal(e.state)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	if data, err = crypt.Encrypt(data, e.config.AgeRecipients); err != nil {
+		return err
+	}
+	if err := e.tx.WriteFile(e.stateFile, data); err != nil {
+		return fmt.Errorf("write file: %w", err)
+	}
+	return nil
+}
+
+// Sum of spend for the account during 7 days ending with given date.
+func (e *Evaluator) weekSpend(date time.Time, account string) float32 {
+	var sum float32
+	for i := 0; i < 7; i++ {
+		sum += e.state.Spend[date.AddDate(0, 0, -i).Format(dayLayout)][account]
+	}
+	return sum
+}
+
+// Remove spend history older than a week before the latest date.
+func (e *Evaluator) pruneSpend(latest time.Time) {
+	oldest := latest.AddDate(0, 0, -7).Format(dayLayout)
+	for day := range e.state.Spend {
+		if day < oldest {
+			delete(e.state.Spend, day)
+		}
+	}
+}
+
+// Convert limits keyed by account ShortIDs to limits
+// keyed by GnuCash Account IDs.
+func resolveLimits(limits map[string]float32, accounts map[string]string) map[string]float32 {
+	res := make(map[string]float32, len(limits))
+	for account, limit := range limits {
+		if name, ok := accounts[account]; ok {
+			account = name
+		}
+		res[account] = limit
+	}
+	return res
+}
+
+// Country extracts ISO 3166-1 alpha-3 country code from
+// the terminal name. Returns empty string when no country found.
+func Country(terminal string) string {
+	fields := strings.FieldsFunc(terminal, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '/' || r == '(' || r == ')'
+	})
+	if len(fields) < 2 {
+		return ""
+	}
+	last := fields[len(fields)-1]
+	i := sort.SearchStrings(countries, last)
+	if i < len(countries) && countries[i] == last {
+		return last
+	}
+	return ""
+}
+
+// ISO 3166-1 alpha-3 country codes, sorted.
+var countries = strings.Fields(`
+ABW AFG AGO AIA ALA ALB AND ARE ARG ARM ASM ATA ATF ATG AUS AUT AZE
+BDI BEL BEN BES BFA BGD BGR BHR BHS BIH BLM BLR BLZ BMU BOL BRA BRB
+BRN BTN BVT BWA CAF CAN CCK CHE CHL CHN CIV CMR COD COG COK COL COM
+CPV CRI CUB CUW CXR CYM CYP CZE DEU DJI DMA DNK DOM DZA ECU EGY ERI
+ESH ESP EST ETH FIN FJI FLK FRA FRO FSM GAB GBR GEO GGY GHA GIB GIN
+GLP GMB GNB GNQ GRC GRD GRL GTM GUF GUM GUY HKG HMD HND HRV HTI HUN
+IDN IMN IND IOT IRL IRN IRQ ISL ISR ITA JAM JEY JOR JPN KAZ KEN KGZ
+KHM KIR KNA KOR KWT LAO LBN LBR LBY LCA LIE LKA LSO LTU LUX LVA MAC
+MAF MAR MCO MDA MDG MDV MEX MHL MKD MLI MLT MMR MNE MNG MNP MOZ MRT
+MSR MTQ MUS MWI MYS MYT NAM NCL NER NFK NGA NIC NIU NLD NOR NPL NRU
+NZL OMN PAK PAN PCN PER PHL PLW PNG POL PRI PRK PRT PRY PSE PYF QAT
+REU ROU RUS RWA SAU SDN SEN SGP SGS SHN SJM SLB SLE SLV SMR SOM SPM
+SRB SSD STP SUR SVK SVN SWE SWZ SXM SYC SYR TCA TCD TGO THA TJK TKL
+TKM TLS TON TTO TUN TUR TUV TWN TZA UGA UKR UMI URY USA UZB VAT VCT
+VEN VGB VIR VNM VUT WLF WSM YEM ZAF ZMB ZWE
+`)
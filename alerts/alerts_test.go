@@ -0,0 +1,122 @@
+package alerts
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/schema"
+)
+
+func TestCountry(t *testing.T) {
+	testset := []struct {
+		Terminal string
+		Expect   string
+	}{
+		{"", ""},
+		{"UKR", ""},
+		{"Silpo, Kyiv", ""},
+		{"SHOP KYIV UKR", "UKR"},
+		{"AMAZON.DE LUXEMBOURG LUX", "LUX"},
+		{"Steam (USA)", "USA"},
+		{"SHOP KYIV XYZ", ""},
+	}
+	for n, test := range testset {
+		assert.Equal(t, test.Expect, Country(test.Terminal),
+			"test #%d: %+v", n, test)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p24fetch-alerts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	minBalance := float32(100)
+	cfg := &config.Config{
+		CardNumber: "abcd",
+		DedupDir:   dir,
+		Alerts: &config.AlertsConfig{
+			MaxAmount:        1000,
+			DailyLimits:      map[string]float32{"food": 100},
+			WeeklyLimits:     map[string]float32{"Expenses:Fun": 300},
+			NewCountries:     true,
+			UnknownTerminals: true,
+			MinBalance:       &minBalance,
+		},
+	}
+	accounts := map[string]string{"food": "Expenses:Food", "fun": "Expenses:Fun"}
+	day := func(d int) time.Time {
+		return time.Date(2020, 9, d, 12, 0, 0, 0, time.UTC)
+	}
+	tran := func(d int, val float32, dst string) schema.Transaction {
+		return schema.Transaction{Date: day(d), SrcVal: val, SrcCur: schema.UAH, Dst: dst}
+	}
+	kinds := func(list []Alert) []string {
+		var res []string
+		for _, alert := range list {
+			res = append(res, alert.Kind)
+		}
+		return res
+	}
+
+	// The first run learns terminals and countries silently
+	e, err := New(cfg)
+	require.NoError(t, err)
+	list := e.Evaluate(
+		[]schema.Transaction{tran(1, -2000, "SHOP KYIV UKR")},
+		[]schema.Transaction{tran(1, -60, "Expenses:Food")},
+		accounts, &Balance{Value: 500, Currency: schema.UAH})
+	assert.Equal(t, []string{LargeTransaction}, kinds(list))
+	require.NoError(t, e.Save())
+
+	// State is restored from the file
+	e, err = New(cfg)
+	require.NoError(t, err)
+	list = e.Evaluate(
+		[]schema.Transaction{
+			tran(1, -10, "SHOP KYIV UKR"),
+			tran(2, -10, "CAFE LVIV UKR"),
+			tran(2, -10, "CAFE WARSZAWA POL"),
+		},
+		[]schema.Transaction{
+			tran(1, -50, "Expenses:Food"),
+			tran(2, -50, "Expenses:Food"),
+			tran(3, -200, "Expenses:Fun"),
+			tran(4, -200, "Expenses:Fun"),
+			tran(5, -200, "Expenses:Fun"),
+		},
+		accounts, &Balance{Value: 50, Currency: schema.UAH})
+	assert.Equal(t, []string{
+		UnknownTerminal, UnknownTerminal, NewCountry,
+		DailyLimit, WeeklyLimit, LowBalance,
+	}, kinds(list))
+	assert.Contains(t, list[3].Message, "Expenses:Food is 110.00 UAH (limit 100.00)")
+
+	// Low balance is reported once
+	list = e.Evaluate(nil, nil, accounts, &Balance{Value: 40, Currency: schema.UAH})
+	assert.Empty(t, list)
+
+	// Week window slides
+	list = e.Evaluate(nil,
+		[]schema.Transaction{tran(12, -200, "Expenses:Fun")},
+		accounts, &Balance{Value: 200, Currency: schema.UAH})
+	assert.Empty(t, list)
+	list = e.Evaluate(nil,
+		[]schema.Transaction{tran(13, -200, "Expenses:Fun")},
+		accounts, &Balance{Value: 50, Currency: schema.UAH})
+	assert.Equal(t, []string{WeeklyLimit, LowBalance}, kinds(list))
+}
+
+func TestEvaluateDisabled(t *testing.T) {
+	e, err := New(&config.Config{})
+	require.NoError(t, err)
+	assert.Empty(t, e.Evaluate(
+		[]schema.Transaction{{SrcVal: -1e6, Dst: "SHOP KYIV UKR"}},
+		nil, nil, &Balance{}))
+	assert.NoError(t, e.Save())
+}
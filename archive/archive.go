@@ -0,0 +1,229 @@
+// Package archive keeps every fetched transaction together with
+// its raw XML, sorting outcome and export destination in an embedded
+// database (one bbolt file per card). Reports, searches and audits
+// query the archive instead of parsing exported files.
+package archive
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/crypt"
+	"github.com/tuxofil/p24fetch/schema"
+	"github.com/tuxofil/p24fetch/sorter"
+)
+
+// Bucket names
+var transactionsBucket = []byte("transactions")
+
+// How long to wait for the database used by another process
+const openTimeout = 10 * time.Second
+
+// Record is an archived transaction.
+type Record struct {
+	// Record ID, see ID()
+	ID string
+	// ID of the run the transaction was fetched in
+	RunID string
+	// Merchant name at the moment of fetching
+	Merchant string
+	// Card number
+	Card string
+	// Parsed transaction. Error and Raw are set when
+	// the transaction failed to parse.
+	Transaction schema.Transaction
+	// Transaction as received from the API
+	RawXML string `json:",omitempty"`
+	// Sorting outcome: sorted, unsorted or ignored
+	Outcome sorter.Outcome
+	// GnuCash Account ID for sorted transactions
+	Account string `json:",omitempty"`
+	// Pattern matched for sorted and ignored transactions
+	Rule string `json:",omitempty"`
+	// Path to the file the transaction was exported to
+	Export string `json:",omitempty"`
+	// When the record was written
+	Archived time.Time
+}
+
+// Archive is a transaction archive of a single card.
+type Archive struct {
+	db *bolt.DB
+	// Public keys to encrypt records with
+	recipients []string
+	// Private keys to decrypt records with
+	identities *crypt.Identities
+}
+
+// FileName returns path to the archive of the card. An archive
+// named by the card number by previous versions is renamed to it.
+func FileName(cfg *config.Config) (string, error) {
+	return cfg.StateFile(path.Join(cfg.ResultsDir, "archive"), ".db")
+}
+
+// Open the archive of the card, creating it when needed.
+func Open(cfg *config.Config) (*Archive, error) {
+	filePath, err := FileName(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(path.Dir(filePath), 0700); err != nil {
+		return nil, fmt.Errorf("create archive dir: %w", err)
+	}
+	db, err := bolt.Open(filePath, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(transactionsBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("create bucket: %w", err)
+	}
+	a := &Archive{
+		db:         db,
+		recipients: cfg.AgeRecipients,
+		identities: crypt.NewIdentities(cfg.AgeIdentity),
+	}
+	return a, nil
+}
+
+// Close the archive.
+func (a *Archive) Close() error {
+	return a.db.Close()
+}
+
+// Put writes records to the archive in a single transaction.
+// Records are encrypted when age recipients are configured.
+// Records with empty ID get one assigned with ID().
+// Records with the same ID are overwritten.
+func (a *Archive) Put(records []Record) error {
+	return a.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(transactionsBucket)
+		now := time.Now()
+		for _, record := range records {
+			if record.ID == "" {
+				record.ID = ID(record)
+			}
+			if record.Archived.IsZero() {
+				record.Archived = now
+			}
+			data, err := json.Marshal(record)
+			if err != nil {
+				return fmt.Errorf("encode record: %w", err)
+			}
+			if data, err = crypt.Encrypt(data, a.recipients); err != nil {
+				return err
+			}
+			if err := bucket.Put(key(record), data); err != nil {
+				return fmt.Errorf("put record: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// ForEach calls fn for every record in chronological order.
+// Iteration stops on the first error returned by fn.
+func (a *Archive) ForEach(fn func(Record) error) error {
+	return a.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(transactionsBucket).ForEach(func(k, v []byte) error {
+			v, err := a.identities.Decrypt(v)
+			if err != nil {
+				return fmt.Errorf("decrypt record %s: %w", k, err)
+			}
+			var record Record
+			if err := json.Unmarshal(v, &record); err != nil {
+				return fmt.Errorf("decode record %s: %w", k, err)
+			}
+			return fn(record)
+		})
+	})
+}
+
+// Load all records of the card. Returns nil when
+// the archive does not exist yet.
+func Load(cfg *config.Config) ([]Record, error) {
+	filePath, err := FileName(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return nil, nil
+	}
+	a, err := Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Close()
+	var res []Record
+	err = a.ForEach(func(record Record) error {
+		res = append(res, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Transactions returns transactions of the records
+// except ignored and failed to parse ones.
+func Transactions(records []Record) []schema.Transaction {
+	var res []schema.Transaction
+	for _, record := range records {
+		if record.Outcome != sorter.Ignored && record.Transaction.Raw == nil {
+			res = append(res, record.Transaction)
+		}
+	}
+	return res
+}
+
+// ID returns stable record ID: a hash of the raw XML or of the
+// transaction itself when no raw XML is known.
+func ID(record Record) string {
+	data := []byte(record.RawXML)
+	if len(data) == 0 {
+		data, _ = json.Marshal(record.Transaction)
+	}
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// NewRunID returns ID for a new run.
+func NewRunID() string {
+	return time.Now().UTC().Format("20060102T150405.000Z")
+}
+
+// XML encodes the transaction the way the API returns it.
+func XML(tran schema.XMLTransaction) string {
+	data, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"statement"`
+		schema.XMLTransaction
+	}{XMLTransaction: tran})
+	if err != nil {
+		panic(err)
+	}
+	return string(data)
+}
+
+// Database key of the record. Keys are ordered by
+// the transaction date.
+func key(record Record) []byte {
+	date := record.Transaction.Date
+	if record.Transaction.Raw != nil {
+		date = record.Archived
+	}
+	return []byte(date.UTC().Format("20060102T150405") + "-" + record.ID)
+}
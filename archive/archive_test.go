@@ -0,0 +1,100 @@
+package archive
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/schema"
+	"github.com/tuxofil/p24fetch/sorter"
+)
+
+func TestArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p24fetch-archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cfg := &config.Config{MerchantName: "card", CardNumber: "abcd", ResultsDir: dir}
+
+	records, err := Load(cfg)
+	require.NoError(t, err)
+	assert.Nil(t, records)
+
+	xmlTran := schema.XMLTransaction{
+		Card: "abcd", AppCode: "123", TranDate: "2020-09-20", TranTime: "12:00:00",
+		Amount: "10.00 UAH", CardAmount: "-10.00 UAH", Rest: "90.00 UAH",
+		Terminal: "Bakery", Description: "bread",
+	}
+	later := schema.ParseTransaction(xmlTran)
+	xmlTran.TranDate = "2020-09-19"
+	earlier := schema.ParseTransaction(xmlTran)
+	rawXML := XML(xmlTran)
+	assert.Equal(t, `<statement card="abcd" appcode="123" trandate="2020-09-19" `+
+		`trantime="12:00:00" amount="10.00 UAH" cardamount="-10.00 UAH" `+
+		`rest="90.00 UAH" terminal="Bakery" description="bread"></statement>`, rawXML)
+
+	a, err := Open(cfg)
+	require.NoError(t, err)
+	require.NoError(t, a.Put([]Record{
+		{RunID: "1", Transaction: later, Outcome: sorter.Unsorted},
+		{RunID: "1", Transaction: earlier, RawXML: rawXML, Outcome: sorter.Sorted,
+			Account: "Expenses:Food", Rule: "Bakery", Export: "abcd.qif"},
+	}))
+	// Records with the same ID are overwritten
+	require.NoError(t, a.Put([]Record{
+		{RunID: "2", Transaction: later, Outcome: sorter.Unsorted},
+	}))
+	require.NoError(t, a.Close())
+
+	records, err = Load(cfg)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "2020-09-19", records[0].Transaction.Date.Format("2006-01-02"))
+	assert.Equal(t, rawXML, records[0].RawXML)
+	assert.Equal(t, "Bakery", records[0].Rule)
+	assert.Equal(t, "abcd.qif", records[0].Export)
+	assert.Equal(t, "2", records[1].RunID)
+	assert.False(t, records[1].Archived.IsZero())
+	assert.Len(t, Transactions(records), 2)
+}
+
+func TestArchiveEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p24fetch-archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	id, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	identity := path.Join(dir, "identity.txt")
+	require.NoError(t, ioutil.WriteFile(identity, []byte(id.String()+"\n"), 0600))
+	cfg := &config.Config{MerchantName: "card", CardNumber: "abcd", ResultsDir: dir,
+		AgeRecipients: []string{id.Recipient().String()}, AgeIdentity: identity}
+
+	a, err := Open(cfg)
+	require.NoError(t, err)
+	tran := schema.Transaction{Date: time.Date(2020, 9, 20, 12, 0, 0, 0, time.UTC),
+		SrcVal: -10, SrcCur: schema.UAH, Dst: "Bakery"}
+	require.NoError(t, a.Put([]Record{{Transaction: tran, Outcome: sorter.Unsorted}}))
+	require.NoError(t, a.Close())
+
+	fileName, err := FileName(cfg)
+	require.NoError(t, err)
+	data, err := ioutil.ReadFile(fileName)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "Bakery")
+
+	records, err := Load(cfg)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "Bakery", records[0].Transaction.Dst)
+
+	// No identity
+	cfg.AgeIdentity = path.Join(dir, "missing.txt")
+	_, err = Load(cfg)
+	assert.Error(t, err)
+}
@@ -0,0 +1,237 @@
+// Package business fetches account statements from the
+// Privat24 for Business autoclient API.
+//
+// Transactions are converted to the form returned by the Privat24
+// merchant API, so they are deduplicated, sorted, exported and
+// archived the same way as transactions of personal cards.
+package business
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/schema"
+	"github.com/tuxofil/p24fetch/source"
+)
+
+// Autoclient API endpoint used when api_url is not configured
+const DefaultAPIURL = "https://acp.privatbank.ua/api/statements/transactions"
+
+// Transactions requested per page
+const pageLimit = 100
+
+// Status of successful responses
+const statusSuccess = "SUCCESS"
+
+// Transaction types
+const (
+	typeDebit  = "D"
+	typeCredit = "C"
+)
+
+// Format of transaction date and time
+const dateTimeFormat = "02.01.2006 15:04:05"
+
+// Transactions of the statement not marked as real
+// are not processed by the bank yet and may change
+const flagReal = "r"
+
+type Client struct {
+	// Configuration used to create the Client
+	config config.Config
+	// HTTP client
+	httpClient *http.Client
+}
+
+// Create new Client instance.
+func New(cfg *config.Config) (*Client, error) {
+	return &Client{
+		config: *cfg,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}, nil
+}
+
+// Page of the statement
+type response struct {
+	Status        string        `json:"status"`
+	Message       string        `json:"message"`
+	ExistNextPage bool          `json:"exist_next_page"`
+	NextPageID    string        `json:"next_page_id"`
+	Transactions  []transaction `json:"transactions"`
+}
+
+// Statement transaction. Only fields used are listed.
+type transaction struct {
+	// Transaction ID
+	ID string `json:"ID"`
+	// Date and time: DD.MM.YYYY HH:MM:SS
+	DateTime string `json:"DATE_TIME_DAT_OD_TIM_P"`
+	// Amount, always positive
+	Sum string `json:"SUM"`
+	// Currency
+	Currency string `json:"CCY"`
+	// Debit (D) or credit (C)
+	Type string `json:"TRANTYPE"`
+	// Real (r) or pending (i)
+	Real string `json:"FL_REAL"`
+	// Purpose of the payment
+	Purpose string `json:"OSND"`
+	// Counterparty name, EDRPOU and IBAN
+	CounterpartyName    string `json:"AUT_CNTR_NAM"`
+	CounterpartyCode    string `json:"AUT_CNTR_CRF"`
+	CounterpartyAccount string `json:"AUT_CNTR_ACC"`
+}
+
+// Identity of the API client. Requests are
+// rate limited per autoclient token.
+func (c *Client) Identity() string {
+	return "privat24_business:" + source.SecretKey(c.config.BusinessToken)
+}
+
+// Limits of requests to the autoclient API.
+func (c *Client) Limits() source.Limits {
+	return source.Limits{Interval: time.Second}
+}
+
+// Fetch transactions of the configured account made in the
+// period, the oldest first. Pending transactions are skipped
+// until processed by the bank. As transactions are deduplicated
+// by time, transactions made since the oldest pending one are
+// held back as well, so it is not dropped once processed.
+func (c *Client) Fetch(ctx context.Context, fromDate, toDate time.Time) ([]schema.XMLTransaction, error) {
+	query := url.Values{
+		"acc":       {c.config.CardNumber},
+		"startDate": {fromDate.Format("02-01-2006")},
+		"endDate":   {toDate.Format("02-01-2006")},
+		"limit":     {strconv.Itoa(pageLimit)},
+	}
+	var (
+		res []schema.XMLTransaction
+		// Date and time of the oldest pending transaction
+		pending string
+	)
+	for page := 1; ; page++ {
+		resp, err := c.fetchPage(ctx, query)
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", page, err)
+		}
+		for _, tran := range resp.Transactions {
+			if tran.Real != flagReal {
+				date, err := time.Parse(dateTimeFormat, tran.DateTime)
+				if err != nil {
+					return nil, fmt.Errorf("transaction %s: parse time: %w", tran.ID, err)
+				}
+				if key := date.Format("2006-01-0215:04:05"); pending == "" || key < pending {
+					pending = key
+				}
+				continue
+			}
+			xmlTran, err := tran.toXML(c.config.CardNumber)
+			if err != nil {
+				return nil, fmt.Errorf("transaction %s: %w", tran.ID, err)
+			}
+			res = append(res, xmlTran)
+		}
+		if !resp.ExistNextPage || resp.NextPageID == "" {
+			break
+		}
+		query.Set("followId", resp.NextPageID)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].TranDate+res[i].TranTime < res[j].TranDate+res[j].TranTime
+	})
+	if pending != "" {
+		for i, tran := range res {
+			if tran.TranDate+tran.TranTime >= pending {
+				c.config.Log().Infof("%d transactions are held back until "+
+					"the pending one is processed", len(res)-i)
+				res = res[:i]
+				break
+			}
+		}
+	}
+	c.config.Log().Debugf("API returned %d transactions", len(res))
+	return res, nil
+}
+
+// Fetch a page of the statement.
+func (c *Client) fetchPage(ctx context.Context, query url.Values) (*response, error) {
+	apiURL := c.config.APIURL
+	if apiURL == "" {
+		apiURL = DefaultAPIURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		apiURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "p24fetch")
+	req.Header.Set("Content-Type", "application/json;charset=utf-8")
+	req.Header.Set("token", c.config.BusinessToken)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("do request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	var parsed response
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("invalid response status: %s", resp.Status)
+		}
+		return nil, fmt.Errorf("parse json: %w", err)
+	}
+	if parsed.Status != statusSuccess {
+		if parsed.Message == "" {
+			parsed.Message = resp.Status
+		}
+		return nil, fmt.Errorf("API error: %s", parsed.Message)
+	}
+	return &parsed, nil
+}
+
+// Convert the transaction to the form of the merchant API.
+func (t transaction) toXML(account string) (schema.XMLTransaction, error) {
+	date, err := time.Parse(dateTimeFormat, t.DateTime)
+	if err != nil {
+		return schema.XMLTransaction{}, fmt.Errorf("parse time: %w", err)
+	}
+	sum := strings.TrimSpace(t.Sum)
+	if _, err := strconv.ParseFloat(sum, 64); err != nil {
+		return schema.XMLTransaction{}, fmt.Errorf("invalid sum: %#v", t.Sum)
+	}
+	cardSum := sum
+	switch t.Type {
+	case typeDebit:
+		cardSum = "-" + sum
+	case typeCredit:
+	default:
+		return schema.XMLTransaction{}, fmt.Errorf("invalid transaction type: %#v", t.Type)
+	}
+	return schema.XMLTransaction{
+		Card:                account,
+		AppCode:             t.ID,
+		TranDate:            date.Format("2006-01-02"),
+		TranTime:            date.Format("15:04:05"),
+		Amount:              sum + " " + t.Currency,
+		CardAmount:          cardSum + " " + t.Currency,
+		Terminal:            t.CounterpartyName,
+		Description:         t.Purpose,
+		CounterpartyCode:    t.CounterpartyCode,
+		CounterpartyAccount: t.CounterpartyAccount,
+	}, nil
+}
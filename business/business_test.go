@@ -0,0 +1,105 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/schema"
+	"github.com/tuxofil/p24fetch/source"
+)
+
+func TestFetchLog(t *testing.T) {
+	pages := map[string]string{
+		"": `{"status":"SUCCESS","exist_next_page":true,"next_page_id":"p2",
+			"transactions":[
+			{"ID":"2","DATE_TIME_DAT_OD_TIM_P":"02.03.2021 10:00:00","SUM":"100.50",
+			 "CCY":"UAH","TRANTYPE":"D","FL_REAL":"r","OSND":"Rent",
+			 "AUT_CNTR_NAM":"Landlord","AUT_CNTR_CRF":"12345678","AUT_CNTR_ACC":"UA01"},
+			{"ID":"3","DATE_TIME_DAT_OD_TIM_P":"03.03.2021 10:00:00","SUM":"5.00",
+			 "CCY":"UAH","TRANTYPE":"D","FL_REAL":"i","OSND":"Pending"},
+			{"ID":"4","DATE_TIME_DAT_OD_TIM_P":"04.03.2021 10:00:00","SUM":"7.00",
+			 "CCY":"UAH","TRANTYPE":"D","FL_REAL":"r","OSND":"After pending"}]}`,
+		"p2": `{"status":"SUCCESS","exist_next_page":false,
+			"transactions":[
+			{"ID":"1","DATE_TIME_DAT_OD_TIM_P":"01.03.2021 09:30:15","SUM":"2000",
+			 "CCY":"UAH","TRANTYPE":"C","FL_REAL":"r","OSND":"Invoice 1",
+			 "AUT_CNTR_NAM":"Customer"}]}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("token") != "secret" {
+			fmt.Fprint(w, `{"status":"ERROR","message":"invalid token"}`)
+			return
+		}
+		assert.Equal(t, "UA213223130000026007233566001", r.URL.Query().Get("acc"))
+		fmt.Fprint(w, pages[r.URL.Query().Get("followId")])
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		Source:        config.SourcePrivat24Business,
+		BusinessToken: "secret",
+		CardNumber:    "UA213223130000026007233566001",
+		Days:          30,
+		APIURL:        server.URL,
+	}
+	c, err := New(cfg)
+	require.NoError(t, err)
+	// Pending transactions and transactions made
+	// since the oldest pending one are skipped
+	trans, err := source.FetchLog(context.Background(), c, cfg.Days)
+	require.NoError(t, err)
+	require.Len(t, trans, 2)
+	assert.Equal(t, schema.XMLTransaction{
+		Card:        cfg.CardNumber,
+		AppCode:     "1",
+		TranDate:    "2021-03-01",
+		TranTime:    "09:30:15",
+		Amount:      "2000 UAH",
+		CardAmount:  "2000 UAH",
+		Terminal:    "Customer",
+		Description: "Invoice 1",
+	}, trans[0])
+	assert.Equal(t, "-100.50 UAH", trans[1].CardAmount)
+	assert.Equal(t, "12345678", trans[1].CounterpartyCode)
+	assert.Equal(t, "UA01", trans[1].CounterpartyAccount)
+
+	tran := schema.ParseTransaction(trans[1])
+	assert.Equal(t, float32(-100.5), tran.SrcVal)
+	assert.Equal(t, "Landlord", tran.Dst)
+	assert.Equal(t, "12345678", tran.DstCode)
+
+	// API errors are reported
+	cfg.BusinessToken = "invalid"
+	c, err = New(cfg)
+	require.NoError(t, err)
+	_, err = source.FetchLog(context.Background(), c, cfg.Days)
+	assert.EqualError(t, err, "page 1: API error: invalid token")
+}
+
+func TestToXML(t *testing.T) {
+	tests := []struct {
+		name string
+		tran transaction
+		err  string
+	}{
+		{"invalid time", transaction{DateTime: "2021-03-01", Sum: "1", Type: typeDebit},
+			`parse time: parsing time "2021-03-01" as "02.01.2006 15:04:05": cannot parse "21-03-01" as "."`},
+		{"invalid sum", transaction{DateTime: "01.03.2021 00:00:00", Sum: "x", Type: typeDebit},
+			`invalid sum: "x"`},
+		{"invalid type", transaction{DateTime: "01.03.2021 00:00:00", Sum: "1", Type: "X"},
+			`invalid transaction type: "X"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.tran.toXML("UA01")
+			assert.EqualError(t, err, test.err)
+		})
+	}
+}
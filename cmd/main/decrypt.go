@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/tuxofil/p24fetch/crypt"
+)
+
+// Decrypt writes content of files encrypted with age (exports,
+// state files, merchants.json) to stdout, e.g. for importing
+// exported transactions into GnuCash. Files not encrypted are
+// written as is.
+func Decrypt(args []string) error {
+	flags := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	identity := flags.String("i", "",
+		"identity file (default is $"+crypt.IdentityEnv+")")
+	output := flags.String("o", "", "write to the file instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("usage: p24fetch decrypt [-i IDENTITY] [-o FILE] FILE...")
+	}
+	ids := crypt.NewIdentities(*identity)
+	if *output == "" {
+		return decryptFiles(os.Stdout, ids, flags.Args())
+	}
+	fd, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("create output file: %w", err)
+	}
+	err = decryptFiles(fd, ids, flags.Args())
+	if cerr := fd.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("close output file: %w", cerr)
+	}
+	if err != nil {
+		// Do not leave partially written output behind
+		_ = os.Remove(*output)
+		return err
+	}
+	return nil
+}
+
+// Decrypt the files writing their content to w.
+func decryptFiles(w io.Writer, ids *crypt.Identities, files []string) error {
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if data, err = ids.Decrypt(data); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("write: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecryptOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p24fetch-main")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	first := path.Join(dir, "first.qif")
+	require.NoError(t, ioutil.WriteFile(first, []byte("!Type:Bank\n"), 0600))
+	second := path.Join(dir, "second.qif")
+	require.NoError(t, ioutil.WriteFile(second, []byte("^\n"), 0600))
+	output := path.Join(dir, "output.qif")
+
+	require.NoError(t, Decrypt([]string{"-o", output, first, second}))
+	data, err := ioutil.ReadFile(output)
+	require.NoError(t, err)
+	assert.Equal(t, "!Type:Bank\n^\n", string(data))
+
+	// Partial output is removed
+	err = Decrypt([]string{"-o", output, first, path.Join(dir, "missing.qif")})
+	require.Error(t, err)
+	_, err = os.Stat(output)
+	assert.True(t, os.IsNotExist(err), "partial output is left")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/statement"
+)
+
+// Import processes statements downloaded from Privat24 web
+// the same way as transactions fetched from the API.
+// Transactions are assigned to merchants by the card number,
+// or to the merchant given with -merchant.
+func Import(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	merchantName := flags.String("merchant", "",
+		"merchant to import transactions of (default is merchants matched by card numbers)")
+	wait := flags.Duration("wait", 0,
+		"how long to wait for a card locked by another run")
+	logOpts := addLogFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 2 {
+		return errors.New("usage: p24fetch import [-merchant NAME] [-wait DURATION] " +
+			"[-log-level LEVEL] [-log-format FORMAT] [-log-file FILE] merchants.json FILE...")
+	}
+	if err := logOpts.setup(); err != nil {
+		return err
+	}
+	configs, err := config.NewConfigs(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	if *merchantName != "" {
+		configs, err = findMerchant(configs, *merchantName)
+		if err != nil {
+			return err
+		}
+	}
+	stmt, err := statement.ReadAll(flags.Args()[1:])
+	if err != nil {
+		return fmt.Errorf("read statement: %w", err)
+	}
+
+	// Assign transactions to merchants by the card
+	// before anything is imported
+	cards := stmt.Cards()
+	var (
+		imports []*config.Config
+		sources []*statement.Source
+		// Merchant names by the last four digits of imported cards
+		matched = map[string]string{}
+	)
+	for _, cfg := range configs {
+		last4 := statement.Last4(cfg.CardNumber)
+		src := stmt.Source(last4, cfg.CardNumber, *merchantName != "")
+		if src.Len() == 0 {
+			continue
+		}
+		if other, ok := matched[last4]; ok {
+			return fmt.Errorf("cards of merchants %s and %s both end with %s, "+
+				"use -merchant to choose one", other, cfg.MerchantName, last4)
+		}
+		matched[last4] = cfg.MerchantName
+		delete(cards, last4)
+		if *merchantName != "" {
+			delete(cards, "")
+		}
+		// The config is shared, so modify a copy
+		importCfg := *cfg
+		importCfg.Days = src.Days()
+		imports = append(imports, &importCfg)
+		sources = append(sources, src)
+	}
+	if len(cards) > 0 {
+		var skipped []string
+		for last4, n := range cards {
+			card := "no card"
+			if last4 != "" {
+				card = "XXXX" + last4
+			}
+			skipped = append(skipped, fmt.Sprintf("%s (%d transactions)", card, n))
+		}
+		sort.Strings(skipped)
+		return fmt.Errorf("no merchant for %s", strings.Join(skipped, ", "))
+	}
+
+	summary := make(Summary, len(imports))
+	for i, cfg := range imports {
+		summary[i] = runMerchant(context.Background(), cfg, sources[i], *wait)
+	}
+	summary.Log()
+	return summary.Err()
+}
+
+// Find the merchant by name.
+func findMerchant(configs []*config.Config, name string) ([]*config.Config, error) {
+	for _, cfg := range configs {
+		if cfg.MerchantName == name {
+			return []*config.Config{cfg}, nil
+		}
+	}
+	return nil, fmt.Errorf("no merchant named %#v", name)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/tuxofil/p24fetch/conffile"
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/sorter"
+	"github.com/tuxofil/p24fetch/txn"
+	"github.com/tuxofil/p24fetch/wizard"
+)
+
+// Init interactively asks for settings of a merchant and writes
+// the merchants file and a starter rules file. The format of
+// the files is chosen by their extensions.
+func Init(args []string) error {
+	flags := flag.NewFlagSet("init", flag.ContinueOnError)
+	rulesPath := flags.String("rules", "",
+		"rules file to write (default is rules file of the same format next to merchants file)")
+	apiURL := flags.String("api-url", "",
+		"Privat24 API endpoint, e.g. a fake one for testing")
+	force := flags.Bool("force", false, "overwrite existing merchants file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: p24fetch init [-rules FILE] [-api-url URL] [-force] merchants.json")
+	}
+	path := flags.Arg(0)
+	if _, err := os.Stat(path); err == nil && !*force {
+		return fmt.Errorf("%s already exists, use -force to overwrite", path)
+	}
+	if *rulesPath == "" {
+		*rulesPath = filepath.Join(filepath.Dir(path), "rules"+filepath.Ext(path))
+	}
+
+	w := wizard.New(os.Stdin, os.Stdout)
+	cfg := &config.Config{RulesPath: *rulesPath, APIURL: *apiURL}
+	if err := w.Merchant(cfg); err != nil {
+		return err
+	}
+	if _, err := os.Stat(*rulesPath); os.IsNotExist(err) {
+		rules := wizard.StarterRules()
+		if err := w.Accounts(rules); err != nil {
+			return err
+		}
+		if err := sorter.WriteRules(*rulesPath, rules); err != nil {
+			return fmt.Errorf("write rules: %w", err)
+		}
+		fmt.Printf("Starter rules written to %s\n", *rulesPath)
+	} else {
+		fmt.Printf("Keeping existing rules file %s\n", *rulesPath)
+	}
+	doc, err := wizard.Document(cfg)
+	if err != nil {
+		return fmt.Errorf("build config: %w", err)
+	}
+	data, err := conffile.Encode(path, nil, doc)
+	if err != nil {
+		return fmt.Errorf("encode config: %w", err)
+	}
+	if err := txn.WriteFile(path, data); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	fmt.Printf("Configuration written to %s\n", path)
+
+	// Read the configuration back to resolve secret references
+	configs, err := config.NewConfigs(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	check, err := w.Confirm("Test credentials against the API?", true)
+	if err != nil || !check {
+		return err
+	}
+	src, err := newSource(configs[0])
+	if err != nil {
+		return fmt.Errorf("create source: %w", err)
+	}
+	if err := w.Check(context.Background(), src, configs[0].Days); err != nil {
+		return fmt.Errorf("check credentials: %w", err)
+	}
+	return nil
+}
+
+// Validate checks the merchants file and rules files of all
+// merchants and reports all problems found.
+func Validate(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: p24fetch validate merchants.json")
+	}
+	configs, err := config.NewConfigs(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	var problems conffile.Problems
+	for _, cfg := range configs {
+		if _, err := sorter.LoadRules(cfg); err != nil {
+			problems.Merge("", err)
+		}
+	}
+	if err := problems.Err(); err != nil {
+		return fmt.Errorf("read rules: %w", err)
+	}
+	fmt.Printf("Configuration is valid: %d merchants\n", len(configs))
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/logger"
+)
+
+// Logging options of a command
+type logOptions struct {
+	level  string
+	format string
+	file   string
+}
+
+// Add logging options to the command flags.
+func addLogFlags(flags *flag.FlagSet) *logOptions {
+	opts := &logOptions{}
+	flags.StringVar(&opts.level, "log-level", logger.Info.String(),
+		"log level: debug, info, warn or error")
+	flags.StringVar(&opts.format, "log-format", logger.Text,
+		"log format: text or json")
+	flags.StringVar(&opts.file, "log-file", "",
+		"append log to the file instead of stderr")
+	return opts
+}
+
+// Make the default logger write as configured. Secrets are
+// redacted. The log file stays open until the process exits.
+func (o *logOptions) setup() error {
+	level, err := logger.ParseLevel(o.level)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = os.Stderr
+	if o.file != "" {
+		fd, err := os.OpenFile(o.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return fmt.Errorf("open log file: %w", err)
+		}
+		w = fd
+	}
+	l, err := logger.New(config.RedactingWriter(w), o.format, level)
+	if err != nil {
+		return err
+	}
+	logger.SetDefault(l)
+	l.Infof("started")
+	return nil
+}
+
+// Make the default logger redact secrets before any command
+// runs, so commands with no logging options do not write
+// secrets resolved from the configuration either.
+func setupDefaultLog(w io.Writer) error {
+	l, err := logger.New(config.RedactingWriter(w), logger.Text, logger.Info)
+	if err != nil {
+		return err
+	}
+	logger.SetDefault(l)
+	return nil
+}
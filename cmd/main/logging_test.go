@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/logger"
+)
+
+func TestSetupDefaultLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p24fetch-main")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	rules := path.Join(dir, "rules.json")
+	require.NoError(t, ioutil.WriteFile(rules, nil, 0600))
+	configPath := path.Join(dir, "merchants.yaml")
+	require.NoError(t, ioutil.WriteFile(configPath, []byte(`merchants:
+  - merchant_name: main
+    merchant_id: 123
+    merchant_password: env:P24FETCH_TEST_PASSWORD
+    card_number: "4149000000001234"
+    card_key_secret: secret
+    days: 1
+    export_format: JSON
+    dedup_dir: `+path.Join(dir, "dedup")+`
+    results_dir: `+path.Join(dir, "results")+`
+    rules_path: `+rules+`
+`), 0600))
+	require.NoError(t, os.Setenv("P24FETCH_TEST_PASSWORD", "s3cr3t-password"))
+	defer os.Unsetenv("P24FETCH_TEST_PASSWORD")
+
+	defer logger.SetDefault(logger.Default())
+	var buf bytes.Buffer
+	require.NoError(t, setupDefaultLog(&buf))
+	configs, err := config.NewConfigs(configPath)
+	require.NoError(t, err)
+	logger.Default().Errorf("request failed: password=%s", configs[0].MerchantPassword)
+	assert.Contains(t, buf.String(), "request failed: password=")
+	assert.NotContains(t, buf.String(), "s3cr3t-password")
+}
@@ -2,119 +2,418 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
 	"os"
 	"path"
+	"sync"
 	"time"
 
+	"github.com/tuxofil/p24fetch/alerts"
+	"github.com/tuxofil/p24fetch/archive"
+	"github.com/tuxofil/p24fetch/business"
 	"github.com/tuxofil/p24fetch/config"
 	"github.com/tuxofil/p24fetch/dedup"
 	"github.com/tuxofil/p24fetch/exporter"
+	"github.com/tuxofil/p24fetch/lock"
+	"github.com/tuxofil/p24fetch/logger"
 	"github.com/tuxofil/p24fetch/merchant"
+	"github.com/tuxofil/p24fetch/monobank"
+	"github.com/tuxofil/p24fetch/notify"
 	"github.com/tuxofil/p24fetch/schema"
 	"github.com/tuxofil/p24fetch/slack"
 	"github.com/tuxofil/p24fetch/sorter"
+	"github.com/tuxofil/p24fetch/source"
+	"github.com/tuxofil/p24fetch/txn"
 )
 
 // Entry point
 func main() {
-	log.Println("started")
 	if err := Main(); err != nil {
-		log.Fatalf("%s", err)
+		logger.Default().Errorf("%s", err)
+		os.Exit(1)
 	}
-	log.Println("done")
+	logger.Default().Infof("done")
 }
 
 func Main() error {
-	configs, err := config.NewConfigs(os.Args[1])
+	if err := setupDefaultLog(os.Stderr); err != nil {
+		return err
+	}
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "serve":
+			return Serve(args[1:])
+		case "ui":
+			return UI(args[1:])
+		case "recurring":
+			return Recurring(args[1:])
+		case "report":
+			return Report(args[1:])
+		case "search":
+			return Search(args[1:])
+		case "decrypt":
+			return Decrypt(args[1:])
+		case "init":
+			return Init(args[1:])
+		case "validate":
+			return Validate(args[1:])
+		case "import":
+			return Import(args[1:])
+		}
+	}
+	return Fetch(args)
+}
+
+// Fetch processes all configured merchants once.
+func Fetch(args []string) error {
+	flags := flag.NewFlagSet("p24fetch", flag.ContinueOnError)
+	workers := flags.Int("workers", 4,
+		"number of merchants processed concurrently")
+	wait := flags.Duration("wait", 0,
+		"how long to wait for a card locked by another run")
+	logOpts := addLogFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || *workers < 1 {
+		return errors.New("usage: p24fetch [-workers N] [-wait DURATION] " +
+			"[-log-level LEVEL] [-log-format FORMAT] [-log-file FILE] merchants.json")
+	}
+	if err := logOpts.setup(); err != nil {
+		return err
+	}
+	configs, err := config.NewConfigs(flags.Arg(0))
 	if err != nil {
 		return fmt.Errorf("read config: %w", err)
 	}
-	for _, config := range configs {
-		if err := processMerchant(config); err != nil {
-			return fmt.Errorf("%s: %w", config.MerchantName, err)
-		}
-		time.Sleep(10 * time.Second)
+	summary := processMerchants(configs, *workers, func(cfg *config.Config) *Stats {
+		return runMerchant(context.Background(), cfg, nil, *wait)
+	})
+	summary.Log()
+	summary.Send(configs)
+	return summary.Err()
+}
+
+// Process merchants concurrently with a pool of workers running
+// the merchants with the run function. Requests to the API sharing
+// the same client identity are serialised by the source package itself.
+func processMerchants(configs []*config.Config, workers int, run func(*config.Config) *Stats) Summary {
+	summary := make(Summary, len(configs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				summary[i] = run(configs[i])
+			}
+		}()
 	}
-	return nil
+	for i := range configs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return summary
 }
 
-func processMerchant(cfg *config.Config) error {
-	log.Printf("processing: %s", cfg.MerchantName)
-	merchant, err := merchant.New(cfg)
+// Process the merchant and record the results to metrics.
+// Transactions are fetched from src, or from the API configured
+// for the merchant when src is nil. Wait is how long to wait
+// for the card lock held by another run.
+func runMerchant(ctx context.Context, cfg *config.Config, src source.Source, wait time.Duration) *Stats {
+	// The config is shared with other workers, so modify a copy
+	runID := archive.NewRunID()
+	runCfg := *cfg
+	runCfg.Logger = cfg.Log().With(logger.FieldRun, runID)
+	cfg = &runCfg
+	stats := &Stats{Merchant: cfg.MerchantName}
+	if err := processMerchant(ctx, cfg, src, runID, wait, stats); err != nil {
+		cfg.Log().Errorf("%s", err)
+		stats.Err = err
+	}
+	stats.Finished = time.Now()
+	appMetrics.Record(stats)
+	return stats
+}
+
+func processMerchant(ctx context.Context, cfg *config.Config, src source.Source, runID string, wait time.Duration, stats *Stats) error {
+	cfg.Log().Infof("processing")
+	fail := func(class string, err error) error {
+		stats.ErrClass = class
+		return err
+	}
+	if src == nil {
+		var err error
+		if src, err = newSource(cfg); err != nil {
+			return fail(errInit, fmt.Errorf("create source: %w", err))
+		}
+	}
+	unlock, err := lockCard(ctx, cfg, wait)
+	if err != nil {
+		return fail(errLocked, err)
+	}
+	defer unlock()
+	// Complete writes interrupted by a crash in the previous run
+	journal, err := cfg.StateFile(cfg.DedupDir, ".txn")
 	if err != nil {
-		return fmt.Errorf("create merchant: %w", err)
+		return fail(errInit, err)
+	}
+	if err := txn.Recover(journal); err != nil {
+		return fail(errInit, fmt.Errorf("recover interrupted run: %w", err))
 	}
 	dedup, err := dedup.New(cfg)
 	if err != nil {
-		return fmt.Errorf("create deduplicator: %w", err)
+		return fail(errInit, fmt.Errorf("create deduplicator: %w", err))
 	}
 	sorter, err := sorter.New(cfg)
 	if err != nil {
-		return fmt.Errorf("create sorter: %w", err)
+		return fail(errInit, fmt.Errorf("create sorter: %w", err))
 	}
-	slack, err := slack.New(cfg)
+	notifiers, err := newNotifiers(cfg)
+	if err != nil {
+		return fail(errInit, fmt.Errorf("create notifiers: %w", err))
+	}
+	alerter, err := alerts.New(cfg)
+	if err != nil {
+		return fail(errInit, fmt.Errorf("create alerts evaluator: %w", err))
+	}
+	archive, err := archive.Open(cfg)
 	if err != nil {
-		return fmt.Errorf("create Slack interface: %w", err)
+		return fail(errInit, fmt.Errorf("open archive: %w", err))
 	}
+	defer archive.Close()
 
-	ctx := context.TODO()
 	// Fetch transaction log
-	xmlTrans, err := merchant.FetchLog(ctx)
+	started := time.Now()
+	xmlTrans, err := source.FetchLog(ctx, src, cfg.Days)
+	stats.FetchDuration = time.Since(started)
 	if err != nil {
-		return fmt.Errorf("fetch log: %w", err)
-	} else if len(xmlTrans) == 0 {
-		log.Printf("no transactions found")
+		return fail(errFetch, fmt.Errorf("fetch log: %w", err))
+	}
+	stats.Fetched = len(xmlTrans)
+	if n := len(xmlTrans); n > 0 {
+		// The latest transaction is the last one
+		balance, currency, err := schema.ParseAmount(xmlTrans[n-1].Rest)
+		if err == nil {
+			stats.Balance, stats.BalanceCurrency = balance, currency
+		}
+	}
+	if len(xmlTrans) == 0 {
+		cfg.Log().Infof("no transactions found")
 		return nil
 	}
 
 	// Deduplicate
 	newTrans := dedup.Filter(xmlTrans)
+	stats.New = len(newTrans)
 	var lastTran schema.XMLTransaction
 	if len(newTrans) > 0 {
 		lastTran = newTrans[len(newTrans)-1]
 	} else {
-		log.Printf("fetched %d transactions but no new found", len(xmlTrans))
+		cfg.Log().Infof("fetched %d transactions but no new found", len(xmlTrans))
 		return nil
 	}
 
-	// Parse transactions
+	// Parse transactions. Card numbers are masked
+	// before transactions are exported or shown anywhere.
 	trans := make([]schema.Transaction, len(newTrans))
 	for i, tran := range newTrans {
-		trans[i] = schema.ParseTransaction(tran)
+		trans[i] = cfg.MaskTransaction(schema.ParseTransaction(tran))
 	}
 
 	// Sort transactions
 	ignoredTrans, sortedTrans, unsortedTrans := sorter.Sort(trans)
-	log.Printf("  sorted: %d; unsorted: %d; ignored: %d",
+	stats.Sorted = len(sortedTrans)
+	stats.Unsorted = len(unsortedTrans)
+	stats.Ignored = len(ignoredTrans)
+	cfg.Log().Infof("sorted: %d; unsorted: %d; ignored: %d",
 		len(sortedTrans), len(unsortedTrans), len(ignoredTrans))
 
+	// Exports, alerts state and deduplicator state are written
+	// together, so a failed or interrupted run exports nothing
+	// and the transactions are fetched again next time. The archive
+	// is written before the commit, as records of the same
+	// transactions are overwritten when they are fetched again.
+	tx := txn.Begin(journal)
+
 	// Export sorted transactions
-	if err := exporter.New(cfg).Export(sortedTrans); err != nil {
-		return fmt.Errorf("export sorted: %w", err)
+	sortedFile, err := exporter.New(cfg).WithTx(tx).ExportFile(sortedTrans)
+	if err != nil {
+		return fail(errExport, fmt.Errorf("export sorted: %w", err))
 	}
 
-	// Export ignored transaction as JSON
-	cfg.ExportFormat = schema.JSON
-	resultsDir := cfg.ResultsDir
-	cfg.ResultsDir = path.Join(resultsDir, "ignored")
-	if err := exporter.New(cfg).Export(ignoredTrans); err != nil {
-		return fmt.Errorf("export ignored: %w", err)
+	// Export ignored transaction as JSON.
+	// The config is shared with other workers, so modify a copy.
+	jsonCfg := *cfg
+	jsonCfg.ExportFormat = schema.JSON
+	jsonCfg.ResultsDir = path.Join(cfg.ResultsDir, "ignored")
+	ignoredFile, err := exporter.New(&jsonCfg).WithTx(tx).ExportFile(ignoredTrans)
+	if err != nil {
+		return fail(errExport, fmt.Errorf("export ignored: %w", err))
 	}
 
 	// Export unsorted transactions as JSON
-	cfg.ResultsDir = path.Join(resultsDir, "unsorted")
-	if err := exporter.New(cfg).Export(unsortedTrans); err != nil {
-		return fmt.Errorf("export unsorted: %w", err)
+	jsonCfg.ResultsDir = path.Join(cfg.ResultsDir, "unsorted")
+	unsortedFile, err := exporter.New(&jsonCfg).WithTx(tx).ExportFile(unsortedTrans)
+	if err != nil {
+		return fail(errExport, fmt.Errorf("export unsorted: %w", err))
 	}
 
-	// Send Slack notifications for unsorted transactions
-	slack.ReportUnsorted(unsortedTrans)
+	// Evaluate spending alerts
+	var balance *alerts.Balance
+	if stats.BalanceCurrency != "" {
+		balance = &alerts.Balance{Value: stats.Balance, Currency: stats.BalanceCurrency}
+	}
+	alertList := alerter.Evaluate(trans, sortedTrans, sorter.Accounts(), balance)
+	if err := alerter.WithTx(tx).Save(); err != nil {
+		return fail(errDedup, fmt.Errorf("save alerts state: %w", err))
+	}
 
 	// Update deduplicator state
-	if err := dedup.Update(lastTran); err != nil {
-		return fmt.Errorf("update dedup: %w", err)
+	if err := dedup.WithTx(tx).Update(lastTran); err != nil {
+		return fail(errDedup, fmt.Errorf("update dedup: %w", err))
+	}
+
+	// Archive transactions
+	err = archiveRun(archive, cfg, runID, sorter, newTrans, trans,
+		sortedFile, ignoredFile, unsortedFile)
+	if err != nil {
+		return fail(errExport, fmt.Errorf("archive: %w", err))
+	}
+	if err := tx.Commit(); err != nil {
+		return fail(errExport, fmt.Errorf("commit: %w", err))
+	}
+
+	// Send notifications for unsorted transactions
+	run := notify.Run{
+		Merchant: cfg.MerchantName,
+		Sorted:   sortedTrans,
+		Unsorted: unsortedTrans,
+		Ignored:  ignoredTrans,
+		Accounts: sorter.Accounts(),
+	}
+	for _, notifier := range notifiers {
+		if err := notifier.ReportRun(run); err != nil {
+			cfg.Log().Errorf("report to %s: %s", notifier.Name(), err)
+			stats.SlackFailures += slackFailures(err)
+		}
+	}
+
+	// Report recurring payments needing attention
+	if flagged, err := flaggedPayments(cfg, archive, trans); err != nil {
+		cfg.Log().Errorf("detect recurring payments: %s", err)
+	} else if len(flagged) > 0 {
+		message := formatFlagged(flagged)
+		cfg.Log().Warnf("%s", message)
+		for _, notifier := range notifiers {
+			if err := notifier.Send(message); err != nil {
+				cfg.Log().Errorf("report to %s: %s", notifier.Name(), err)
+				stats.SlackFailures += slackFailures(err)
+			}
+		}
+	}
+
+	// Report spending alerts
+	if len(alertList) > 0 {
+		for _, alert := range alertList {
+			cfg.Log().Warnf("alert: %s", alert.Message)
+		}
+		slack, err := slack.New(cfg)
+		if err == nil {
+			err = slack.ReportAlerts(alertList)
+		}
+		if err != nil {
+			cfg.Log().Errorf("report alerts to Slack: %s", err)
+			stats.SlackFailures += slackFailures(err)
+		}
 	}
 	return nil
 }
+
+// Lock the card against concurrent runs, including runs of
+// other processes, as they would export the same transactions
+// twice. Returns a function releasing the lock.
+func lockCard(ctx context.Context, cfg *config.Config, wait time.Duration) (func(), error) {
+	unlock, err := cfg.LockCard(ctx, wait)
+	if errors.Is(err, lock.ErrLocked) {
+		return nil, fmt.Errorf("%w; try again later or use -wait", err)
+	}
+	return unlock, err
+}
+
+// Archive transactions of the run. Files are paths
+// transactions were exported to by sorting outcome.
+func archiveRun(
+	a *archive.Archive,
+	cfg *config.Config,
+	runID string,
+	s *sorter.Sorter,
+	xmlTrans []schema.XMLTransaction,
+	trans []schema.Transaction,
+	sortedFile, ignoredFile, unsortedFile string,
+) error {
+	files := map[sorter.Outcome]string{
+		sorter.Sorted:   sortedFile,
+		sorter.Ignored:  ignoredFile,
+		sorter.Unsorted: unsortedFile,
+	}
+	records := make([]archive.Record, len(trans))
+	for i, tran := range trans {
+		res := s.Classify(tran)
+		record := archive.Record{
+			RunID:       runID,
+			Merchant:    cfg.MerchantName,
+			Card:        cfg.MaskedCard(),
+			Transaction: tran,
+			RawXML:      archive.XML(xmlTrans[i]),
+			Outcome:     res.Outcome,
+			Account:     res.Account,
+			Rule:        res.Rule,
+			Export:      files[res.Outcome],
+		}
+		// ID is computed from the card number to stay stable
+		// when the masking policy changes
+		record.ID = archive.ID(record)
+		masked := xmlTrans[i]
+		masked.Card = cfg.MaskCard(masked.Card)
+		record.RawXML = archive.XML(masked)
+		records[i] = record
+	}
+	return putRecords(a, records)
+}
+
+// Writes records to the archive. Replaced in tests.
+var putRecords = (*archive.Archive).Put
+
+// Create the source of transactions configured for the merchant.
+func newSource(cfg *config.Config) (source.Source, error) {
+	switch cfg.Source {
+	case config.SourcePrivat24Business:
+		return business.New(cfg)
+	case config.SourceMonobank:
+		return monobank.New(cfg)
+	default:
+		return merchant.New(cfg)
+	}
+}
+
+// Create all notifiers configured for the merchant.
+func newNotifiers(cfg *config.Config) ([]notify.Notifier, error) {
+	notifiers, err := notify.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	slack, err := slack.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("create Slack interface: %w", err)
+	}
+	if slack.IsActive() {
+		notifiers = append(notifiers, slack)
+	}
+	return notifiers, nil
+}
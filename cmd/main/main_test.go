@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tuxofil/p24fetch/archive"
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/schema"
+	"github.com/tuxofil/p24fetch/sorter"
+	"github.com/tuxofil/p24fetch/source"
+)
+
+type fakeSource struct {
+	trans []schema.XMLTransaction
+}
+
+func (s *fakeSource) Identity() string { return "fake" }
+
+func (s *fakeSource) Limits() source.Limits { return source.Limits{} }
+
+func (s *fakeSource) Fetch(ctx context.Context, from, to time.Time) ([]schema.XMLTransaction, error) {
+	return s.trans, nil
+}
+
+// Create configuration of a merchant storing files in the dir.
+func testConfig(t *testing.T, dir, name string) *config.Config {
+	cfg := &config.Config{
+		MerchantName:  name,
+		CardNumber:    "4149000000001234",
+		CardKeySecret: "secret",
+		DedupDir:      path.Join(dir, "dedup"),
+		ResultsDir:    path.Join(dir, "results"),
+		RulesPath:     path.Join(dir, "rules.json"),
+		ExportFormat:  schema.JSON,
+		Days:          30,
+	}
+	require.NoError(t, sorter.WriteRules(cfg.RulesPath, &sorter.Rules{
+		Accounts: map[string]string{"food": "Expenses:Food"},
+		Rules:    []map[string][]string{{"food": {"Silpo"}}},
+	}))
+	return cfg
+}
+
+func TestRunMerchantFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p24fetch-main")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cfg := testConfig(t, dir, "main")
+	src := &fakeSource{trans: []schema.XMLTransaction{
+		{Card: cfg.CardNumber, TranDate: "2021-03-01", TranTime: "10:00:00",
+			Amount: "10.00 UAH", CardAmount: "-10.00 UAH", Terminal: "Silpo"},
+		{Card: cfg.CardNumber, TranDate: "2021-03-02", TranTime: "10:00:00",
+			Amount: "20.00 UAH", CardAmount: "-20.00 UAH", Terminal: "Bakery"},
+	}}
+	stateFile, err := cfg.StateFile(cfg.DedupDir, ".json")
+	require.NoError(t, err)
+	assertNoState := func() {
+		_, err := os.Stat(stateFile)
+		assert.True(t, os.IsNotExist(err), "dedup state is written")
+	}
+
+	// Failed export
+	unsortedDir := path.Join(cfg.ResultsDir, "unsorted")
+	require.NoError(t, os.MkdirAll(cfg.ResultsDir, 0700))
+	require.NoError(t, ioutil.WriteFile(unsortedDir, nil, 0600))
+	stats := runMerchant(context.Background(), cfg, src, 0)
+	require.Error(t, stats.Err)
+	assert.Equal(t, errExport, stats.ErrClass)
+	assertNoState()
+	require.NoError(t, os.Remove(unsortedDir))
+
+	// Failed archive write
+	putRecords = func(*archive.Archive, []archive.Record) error {
+		return errors.New("disk full")
+	}
+	stats = runMerchant(context.Background(), cfg, src, 0)
+	putRecords = (*archive.Archive).Put
+	assert.EqualError(t, stats.Err, "archive: disk full")
+	assertNoState()
+	files, err := ioutil.ReadDir(cfg.ResultsDir)
+	require.NoError(t, err)
+	for _, file := range files {
+		assert.True(t, file.IsDir(), "%s is exported", file.Name())
+	}
+
+	// The next run processes the same transactions
+	stats = runMerchant(context.Background(), cfg, src, 0)
+	require.NoError(t, stats.Err)
+	assert.Equal(t, 2, stats.New)
+	assert.Equal(t, 1, stats.Sorted)
+	assert.FileExists(t, stateFile)
+	records, err := archive.Load(cfg)
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+}
+
+func TestProcessMerchants(t *testing.T) {
+	var configs []*config.Config
+	for i := 0; i < 6; i++ {
+		configs = append(configs, &config.Config{MerchantName: fmt.Sprintf("m%d", i)})
+	}
+	var (
+		mu           sync.Mutex
+		running, max int
+		processed    []string
+	)
+	summary := processMerchants(configs, 2, func(cfg *config.Config) *Stats {
+		mu.Lock()
+		running++
+		if running > max {
+			max = running
+		}
+		processed = append(processed, cfg.MerchantName)
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		stats := &Stats{Merchant: cfg.MerchantName}
+		if cfg.MerchantName == "m0" {
+			stats.Err = errors.New("expired password")
+		}
+		return stats
+	})
+
+	// Merchants after the failed one are processed
+	// with no more than two at a time
+	assert.Len(t, processed, len(configs))
+	assert.Equal(t, 2, max)
+	require.Len(t, summary, len(configs))
+	for i, stats := range summary {
+		assert.Equal(t, configs[i].MerchantName, stats.Merchant)
+	}
+	assert.Equal(t, 1, summary.Failed())
+	assert.EqualError(t, summary.Err(), "1 of 6 merchants failed")
+	assert.NoError(t, summary[1:].Err())
+}
+
+func TestFetch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p24fetch-main")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "valid" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errorDescription":"Unknown 'X-Token'"}`)
+			return
+		}
+		switch {
+		case r.URL.Path == "/personal/client-info":
+			fmt.Fprint(w, `{"accounts":[{"id":"uah1","currencyCode":980,"maskedPan":["537541******1234"]}]}`)
+		case strings.HasPrefix(r.URL.Path, "/personal/statement/"):
+			fmt.Fprintf(w, `[{"id":"a","time":%d,"description":"Silpo","amount":-1000,`+
+				`"operationAmount":-1000,"currencyCode":980,"balance":9000}]`, time.Now().Unix()-60)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	rules := path.Join(dir, "rules.json")
+	require.NoError(t, sorter.WriteRules(rules, &sorter.Rules{
+		Accounts: map[string]string{"food": "Expenses:Food"},
+		Rules:    []map[string][]string{{"food": {"Silpo"}}},
+	}))
+	configPath := path.Join(dir, "merchants.yaml")
+	require.NoError(t, ioutil.WriteFile(configPath, []byte(`defaults:
+  source: monobank
+  api_url: `+server.URL+`
+  days: 1
+  dedup_dir: `+path.Join(dir, "dedup")+`
+  results_dir: `+path.Join(dir, "results")+`
+  rules_path: `+rules+`
+  export_format: JSON
+merchants:
+  - merchant_name: expired
+    monobank_token: invalid
+    card_number: "5375410000005678"
+  - merchant_name: main
+    monobank_token: valid
+    card_number: "5375410000001234"
+`), 0600))
+
+	// A failed merchant fails the run
+	err = Fetch([]string{"-log-file", path.Join(dir, "p24fetch.log"), configPath})
+	assert.EqualError(t, err, "1 of 2 merchants failed")
+
+	// The other merchant is processed
+	exported, err := filepath.Glob(path.Join(dir, "results", "XXXX1234-*.json"))
+	require.NoError(t, err)
+	assert.Len(t, exported, 1)
+}
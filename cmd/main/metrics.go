@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/tuxofil/p24fetch/metrics"
+)
+
+// Application metrics exposed in the daemon mode.
+var appMetrics = newMetrics(metrics.NewRegistry())
+
+// Metrics is a set of p24fetch metrics.
+type Metrics struct {
+	registry      *metrics.Registry
+	fetchDuration metrics.Histogram
+	errors        metrics.Counter
+	transactions  metrics.Counter
+	lastSuccess   metrics.Gauge
+	balance       metrics.Gauge
+	slackFailures metrics.Counter
+}
+
+func newMetrics(r *metrics.Registry) *Metrics {
+	return &Metrics{
+		registry: r,
+		fetchDuration: r.NewHistogram("p24fetch_fetch_duration_seconds",
+			"Time spent fetching transactions from the API.",
+			metrics.DefBuckets, "merchant"),
+		errors: r.NewCounter("p24fetch_errors_total",
+			"Count of failed merchant runs by error class.",
+			"merchant", "class"),
+		transactions: r.NewCounter("p24fetch_transactions_total",
+			"Count of processed transactions by status.",
+			"merchant", "status"),
+		lastSuccess: r.NewGauge("p24fetch_last_success_timestamp_seconds",
+			"Unix time of the last successful merchant run.",
+			"merchant"),
+		balance: r.NewGauge("p24fetch_balance",
+			"Card balance reported with the latest transaction.",
+			"merchant", "currency"),
+		slackFailures: r.NewCounter("p24fetch_slack_failures_total",
+			"Count of Slack messages failed to deliver.",
+			"merchant"),
+	}
+}
+
+// Record updates metrics with the merchant run results.
+func (m *Metrics) Record(stats *Stats) {
+	name := stats.Merchant
+	if stats.FetchDuration > 0 {
+		m.fetchDuration.Observe(stats.FetchDuration.Seconds(), name)
+	}
+	m.transactions.Add(float64(stats.Fetched), name, "fetched")
+	m.transactions.Add(float64(stats.New), name, "new")
+	m.transactions.Add(float64(stats.Sorted), name, "sorted")
+	m.transactions.Add(float64(stats.Unsorted), name, "unsorted")
+	m.transactions.Add(float64(stats.Ignored), name, "ignored")
+	m.slackFailures.Add(float64(stats.SlackFailures), name)
+	if stats.BalanceCurrency != "" {
+		m.balance.Set(float64(stats.Balance), name, string(stats.BalanceCurrency))
+	}
+	if stats.Err != nil {
+		m.errors.Inc(name, stats.ErrClass)
+	} else {
+		m.lastSuccess.Set(float64(stats.Finished.Unix()), name)
+	}
+}
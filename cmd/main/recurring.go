@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tuxofil/p24fetch/archive"
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/recurring"
+	"github.com/tuxofil/p24fetch/schema"
+)
+
+// Recurring lists recurring payments detected in
+// the transaction archive of every merchant.
+func Recurring(args []string) error {
+	flags := flag.NewFlagSet("recurring", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: p24fetch recurring merchants.json")
+	}
+	configs, err := config.NewConfigs(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	for _, cfg := range configs {
+		records, err := archive.Load(cfg)
+		if err != nil {
+			return fmt.Errorf("%s: load archive: %w", cfg.MerchantName, err)
+		}
+		payments := recurring.Detect(archive.Transactions(records), time.Now())
+		fmt.Printf("%s: %d recurring payment(s)\n", cfg.MerchantName, len(payments))
+		if len(payments) > 0 {
+			fmt.Println(recurring.Format(payments))
+		}
+	}
+	return nil
+}
+
+// Detect recurring payments needing attention: overdue ones
+// and ones which price has changed with the new transactions.
+// Payments overdue for more than an interval are considered
+// cancelled and are not reported. Overdue payments are reported
+// once per expected charge.
+func flaggedPayments(cfg *config.Config, a *archive.Archive, newTrans []schema.Transaction) ([]recurring.Payment, error) {
+	alerted, err := recurring.LoadAlerted(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("load alerted payments: %w", err)
+	}
+	var records []archive.Record
+	err = a.ForEach(func(record archive.Record) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load archive: %w", err)
+	}
+	var since time.Time
+	for _, tran := range newTrans {
+		if tran.Raw == nil && (since.IsZero() || tran.Date.Before(since)) {
+			since = tran.Date
+		}
+	}
+	payments := recurring.Detect(archive.Transactions(records), time.Now())
+	for i, p := range payments {
+		payments[i].PriceChanged = p.PriceChanged && !since.IsZero() && !p.Last.Before(since)
+		payments[i].Missing = p.Missing && time.Since(p.Next) < p.Interval
+	}
+	res := alerted.Filter(payments)
+	if err := alerted.Save(); err != nil {
+		return nil, fmt.Errorf("save alerted payments: %w", err)
+	}
+	return res, nil
+}
+
+// Format recurring payments needing attention as a message.
+func formatFlagged(payments []recurring.Payment) string {
+	lines := []string{"Recurring payments need attention:"}
+	for _, p := range payments {
+		lines = append(lines, "* "+p.String())
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/tuxofil/p24fetch/archive"
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/report"
+)
+
+// Report writes the monthly spending report built from
+// the transaction archive of all merchants.
+func Report(args []string) error {
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+	format := flags.String("format", report.Text,
+		"output format: text, csv, json or html")
+	from := flags.String("from", "", "first month to report (YYYY-MM)")
+	to := flags.String("to", "", "last month to report (YYYY-MM)")
+	merchant := flags.String("merchant", "", "report on the merchant only")
+	output := flags.String("o", "", "write the report to the file instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: p24fetch report [-format FORMAT] " +
+			"[-from YYYY-MM] [-to YYYY-MM] [-merchant NAME] [-o FILE] merchants.json")
+	}
+	var fromMonth, toMonth time.Time
+	if *from != "" {
+		t, err := time.Parse("2006-01", *from)
+		if err != nil {
+			return fmt.Errorf("invalid -from: %w", err)
+		}
+		fromMonth = t
+	}
+	if *to != "" {
+		t, err := time.Parse("2006-01", *to)
+		if err != nil {
+			return fmt.Errorf("invalid -to: %w", err)
+		}
+		toMonth = t.AddDate(0, 1, 0)
+	}
+	configs, err := config.NewConfigs(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	var records []archive.Record
+	found := false
+	for _, cfg := range configs {
+		if *merchant != "" && cfg.MerchantName != *merchant {
+			continue
+		}
+		found = true
+		res, err := archive.Load(cfg)
+		if err != nil {
+			return fmt.Errorf("%s: load archive: %w", cfg.MerchantName, err)
+		}
+		records = append(records, res...)
+	}
+	if !found {
+		return fmt.Errorf("merchant not found: %#v", *merchant)
+	}
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		fd, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("create output file: %w", err)
+		}
+		defer fd.Close()
+		w = fd
+	}
+	if err := report.Build(records, fromMonth, toMonth).Write(w, *format); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tuxofil/p24fetch/archive"
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/search"
+	"github.com/tuxofil/p24fetch/sorter"
+)
+
+// Search prints archived transactions matching the query,
+// the latest first.
+func Search(args []string) error {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print results as JSON")
+	limit := flags.Int("limit", 50, "print at most N results, 0 means no limit")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 2 || *limit < 0 {
+		return errors.New("usage: p24fetch search [-json] [-limit N] merchants.json QUERY")
+	}
+	query, err := search.Parse(strings.Join(flags.Args()[1:], " "))
+	if err != nil {
+		return fmt.Errorf("parse query: %w", err)
+	}
+	configs, err := config.NewConfigs(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	var (
+		found []archive.Record
+		seen  = map[string]bool{}
+	)
+	for _, cfg := range configs {
+		// Merchants may share the card
+		if seen[cfg.CardNumber] {
+			continue
+		}
+		seen[cfg.CardNumber] = true
+		records, err := archive.Load(cfg)
+		if err != nil {
+			return fmt.Errorf("%s: load archive: %w", cfg.MerchantName, err)
+		}
+		for _, record := range records {
+			if query.Match(record) {
+				found = append(found, record)
+			}
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].Transaction.Date.After(found[j].Transaction.Date)
+	})
+	if *limit > 0 && len(found) > *limit {
+		found = found[:*limit]
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(found)
+	}
+	return writeRecords(os.Stdout, found)
+}
+
+// Write records as a text table.
+func writeRecords(w io.Writer, records []archive.Record) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "DATE\tCARD\t%10s\t\tPAYEE\tACCOUNT\tNOTE\n", "AMOUNT")
+	for _, record := range records {
+		tran := record.Transaction
+		// Records of previous versions have the card not masked
+		card := record.Card
+		if n := len(card); n > 4 && strings.Trim(card, "0123456789") == "" {
+			card = "XXXX" + card[n-4:]
+		}
+		if tran.Raw != nil {
+			fmt.Fprintf(tw, "%s %s\t%s\t\t\t\t\t%s\n", tran.Raw.TranDate,
+				tran.Raw.TranTime, card, tran.Error)
+			continue
+		}
+		account := record.Account
+		if record.Outcome != sorter.Sorted {
+			account = "(" + string(record.Outcome) + ")"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%10.2f\t%s\t%s\t%s\t%s\n",
+			tran.Date.Format("2006-01-02 15:04"), card, tran.SrcVal,
+			tran.SrcCur, tran.Dst, account, strings.ReplaceAll(tran.Note, "\n", " "))
+	}
+	return tw.Flush()
+}
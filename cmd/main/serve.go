@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/logger"
+	"github.com/tuxofil/p24fetch/review"
+	"github.com/tuxofil/p24fetch/schedule"
+	"github.com/tuxofil/p24fetch/slack"
+	"github.com/tuxofil/p24fetch/webui"
+)
+
+// Serve runs the tool in the daemon mode, processing every
+// merchant according to its schedule.
+// SIGHUP reloads the configuration, SIGTERM and SIGINT stop
+// the daemon after all running jobs are finished.
+func Serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	workers := flags.Int("workers", 4,
+		"number of merchants processed concurrently")
+	listen := flags.String("listen", "",
+		"address to serve /metrics and Slack interactions on, e.g. :9124")
+	uiListen := flags.String("ui-listen", "",
+		"loopback address to serve the web UI on, e.g. 127.0.0.1:8024")
+	wait := flags.Duration("wait", 0,
+		"how long to wait for a card locked by another run")
+	logOpts := addLogFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || *workers < 1 {
+		return errors.New("usage: p24fetch serve [-workers N] [-listen ADDR] [-ui-listen ADDR] [-wait DURATION] " +
+			"[-log-level LEVEL] [-log-format FORMAT] [-log-file FILE] merchants.json")
+	}
+	if *uiListen != "" {
+		if err := webui.CheckAddr(*uiListen); err != nil {
+			return err
+		}
+	}
+	if err := logOpts.setup(); err != nil {
+		return err
+	}
+	d := &daemon{
+		configPath: flags.Arg(0),
+		sem:        make(chan struct{}, *workers),
+		wait:       *wait,
+		busy:       map[string]bool{},
+		reviewer:   review.New(nil),
+	}
+	d.interactions = slack.NewInteractions(nil, d.reviewer)
+	if *listen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", appMetrics.registry)
+		mux.Handle("/slack/interactions", d.interactions)
+		defer serveHTTP(*listen, mux)()
+	}
+	// The web UI has no authentication, so it is never
+	// served on the public listener
+	if *uiListen != "" {
+		defer serveHTTP(*uiListen, webui.New(d.reviewer))()
+	}
+	return d.Run()
+}
+
+// Serve HTTP requests in background. Exits the process when
+// the server fails. Returns a function stopping the server.
+func serveHTTP(addr string, handler http.Handler) func() {
+	server := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		logger.Default().Infof("listening on %s", addr)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			logger.Default().Errorf("HTTP server: %s", err)
+			os.Exit(1)
+		}
+	}()
+	return func() { _ = server.Close() }
+}
+
+type daemon struct {
+	// Path to the merchants configuration file
+	configPath string
+	// Limits count of merchants processed simultaneously
+	sem chan struct{}
+	// How long to wait for a card locked by another process
+	wait time.Duration
+	// Guards the busy map
+	mu sync.Mutex
+	// Card numbers being processed at the moment
+	busy map[string]bool
+	// Tracks running jobs
+	jobs sync.WaitGroup
+	// Cancelled when the daemon is stopping, so jobs
+	// waiting for a worker are not started
+	shutdown context.Context
+	// Unsorted transactions manager used by the web UI
+	reviewer *review.Reviewer
+	// Slack interaction payloads handler
+	interactions *slack.Interactions
+}
+
+// Run schedules merchants processing until terminated.
+func (d *daemon) Run() error {
+	configs, err := d.readConfigs()
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	var stop context.CancelFunc
+	d.shutdown, stop = context.WithCancel(context.Background())
+	defer stop()
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		var loops sync.WaitGroup
+		for _, cfg := range configs {
+			loops.Add(1)
+			go func(cfg *config.Config) {
+				defer loops.Done()
+				d.loop(ctx, cfg)
+			}(cfg)
+		}
+		sig := <-signals
+		cancel()
+		loops.Wait()
+		if sig != syscall.SIGHUP {
+			logger.Default().Infof("got %s, waiting for running jobs", sig)
+			stop()
+			d.jobs.Wait()
+			d.interactions.Wait()
+			return nil
+		}
+		logger.Default().Infof("reloading configuration")
+		if newConfigs, err := d.readConfigs(); err != nil {
+			logger.Default().Errorf("reload config: %s; keeping the old one", err)
+		} else {
+			configs = newConfigs
+		}
+	}
+}
+
+// Read configuration and check every merchant has a schedule.
+func (d *daemon) readConfigs() ([]*config.Config, error) {
+	configs, err := config.NewConfigs(d.configPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, cfg := range configs {
+		if cfg.Schedule == "" {
+			return nil, fmt.Errorf("%s: no schedule", cfg.MerchantName)
+		}
+	}
+	d.reviewer.SetConfigs(configs)
+	d.interactions.SetConfigs(configs)
+	return configs, nil
+}
+
+// Start merchant processing according to the schedule
+// until the context is cancelled.
+func (d *daemon) loop(ctx context.Context, cfg *config.Config) {
+	sched, err := schedule.Parse(cfg.Schedule)
+	if err != nil {
+		// Must not happen as the config is validated
+		cfg.Log().Errorf("parse schedule: %s", err)
+		return
+	}
+	for {
+		next := sched.Next(time.Now())
+		if next.IsZero() {
+			cfg.Log().Warnf("schedule never fires")
+			return
+		}
+		cfg.Log().Infof("next run at %s", next.Format(time.RFC3339))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		d.start(cfg)
+	}
+}
+
+// Start merchant processing in background unless the previous
+// run for the same card is still in progress.
+// Runs waiting for a free worker are skipped when
+// the daemon is stopping.
+func (d *daemon) start(cfg *config.Config) {
+	d.mu.Lock()
+	if d.busy[cfg.CardNumber] {
+		d.mu.Unlock()
+		cfg.Log().Warnf("previous run is still in progress, skipping")
+		return
+	}
+	d.busy[cfg.CardNumber] = true
+	d.mu.Unlock()
+
+	d.jobs.Add(1)
+	go func() {
+		defer d.jobs.Done()
+		defer func() {
+			d.mu.Lock()
+			delete(d.busy, cfg.CardNumber)
+			d.mu.Unlock()
+		}()
+		select {
+		case d.sem <- struct{}{}:
+		case <-d.shutdown.Done():
+			cfg.Log().Infof("shutting down, run skipped")
+			return
+		}
+		defer func() { <-d.sem }()
+		if d.shutdown.Err() != nil {
+			cfg.Log().Infof("shutting down, run skipped")
+			return
+		}
+
+		summary := Summary{runMerchant(context.Background(), cfg, nil, d.wait)}
+		summary.Log()
+		summary.Send([]*config.Config{cfg})
+	}()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/logger"
+	"github.com/tuxofil/p24fetch/schema"
+	"github.com/tuxofil/p24fetch/slack"
+)
+
+// Stats holds results of a single merchant processing.
+type Stats struct {
+	// Merchant name as set in the configuration
+	Merchant string
+	// Count of transactions fetched from the API
+	Fetched int
+	// Count of transactions left after deduplication
+	New int
+	// Count of transactions mapped to accounts
+	Sorted int
+	// Count of transactions not mapped to any account
+	Unsorted int
+	// Count of ignored transactions
+	Ignored int
+	// Not nil when merchant processing failed
+	Err error
+	// Class of the error, one of err* constants
+	ErrClass string
+	// Time spent fetching transactions from the API
+	FetchDuration time.Duration
+	// Card balance reported with the latest transaction
+	Balance float32
+	// Card balance currency. Empty when balance is unknown.
+	BalanceCurrency schema.Currency
+	// Count of Slack messages failed to deliver
+	SlackFailures int
+	// Time when the processing was finished
+	Finished time.Time
+}
+
+// Error classes
+const (
+	errInit   = "init"
+	errFetch  = "fetch"
+	errExport = "export"
+	errDedup  = "dedup"
+	errLocked = "locked"
+)
+
+// Summary is a per-run report on all processed merchants.
+type Summary []*Stats
+
+// Failed returns count of failed merchants.
+func (s Summary) Failed() int {
+	var n int
+	for _, stats := range s {
+		if stats.Err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Err returns an error when some of merchants failed,
+// so the tool exits with non-zero code.
+func (s Summary) Err() error {
+	if n := s.Failed(); n > 0 {
+		return fmt.Errorf("%d of %d merchants failed", n, len(s))
+	}
+	return nil
+}
+
+// String formats the summary as a text table.
+func (s Summary) String() string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MERCHANT\tFETCHED\tNEW\tSORTED\tUNSORTED\tIGNORED\tERROR")
+	for _, stats := range s {
+		errStr := "-"
+		if stats.Err != nil {
+			errStr = config.Redact(stats.Err.Error())
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%s\n",
+			stats.Merchant, stats.Fetched, stats.New, stats.Sorted,
+			stats.Unsorted, stats.Ignored, errStr)
+	}
+	_ = w.Flush()
+	return buf.String()
+}
+
+// Log writes the summary to the log.
+func (s Summary) Log() {
+	logger.Default().Infof("summary:\n%s", s)
+	if n := s.Failed(); n > 0 {
+		logger.Default().Errorf("%d of %d merchants failed", n, len(s))
+	}
+}
+
+// Send delivers the summary to Slack channels of all merchants
+// having summary reporting enabled. Every channel receives
+// the summary only once, with merchants posting to the channel.
+func (s Summary) Send(configs []*config.Config) {
+	senders, summaries := s.byChannel(configs)
+	for i, cfg := range senders {
+		slack, err := slack.New(cfg)
+		if err != nil {
+			cfg.Log().Errorf("create Slack interface: %s", err)
+			continue
+		}
+		if err := slack.Sendf("p24fetch summary:\n```%s```", summaries[i]); err != nil {
+			cfg.Log().Errorf("send summary to Slack: %s", err)
+		}
+	}
+}
+
+// Split the summary by Slack channels of merchants having summary
+// reporting enabled. Returns configurations to send summaries with
+// and summaries of merchants posting to their channels.
+func (s Summary) byChannel(configs []*config.Config) ([]*config.Config, []Summary) {
+	channel := map[string]string{}
+	for _, cfg := range configs {
+		channel[cfg.MerchantName] = cfg.SlackToken + "/" + cfg.SlackChannel
+	}
+	var (
+		senders   []*config.Config
+		summaries []Summary
+		seen      = map[string]bool{}
+	)
+	for _, cfg := range configs {
+		key := channel[cfg.MerchantName]
+		if !cfg.SlackSummary || seen[key] {
+			continue
+		}
+		seen[key] = true
+		var summary Summary
+		for _, stats := range s {
+			if channel[stats.Merchant] == key {
+				summary = append(summary, stats)
+			}
+		}
+		if len(summary) > 0 {
+			senders = append(senders, cfg)
+			summaries = append(summaries, summary)
+		}
+	}
+	return senders, summaries
+}
+
+// Get count of undelivered messages from the Slack error.
+// Returns 0 for errors of other notifiers.
+func slackFailures(err error) int {
+	var derr *slack.DeliveryError
+	if errors.As(err, &derr) {
+		return derr.Failed
+	}
+	return 0
+}
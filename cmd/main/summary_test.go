@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tuxofil/p24fetch/config"
+)
+
+func TestSummaryByChannel(t *testing.T) {
+	configs := []*config.Config{
+		{MerchantName: "a", SlackToken: "t", SlackChannel: "family", SlackSummary: true},
+		{MerchantName: "b", SlackToken: "t", SlackChannel: "family", SlackSummary: true},
+		{MerchantName: "c", SlackToken: "t", SlackChannel: "work", SlackSummary: true},
+		{MerchantName: "d", SlackToken: "t", SlackChannel: "other"},
+	}
+	a := &Stats{Merchant: "a"}
+	b := &Stats{Merchant: "b"}
+	c := &Stats{Merchant: "c", Err: errors.New("failed")}
+	d := &Stats{Merchant: "d"}
+	senders, summaries := Summary{a, b, c, d}.byChannel(configs)
+	assert.Equal(t, []*config.Config{configs[0], configs[2]}, senders)
+	assert.Equal(t, []Summary{{a, b}, {c}}, summaries)
+}
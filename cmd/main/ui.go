@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/logger"
+	"github.com/tuxofil/p24fetch/review"
+	"github.com/tuxofil/p24fetch/webui"
+)
+
+// UI serves the web interface for reviewing unsorted transactions.
+func UI(args []string) error {
+	flags := flag.NewFlagSet("ui", flag.ContinueOnError)
+	listen := flags.String("listen", "127.0.0.1:8024",
+		"loopback address to serve the web UI on")
+	logOpts := addLogFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: p24fetch ui [-listen ADDR] " +
+			"[-log-level LEVEL] [-log-format FORMAT] [-log-file FILE] merchants.json")
+	}
+	if err := webui.CheckAddr(*listen); err != nil {
+		return err
+	}
+	if err := logOpts.setup(); err != nil {
+		return err
+	}
+	configs, err := config.NewConfigs(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	logger.Default().Infof("listening on http://%s/", *listen)
+	return http.ListenAndServe(*listen, webui.New(review.New(configs)))
+}
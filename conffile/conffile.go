@@ -0,0 +1,570 @@
+// Package conffile decodes configuration files written in JSON,
+// YAML or TOML and reports validation problems with their
+// locations in the file.
+//
+// All formats are decoded according to `json` struct tags, so
+// a single set of field names is used whatever the format is.
+// Fields are referred to by paths like "merchants[0].merchant_id".
+package conffile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"github.com/tuxofil/p24fetch/crypt"
+)
+
+// Supported formats
+const (
+	JSON = "json"
+	YAML = "yaml"
+	TOML = "toml"
+)
+
+// Format detects the file format by its extension.
+// Files with unknown extensions are JSON. The extension
+// of encrypted files (.age) is ignored.
+func Format(path string) string {
+	switch filepath.Ext(strings.TrimSuffix(path, crypt.Ext)) {
+	case ".yaml", ".yml":
+		return YAML
+	case ".toml":
+		return TOML
+	}
+	return JSON
+}
+
+// Decode the file data into v. The format is detected by
+// the file path. Returns line numbers of fields in the file.
+// Decoding errors are *Error when location is known.
+func Decode(path string, data []byte, v interface{}) (Positions, error) {
+	var pos Positions
+	switch Format(path) {
+	case JSON:
+		pos = jsonPositions(data)
+		if err := json.Unmarshal(data, v); err != nil {
+			return nil, jsonError(path, data, pos, err)
+		}
+		return pos, nil
+	case YAML:
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		pos = Positions{}
+		yamlPositions(&node, "", pos)
+		var generic interface{}
+		if err := node.Decode(&generic); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return pos, convert(path, pos, generic, v)
+	default:
+		var generic map[string]interface{}
+		if _, err := toml.Decode(string(data), &generic); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		pos = tomlPositions(data)
+		return pos, convert(path, pos, generic, v)
+	}
+}
+
+// Encode v in the format of the file. When old data of the file
+// is given, comments of YAML files are kept.
+func Encode(path string, old []byte, v interface{}) ([]byte, error) {
+	format := Format(path)
+	// Encode to JSON first to respect json tags
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if format == JSON {
+		return append(data, '\n'), nil
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if format == TOML {
+		if err := toml.NewEncoder(&buf).Encode(generic); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	if data, err = yaml.Marshal(generic); err != nil {
+		return nil, err
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	var oldNode yaml.Node
+	if len(old) > 0 && yaml.Unmarshal(old, &oldNode) == nil &&
+		oldNode.Kind == yaml.DocumentNode && len(oldNode.Content) > 0 {
+		copyComments(&oldNode, &node)
+	}
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode generic value decoded from YAML or TOML into v
+// through JSON, so json tags are used.
+func convert(path string, pos Positions, generic, v interface{}) error {
+	data, err := json.Marshal(normalize(generic))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		var terr *json.UnmarshalTypeError
+		if errors.As(err, &terr) {
+			return typeError(path, pos, terr, 0)
+		}
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Convert maps with non-string keys (allowed in YAML)
+// to maps with string keys.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			res[fmt.Sprint(key)] = normalize(value)
+		}
+		return res
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = normalize(value)
+		}
+		return v
+	case []interface{}:
+		for i, value := range v {
+			v[i] = normalize(value)
+		}
+		return v
+	case []map[string]interface{}:
+		res := make([]interface{}, len(v))
+		for i, value := range v {
+			res[i] = normalize(value)
+		}
+		return res
+	}
+	return v
+}
+
+// Convert JSON decoding error to a located one.
+func jsonError(path string, data []byte, pos Positions, err error) error {
+	var (
+		serr *json.SyntaxError
+		terr *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &serr):
+		return &Error{Problems: []Problem{{
+			File:    path,
+			Line:    lineAt(data, serr.Offset),
+			Message: serr.Error(),
+		}}}
+	case errors.As(err, &terr):
+		return typeError(path, pos, terr, lineAt(data, terr.Offset))
+	}
+	return fmt.Errorf("%s: %w", path, err)
+}
+
+// Convert type mismatch error to a located one.
+// The line is looked up by the field path when not known.
+func typeError(path string, pos Positions, err *json.UnmarshalTypeError, line int) error {
+	// Array indices are separate path elements in JSON errors
+	var field string
+	for _, name := range strings.Split(err.Field, ".") {
+		if _, e := strconv.Atoi(name); e == nil && field != "" {
+			name = "[" + name + "]"
+		}
+		field = Join(field, name)
+	}
+	if line == 0 {
+		line = pos.Line(field)
+	}
+	return &Error{Problems: []Problem{{
+		File:    path,
+		Line:    line,
+		Field:   field,
+		Message: fmt.Sprintf("expected %s, got %s", err.Type, err.Value),
+	}}}
+}
+
+// Positions maps field paths to line numbers.
+type Positions map[string]int
+
+// Has reports whether the field is present in the file.
+func (p Positions) Has(field string) bool {
+	_, ok := p[field]
+	return ok
+}
+
+// Line returns line number of the field or of its closest
+// parent present in the file. Returns 0 when unknown.
+func (p Positions) Line(field string) int {
+	for field != "" {
+		if line, ok := p[field]; ok {
+			return line
+		}
+		field = Parent(field)
+	}
+	return 0
+}
+
+// Join the field path with the field name.
+func Join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	if name == "" {
+		return path
+	}
+	if strings.HasPrefix(name, "[") {
+		return path + name
+	}
+	return path + "." + name
+}
+
+// Index returns path of the array element.
+func Index(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+// Parent returns path of the field parent.
+func Parent(field string) string {
+	i := strings.LastIndexAny(field, ".[")
+	if i < 0 {
+		return ""
+	}
+	return field[:i]
+}
+
+// Line number of the byte offset.
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// Collect positions of JSON fields.
+func jsonPositions(data []byte) Positions {
+	pos := Positions{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// Offset of the next token start
+	next := func() int64 {
+		off := dec.InputOffset()
+		for off < int64(len(data)) && strings.IndexByte(" \t\r\n,:", data[off]) >= 0 {
+			off++
+		}
+		return off
+	}
+	var walk func(path string) error
+	walk = func(path string) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				field := Join(path, fmt.Sprint(key))
+				pos[field] = lineAt(data, dec.InputOffset())
+				if err := walk(field); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				field := Index(path, i)
+				pos[field] = lineAt(data, next())
+				if err := walk(field); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		}
+		return err
+	}
+	// Syntax errors are reported by the decoder
+	_ = walk("")
+	return pos
+}
+
+// Collect positions of YAML fields.
+func yamlPositions(node *yaml.Node, path string, pos Positions) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			yamlPositions(child, path, pos)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			field := Join(path, node.Content[i].Value)
+			pos[field] = node.Content[i].Line
+			yamlPositions(node.Content[i+1], field, pos)
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			field := Index(path, i)
+			pos[field] = child.Line
+			yamlPositions(child, field, pos)
+		}
+	}
+}
+
+// Collect positions of TOML fields. Tables, arrays of tables
+// and keys are recognised; positions inside inline tables and
+// multi-line values are not collected.
+func tomlPositions(data []byte) Positions {
+	var (
+		pos = Positions{}
+		// Current table path
+		table string
+		// Resolved paths of array tables by name
+		arrays = map[string]string{}
+		// Count of elements of array tables by path
+		counts = map[string]int{}
+	)
+	// Resolve table name to the path
+	resolve := func(name string) string {
+		parts := strings.Split(name, ".")
+		var res string
+		for i, part := range parts {
+			res = Join(res, strings.Trim(strings.TrimSpace(part), `"'`))
+			if path, ok := arrays[strings.Join(parts[:i+1], ".")]; ok {
+				res = path
+			}
+		}
+		return res
+	}
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "[["):
+			name := strings.TrimSpace(strings.SplitN(line[2:], "]]", 2)[0])
+			base := strings.Trim(name[strings.LastIndex(name, ".")+1:], `"'`)
+			if i := strings.LastIndex(name, "."); i >= 0 {
+				base = Join(resolve(name[:i]), base)
+			}
+			table = Index(base, counts[base])
+			counts[base]++
+			for key := range arrays {
+				if strings.HasPrefix(key, name+".") {
+					delete(arrays, key)
+				}
+			}
+			arrays[name] = table
+			if !pos.Has(base) {
+				pos[base] = n + 1
+			}
+			pos[table] = n + 1
+		case strings.HasPrefix(line, "["):
+			table = resolve(strings.TrimSpace(strings.SplitN(line[1:], "]", 2)[0]))
+			pos[table] = n + 1
+		case line == "" || strings.HasPrefix(line, "#"):
+		default:
+			if i := strings.Index(line, "="); i > 0 {
+				key := strings.Trim(strings.TrimSpace(line[:i]), `"'`)
+				pos[Join(table, key)] = n + 1
+			}
+		}
+	}
+	return pos
+}
+
+// Copy comments from the old YAML node to the matching new one.
+// Mapping values are matched by keys, sequence items by index.
+func copyComments(old, node *yaml.Node) {
+	node.HeadComment = old.HeadComment
+	node.LineComment = old.LineComment
+	node.FootComment = old.FootComment
+	if old.Kind != node.Kind {
+		return
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		keys := map[string]int{}
+		for i := 0; i+1 < len(old.Content); i += 2 {
+			keys[old.Content[i].Value] = i
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if j, ok := keys[node.Content[i].Value]; ok {
+				copyComments(old.Content[j], node.Content[i])
+				copyComments(old.Content[j+1], node.Content[i+1])
+			}
+		}
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for i := 0; i < len(node.Content) && i < len(old.Content); i++ {
+			copyComments(old.Content[i], node.Content[i])
+		}
+	}
+}
+
+// Problem is a single problem found in a configuration file.
+type Problem struct {
+	// Path to the file. Empty when unknown.
+	File string
+	// Line number. Zero when unknown.
+	Line int
+	// Path of the field, e.g. "merchants[0].days".
+	// Empty for problems of the whole file.
+	Field string
+	// Description of the problem
+	Message string
+}
+
+func (p Problem) String() string {
+	var parts []string
+	if p.File != "" {
+		loc := p.File
+		if p.Line > 0 {
+			loc += fmt.Sprintf(":%d", p.Line)
+		}
+		parts = append(parts, loc)
+	}
+	if p.Field != "" {
+		parts = append(parts, p.Field)
+	}
+	return strings.Join(append(parts, p.Message), ": ")
+}
+
+// Error lists all problems found in a configuration.
+type Error struct {
+	Problems []Problem
+}
+
+func (e *Error) Error() string {
+	if len(e.Problems) == 1 {
+		return e.Problems[0].String()
+	}
+	lines := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		lines[i] = "\n  " + p.String()
+	}
+	return fmt.Sprintf("%d problems:%s", len(e.Problems), strings.Join(lines, ""))
+}
+
+// Locate sets the file and lines of problems of the error
+// when it is *Error. Field paths of problems are relative to
+// prefix; problems of fields missing under prefix are located
+// under fallback when the field is present there (e.g. the
+// value was inherited from defaults).
+func Locate(err error, file string, pos Positions, prefix, fallback string) error {
+	locs := []Location{{File: file, Pos: pos, Field: prefix}}
+	if fallback != "" {
+		locs = append(locs, Location{File: file, Pos: pos, Field: fallback})
+	}
+	return LocateAny(err, locs)
+}
+
+// Location is a section of a configuration file.
+type Location struct {
+	// Path to the file
+	File string
+	// Line numbers of fields in the file
+	Pos Positions
+	// Path of the section, e.g. "merchants[0]"
+	Field string
+}
+
+// LocateAny is like Locate, but looks fields up in several
+// sections, possibly of different files, in order. Problems
+// of fields present in none of them are located in the first.
+func LocateAny(err error, locs []Location) error {
+	var cerr *Error
+	if !errors.As(err, &cerr) || len(locs) == 0 {
+		return err
+	}
+	res := &Error{Problems: make([]Problem, len(cerr.Problems))}
+	for i, p := range cerr.Problems {
+		loc := locs[0]
+		if p.Field != "" {
+			for _, l := range locs {
+				if l.Pos.Has(Join(l.Field, p.Field)) {
+					loc = l
+					break
+				}
+			}
+		}
+		field := Join(loc.Field, p.Field)
+		p.File, p.Line, p.Field = loc.File, loc.Pos.Line(field), field
+		res.Problems[i] = p
+	}
+	return res
+}
+
+// Problems collects validation problems.
+type Problems []Problem
+
+// Add a problem of the field.
+func (p *Problems) Add(field, format string, args ...interface{}) {
+	*p = append(*p, Problem{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Merge problems of the error with field paths prefixed.
+// Errors of other types are added as a problem of the prefix.
+func (p *Problems) Merge(prefix string, err error) {
+	if err == nil {
+		return
+	}
+	var cerr *Error
+	if !errors.As(err, &cerr) {
+		p.Add(prefix, "%s", err)
+		return
+	}
+	for _, problem := range cerr.Problems {
+		problem.Field = Join(prefix, problem.Field)
+		*p = append(*p, problem)
+	}
+}
+
+// Err returns *Error with the problems sorted by location,
+// or nil when there are no problems. Duplicates (e.g. problems
+// of defaults shared by several entries) are reported once.
+func (p Problems) Err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	sorted := make([]Problem, len(p))
+	copy(sorted, p)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].File != sorted[j].File {
+			return sorted[i].File < sorted[j].File
+		}
+		return sorted[i].Line < sorted[j].Line
+	})
+	res := sorted[:1]
+	for _, problem := range sorted[1:] {
+		if problem != res[len(res)-1] {
+			res = append(res, problem)
+		}
+	}
+	return &Error{Problems: res}
+}
@@ -0,0 +1,155 @@
+package conffile
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testEntry struct {
+	Name  string   `json:"name"`
+	Days  int      `json:"days"`
+	Tags  []string `json:"tags"`
+	Inner struct {
+		Flag bool `json:"flag"`
+	} `json:"inner"`
+}
+
+type testFile struct {
+	Entries  []testEntry `json:"entries"`
+	Defaults testEntry   `json:"defaults"`
+}
+
+var testFiles = map[string]string{
+	"test.json": `{
+  "defaults": {"days": 7},
+  "entries": [
+    {
+      "name": "first",
+      "tags": ["a", "b"]
+    },
+    {
+      "name": "second",
+      "days": 3,
+      "inner": {"flag": true}
+    }
+  ]
+}
+`,
+	"test.yaml": `# comment
+defaults:
+  days: 7
+entries:
+  - name: first
+    tags: [a, b]
+  - name: second
+    days: 3
+    inner:
+      flag: true
+`,
+	"test.toml": `# comment
+[defaults]
+days = 7
+
+[[entries]]
+name = "first"
+tags = ["a", "b"]
+
+[[entries]]
+name = "second"
+days = 3
+[entries.inner]
+flag = true
+`,
+}
+
+func TestDecode(t *testing.T) {
+	lines := map[string]map[string]int{
+		"test.json": {"defaults.days": 2, "entries[0]": 4, "entries[0].tags": 6,
+			"entries[1].days": 10, "entries[1].inner.flag": 11},
+		"test.yaml": {"defaults.days": 3, "entries[0]": 5, "entries[0].tags": 6,
+			"entries[1].days": 8, "entries[1].inner.flag": 10},
+		"test.toml": {"defaults.days": 3, "entries[0]": 5, "entries[0].tags": 7,
+			"entries[1].days": 11, "entries[1].inner.flag": 13},
+	}
+	for name, data := range testFiles {
+		var f testFile
+		pos, err := Decode(name, []byte(data), &f)
+		require.NoError(t, err, name)
+		assert.Equal(t, 7, f.Defaults.Days, name)
+		require.Len(t, f.Entries, 2, name)
+		assert.Equal(t, []string{"a", "b"}, f.Entries[0].Tags, name)
+		assert.Equal(t, 3, f.Entries[1].Days, name)
+		assert.True(t, f.Entries[1].Inner.Flag, name)
+		for field, line := range lines[name] {
+			assert.Equal(t, line, pos.Line(field), "%s: %s", name, field)
+		}
+		// Missing fields are located by the parent
+		assert.Equal(t, lines[name]["entries[0]"], pos.Line("entries[0].days"), name)
+		assert.False(t, pos.Has("entries[0].days"), name)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	var f testFile
+	_, err := Decode("bad.json", []byte("{\n  \"entries\": [\n    {\"days\": \"many\"}]}"), &f)
+	var cerr *Error
+	require.True(t, errors.As(err, &cerr), err)
+	assert.Equal(t, 3, cerr.Problems[0].Line)
+	assert.Contains(t, err.Error(), "bad.json:3: entries[0].days: expected int, got string")
+
+	_, err = Decode("bad.json", []byte("{\n  \"entries\": [,]}"), &f)
+	assert.Contains(t, err.Error(), "bad.json:2: invalid character")
+
+	_, err = Decode("bad.yaml", []byte("defaults:\n  days: many\n"), &f)
+	assert.Contains(t, err.Error(), "bad.yaml:2: defaults.days: expected int, got string")
+
+	_, err = Decode("bad.toml", []byte("[defaults\n"), &f)
+	assert.Error(t, err)
+}
+
+func TestLocate(t *testing.T) {
+	var f testFile
+	pos, err := Decode("test.yaml", []byte(testFiles["test.yaml"]), &f)
+	require.NoError(t, err)
+
+	var problems Problems
+	problems.Add("days", "invalid days")
+	problems.Add("name", "invalid name")
+	problems.Add("", "broken entry")
+	err = Locate(problems.Err(), "test.yaml", pos, "entries[0]", "defaults")
+	assert.Equal(t, "3 problems:\n"+
+		"  test.yaml:3: defaults.days: invalid days\n"+
+		"  test.yaml:5: entries[0].name: invalid name\n"+
+		"  test.yaml:5: entries[0]: broken entry", err.Error())
+
+	var merged Problems
+	merged.Merge("", err)
+	merged.Merge("other", errors.New("plain error"))
+	assert.Len(t, merged, 4)
+	assert.Equal(t, "other: plain error", merged[3].String())
+	assert.Nil(t, Problems{}.Err())
+}
+
+func TestEncode(t *testing.T) {
+	var f testFile
+	_, err := Decode("test.yaml", []byte(testFiles["test.yaml"]), &f)
+	require.NoError(t, err)
+	f.Entries[0].Tags = append(f.Entries[0].Tags, "c")
+
+	for name, old := range testFiles {
+		data, err := Encode(name, []byte(old), f)
+		require.NoError(t, err, name)
+		var decoded testFile
+		_, err = Decode(name, data, &decoded)
+		require.NoError(t, err, name)
+		assert.Equal(t, f, decoded, name)
+	}
+
+	// YAML comments are kept
+	data, err := Encode("test.yaml", []byte(testFiles["test.yaml"]), f)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "# comment\n")
+}
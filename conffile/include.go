@@ -0,0 +1,84 @@
+package conffile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Includes returns paths of files included by the file with
+// the "include" setting. Patterns are relative to the directory
+// of the file and may contain glob wildcards. Patterns without
+// wildcards must name existing files. Problems are *Error
+// located in the file.
+func Includes(file string, pos Positions, include []string) ([]string, error) {
+	var (
+		paths    []string
+		problems Problems
+	)
+	for i, pattern := range include {
+		field := Index("include", i)
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(file), pattern)
+		}
+		if !strings.ContainsAny(pattern, `*?[`) {
+			if _, err := os.Stat(pattern); err != nil {
+				problems.Add(field, "invalid include: %s", err)
+				continue
+			}
+			paths = append(paths, pattern)
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			problems.Add(field, "invalid include pattern %#v: %s", include[i], err)
+			continue
+		}
+		paths = append(paths, matches...)
+	}
+	if err := Locate(problems.Err(), file, pos, "", ""); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// Includer tracks files being read to detect include cycles
+// and files included more than once.
+type Includer struct {
+	// Files being read, the outermost first
+	stack []string
+	// All files read
+	seen map[string]bool
+}
+
+// Enter marks the file as being read. Returns false when the
+// file has already been read and should be skipped. Include
+// cycles are reported as errors. Leave must be called when
+// the file and files included by it are read.
+func (inc *Includer) Enter(file string) (bool, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return false, err
+	}
+	for i, f := range inc.stack {
+		if f == abs {
+			cycle := append(append([]string{}, inc.stack[i:]...), abs)
+			return false, fmt.Errorf("include cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	if inc.seen[abs] {
+		return false, nil
+	}
+	if inc.seen == nil {
+		inc.seen = map[string]bool{}
+	}
+	inc.seen[abs] = true
+	inc.stack = append(inc.stack, abs)
+	return true, nil
+}
+
+// Leave marks the last file entered as read.
+func (inc *Includer) Leave() {
+	inc.stack = inc.stack[:len(inc.stack)-1]
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"github.com/tuxofil/p24fetch/conffile"
+)
+
+// AlertsConfig configures spending alerts evaluated after
+// transactions are sorted.
+type AlertsConfig struct {
+	// Alert on a single transaction spending more than this
+	// amount (in the card currency). Zero disables the check.
+	MaxAmount float32 `json:"max_amount"`
+	// Alert when daily spend for an account exceeds the limit.
+	// Mapping: account ShortID or GnuCash Account ID -> limit.
+	DailyLimits map[string]float32 `json:"daily_limits"`
+	// Alert when spend for an account during the last 7 days
+	// exceeds the limit.
+	// Mapping: account ShortID or GnuCash Account ID -> limit.
+	WeeklyLimits map[string]float32 `json:"weekly_limits"`
+	// Alert on transactions from countries not seen before.
+	NewCountries bool `json:"new_countries"`
+	// Alert on transactions from terminals not seen before.
+	UnknownTerminals bool `json:"unknown_terminals"`
+	// Alert when the card balance drops below the value.
+	// Not set disables the check.
+	MinBalance *float32 `json:"min_balance"`
+}
+
+// Validate checks values of the alerts configuration.
+// Returns *conffile.Error listing all problems found.
+func (a *AlertsConfig) Validate() error {
+	var problems conffile.Problems
+	if a.MaxAmount < 0 {
+		problems.Add("max_amount", "invalid max amount: %v", a.MaxAmount)
+	}
+	for account, limit := range a.DailyLimits {
+		if limit <= 0 {
+			problems.Add(conffile.Join("daily_limits", account), "invalid daily limit: %v", limit)
+		}
+	}
+	for account, limit := range a.WeeklyLimits {
+		if limit <= 0 {
+			problems.Add(conffile.Join("weekly_limits", account), "invalid weekly limit: %v", limit)
+		}
+	}
+	if a.MinBalance != nil && *a.MinBalance < 0 {
+		problems.Add("min_balance", "invalid min balance")
+	}
+	return problems.Err()
+}
@@ -0,0 +1,191 @@
+package config
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/tuxofil/p24fetch/conffile"
+	"github.com/tuxofil/p24fetch/lock"
+	"github.com/tuxofil/p24fetch/schema"
+	"github.com/tuxofil/p24fetch/txn"
+)
+
+// Card masking policies, see Config.CardMask
+const (
+	// Last 4 digits: XXXX1234
+	CardMaskLast4 = "last4"
+	// Keyed hash of the card number: card-0123456789abcdef
+	CardMaskHash = "hash"
+	// User-defined alias, see Config.CardAlias
+	CardMaskAlias = "alias"
+)
+
+// Characters not allowed in card aliases as they
+// are used in file names and glob patterns
+const aliasForbidden = `/\*?[`
+
+// MaskedCard returns the card number of the merchant masked
+// according to the masking policy.
+func (c *Config) MaskedCard() string {
+	return c.MaskCard(c.CardNumber)
+}
+
+// MaskCard masks the card number according to the masking
+// policy. The alias applies to the card of the merchant only,
+// other cards are masked to last 4 digits.
+func (c *Config) MaskCard(card string) string {
+	if card == "" {
+		return ""
+	}
+	switch c.CardMask {
+	case CardMaskHash:
+		return c.cardHash(card)
+	case CardMaskAlias:
+		if card == c.CardNumber && c.CardAlias != "" {
+			return c.CardAlias
+		}
+	}
+	if len(card) <= 4 {
+		return "XXXX"
+	}
+	return "XXXX" + card[len(card)-4:]
+}
+
+// MaskTransaction returns the transaction with
+// card numbers masked.
+func (c *Config) MaskTransaction(tran schema.Transaction) schema.Transaction {
+	tran.Src = c.MaskCard(tran.Src)
+	if tran.Raw != nil {
+		raw := *tran.Raw
+		raw.Card = c.MaskCard(raw.Card)
+		tran.Raw = &raw
+	}
+	return tran
+}
+
+// CardKey returns a stable identifier of the card used to name
+// state files. It does not depend on the masking policy. The key
+// is an HMAC of the card number keyed with CardKeySecret, so the
+// card number can't be recovered from it without the secret.
+func (c *Config) CardKey() string {
+	return c.cardHash(c.CardNumber)
+}
+
+// StateFile returns path to the file of the card in dir named
+// by CardKey with the suffix. A file named by the card number,
+// as written by previous versions, is renamed to it.
+func (c *Config) StateFile(dir, suffix string) (string, error) {
+	filePath := path.Join(dir, c.CardKey()+suffix)
+	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+		return filePath, nil
+	}
+	legacy := path.Join(dir, c.CardNumber+suffix)
+	if err := os.Rename(legacy, filePath); err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("rename legacy state file: %w", err)
+	}
+	return filePath, nil
+}
+
+// LockCard locks the card against concurrent writers of its
+// files, including other processes, waiting for a busy lock up
+// to wait. The error wraps lock.ErrLocked when the lock is busy.
+// Returns a function releasing the lock.
+func (c *Config) LockCard(ctx context.Context, wait time.Duration) (func(), error) {
+	if err := os.MkdirAll(c.DedupDir, 0700); err != nil {
+		return nil, fmt.Errorf("create dedup dir: %w", err)
+	}
+	lockFile, err := c.StateFile(c.DedupDir, ".lock")
+	if err != nil {
+		return nil, fmt.Errorf("lock card: %w", err)
+	}
+	l, err := lock.Acquire(ctx, lockFile, wait)
+	if errors.Is(err, lock.ErrLocked) {
+		return nil, fmt.Errorf("card is being processed by another run (%w)", err)
+	} else if err != nil {
+		return nil, fmt.Errorf("lock card: %w", err)
+	}
+	if l.Stale != nil {
+		c.Log().Warnf("took over stale lock of %s", l.Stale)
+	}
+	return func() {
+		if err := l.Release(); err != nil {
+			c.Log().Errorf("release lock: %s", err)
+		}
+	}, nil
+}
+
+func (c *Config) cardHash(card string) string {
+	mac := hmac.New(sha256.New, []byte(c.CardKeySecret))
+	_, _ = mac.Write([]byte(card))
+	return "card-" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// Name of the file in dedup_dir with the generated card key secret
+const cardKeySecretFile = "card-key.secret"
+
+// Load the card key secret from dedup_dir unless configured.
+// The secret is generated on the first run.
+func (c *Config) loadCardKeySecret() error {
+	if c.CardKeySecret != "" {
+		return nil
+	}
+	filePath := path.Join(c.DedupDir, cardKeySecretFile)
+	data, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		data, err = createCardKeySecret(filePath)
+	}
+	if err != nil {
+		return fmt.Errorf("read card key secret: %w", err)
+	}
+	c.CardKeySecret = strings.TrimSpace(string(data))
+	if c.CardKeySecret == "" {
+		return fmt.Errorf("empty card key secret: %s", filePath)
+	}
+	return nil
+}
+
+// Write a random secret to the file. The file is created complete,
+// so other processes never read it partially written. If the file
+// is created meanwhile by another process, its secret is returned.
+func createCardKeySecret(filePath string) ([]byte, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	data := []byte(hex.EncodeToString(secret) + "\n")
+	if err := os.MkdirAll(path.Dir(filePath), 0700); err != nil {
+		return nil, err
+	}
+	if err := txn.CreateFile(filePath, data); os.IsExist(err) {
+		return ioutil.ReadFile(filePath)
+	} else if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Validate the masking policy settings.
+func (c *Config) validateCardMask(problems *conffile.Problems) {
+	switch c.CardMask {
+	case "", CardMaskLast4, CardMaskHash:
+	case CardMaskAlias:
+		if c.CardAlias == "" {
+			problems.Add("card_alias", "no card alias")
+		}
+	default:
+		problems.Add("card_mask", "invalid card mask: %s", c.CardMask)
+	}
+	if strings.ContainsAny(c.CardAlias, aliasForbidden) || strings.HasPrefix(c.CardAlias, ".") {
+		problems.Add("card_alias", "invalid card alias: %#v", c.CardAlias)
+	}
+}
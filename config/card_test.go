@@ -0,0 +1,100 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tuxofil/p24fetch/schema"
+)
+
+func TestMaskCard(t *testing.T) {
+	const card = "4149123456781234"
+	testset := []struct {
+		Config Config
+		Card   string
+		Expect string
+	}{
+		{Config{CardNumber: card}, card, "XXXX1234"},
+		{Config{CardNumber: card, CardMask: CardMaskLast4}, "123", "XXXX"},
+		{Config{CardNumber: card, CardMask: CardMaskHash, CardKeySecret: "secret"}, card,
+			"card-7f262d6fc7caf45e"},
+		{Config{CardNumber: card, CardMask: CardMaskAlias, CardAlias: "family"}, card, "family"},
+		{Config{CardNumber: card, CardMask: CardMaskAlias, CardAlias: "family"},
+			"5168000000005678", "XXXX5678"},
+		{Config{CardNumber: card}, "", ""},
+	}
+	for n, test := range testset {
+		assert.Equal(t, test.Expect, test.Config.MaskCard(test.Card),
+			"test case #%d: %+v", n, test)
+	}
+
+	cfg := &Config{CardNumber: card}
+	tran := cfg.MaskTransaction(schema.Transaction{Src: card,
+		Raw: &schema.XMLTransaction{Card: card}})
+	assert.Equal(t, "XXXX1234", tran.Src)
+	assert.Equal(t, "XXXX1234", tran.Raw.Card)
+
+	// The key does not depend on the masking policy
+	cfg.CardMask = CardMaskAlias
+	assert.Equal(t, (&Config{CardNumber: card}).CardKey(), cfg.CardKey())
+	assert.NotContains(t, cfg.CardKey(), "1234")
+
+	// The key depends on the secret
+	cfg.CardKeySecret = "other"
+	assert.NotEqual(t, (&Config{CardNumber: card}).CardKey(), cfg.CardKey())
+}
+
+func TestStateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cfg := &Config{CardNumber: "4149123456781234"}
+
+	legacy := filepath.Join(dir, cfg.CardNumber+".json")
+	require.NoError(t, ioutil.WriteFile(legacy, []byte("{}"), 0600))
+	file, err := cfg.StateFile(dir, ".json")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, cfg.CardKey()+".json"), file)
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(data))
+	_, err = os.Stat(legacy)
+	assert.True(t, os.IsNotExist(err))
+
+	// Nothing to migrate
+	file, err = cfg.StateFile(dir, ".txn")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, cfg.CardKey()+".txn"), file)
+}
+
+func TestLoadCardKeySecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Concurrent runs agree on the secret
+	configs := make([]*Config, 8)
+	var wg sync.WaitGroup
+	for i := range configs {
+		configs[i] = &Config{DedupDir: filepath.Join(dir, "dedup")}
+		wg.Add(1)
+		go func(cfg *Config) {
+			defer wg.Done()
+			assert.NoError(t, cfg.loadCardKeySecret())
+		}(configs[i])
+	}
+	wg.Wait()
+	for _, cfg := range configs {
+		assert.Len(t, cfg.CardKeySecret, 64)
+		assert.Equal(t, configs[0].CardKeySecret, cfg.CardKeySecret)
+	}
+	files, err := ioutil.ReadDir(filepath.Join(dir, "dedup"))
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
@@ -1,33 +1,75 @@
 package config
 
 import (
-	"errors"
-	"fmt"
-	"log"
+	"net/url"
 	"os"
 	"strings"
 
+	"github.com/tuxofil/p24fetch/conffile"
+	"github.com/tuxofil/p24fetch/crypt"
+	"github.com/tuxofil/p24fetch/logger"
+	"github.com/tuxofil/p24fetch/schedule"
 	"github.com/tuxofil/p24fetch/schema"
 )
 
+// Transaction sources, see Config.Source
+const (
+	// Privat24 merchant API of personal cards
+	SourcePrivat24 = "privat24"
+	// Privat24 for Business autoclient API
+	SourcePrivat24Business = "privat24_business"
+	// Monobank personal API
+	SourceMonobank = "monobank"
+)
+
 type Config struct {
 	// Descriptive name of the merchant.
 	// Used for logging/messaging.
 	MerchantName string `json:"merchant_name"`
 
+	// Source of transactions: "privat24" (default),
+	// "privat24_business" or "monobank".
+	Source string `json:"source"`
 	// Privat24 Merchant ID
 	MerchantID int `json:"merchant_id"`
 	// Privat34 Merchant Password
 	MerchantPassword string `json:"merchant_password"`
-	// Bank card number
+	// Privat24 for Business autoclient token.
+	// Mandatory for privat24_business source.
+	BusinessToken string `json:"business_token"`
+	// Monobank personal API token.
+	// Mandatory for monobank source.
+	MonobankToken string `json:"monobank_token"`
+	// Bank card number. IBAN of the account
+	// for privat24_business source.
 	CardNumber string `json:"card_number"`
+	// How the card number is shown in file names, logs,
+	// notifications and exports: "last4" (default), "hash"
+	// or "alias".
+	CardMask string `json:"card_mask"`
+	// Name of the card shown with the "alias" card mask.
+	CardAlias string `json:"card_alias"`
+	// Secret the card keys naming state files and the "hash"
+	// card mask are derived with. Optional: a random secret is
+	// generated in dedup_dir on the first run.
+	CardKeySecret string `json:"card_key_secret"`
+	// Privat24 API endpoint. Optional, intended for testing
+	// against a fake endpoint.
+	APIURL string `json:"api_url"`
 	// Fetch transaction history for this number of days
 	Days int `json:"days"`
+	// Fetch schedule used in the daemon mode.
+	// Either an interval ("6h", "@every 6h"), a shorthand
+	// ("@daily") or a 5-field cron expression ("0 */6 * * *").
+	Schedule string `json:"schedule"`
 
 	// Deduplicator state directory
 	DedupDir string `json:"dedup_dir"`
 	// Path to a JSON file with sorting rules.
 	RulesPath string `json:"rules_path"`
+	// Path to a file with sorting rules of the merchant merged
+	// on top of rules_path. New rules are saved to it. Optional.
+	RulesOverlay string `json:"rules_overlay"`
 	// Path to a directory to write exported files
 	ResultsDir string `json:"results_dir"`
 
@@ -44,22 +86,69 @@ type Config struct {
 	SlackToken string `json:"slack_token"`
 	// Slack channel ID to write messages to.
 	SlackChannel string `json:"slack_channel"`
+	// Send end-of-run summary to the Slack channel.
+	SlackSummary bool `json:"slack_summary"`
+	// Post a single digest message per merchant run instead
+	// of a message per unsorted transaction.
+	SlackDigest bool `json:"slack_digest"`
+	// Signing secret of the Slack app used to verify
+	// interaction payloads (account choices for unsorted
+	// transactions).
+	SlackSigningSecret string `json:"slack_signing_secret"`
+
+	// Additional notification backends
+	Notifiers []NotifierConfig `json:"notifiers"`
+
+	// Spending alerts. Optional.
+	Alerts *AlertsConfig `json:"alerts"`
+
+	// Public keys (age1...) to encrypt exported files, the
+	// archive and the deduplicator state with. Optional.
+	AgeRecipients []string `json:"age_recipients"`
+	// Path to the file with private keys (AGE-SECRET-KEY-1...)
+	// to read encrypted state with. Defaults to the file named
+	// by P24FETCH_AGE_IDENTITY environment variable.
+	AgeIdentity string `json:"age_identity"`
 
-	// Logging interface. Optional.
-	Logger *log.Logger
+	// Logger of the merchant. Optional, see Log.
+	Logger *logger.Logger `json:"-"`
 }
 
 // SetDefaultsFrom copies missing values from another Config instance
 func (c *Config) SetDefaultsFrom(d Config) {
+	if c.APIURL == "" {
+		c.APIURL = d.APIURL
+	}
+	if c.Source == "" {
+		c.Source = d.Source
+	}
+	if c.BusinessToken == "" {
+		c.BusinessToken = d.BusinessToken
+	}
+	if c.MonobankToken == "" {
+		c.MonobankToken = d.MonobankToken
+	}
+	if c.CardMask == "" {
+		c.CardMask = d.CardMask
+	}
+	if c.CardKeySecret == "" {
+		c.CardKeySecret = d.CardKeySecret
+	}
 	if c.Days == 0 {
 		c.Days = d.Days
 	}
+	if c.Schedule == "" {
+		c.Schedule = d.Schedule
+	}
 	if c.DedupDir == "" {
 		c.DedupDir = d.DedupDir
 	}
 	if c.RulesPath == "" {
 		c.RulesPath = d.RulesPath
 	}
+	if c.RulesOverlay == "" {
+		c.RulesOverlay = d.RulesOverlay
+	}
 	if c.ResultsDir == "" {
 		c.ResultsDir = d.ResultsDir
 	}
@@ -78,57 +167,127 @@ func (c *Config) SetDefaultsFrom(d Config) {
 	if c.SlackChannel == "" {
 		c.SlackChannel = d.SlackChannel
 	}
+	if c.SlackSigningSecret == "" {
+		c.SlackSigningSecret = d.SlackSigningSecret
+	}
+	if !c.SlackSummary {
+		c.SlackSummary = d.SlackSummary
+	}
+	if !c.SlackDigest {
+		c.SlackDigest = d.SlackDigest
+	}
+	if c.Notifiers == nil {
+		c.Notifiers = d.Notifiers
+	}
+	if c.Alerts == nil {
+		c.Alerts = d.Alerts
+	}
+	if c.AgeRecipients == nil {
+		c.AgeRecipients = d.AgeRecipients
+	}
+	if c.AgeIdentity == "" {
+		c.AgeIdentity = d.AgeIdentity
+	}
 }
 
 // Validate checks values of the configuration.
+// Returns *conffile.Error listing all problems found.
 func (c *Config) Validate() error {
+	var problems conffile.Problems
 	if c.MerchantName == "" {
-		return errors.New("no merchant name")
-	}
-	if c.MerchantID < 1 {
-		return fmt.Errorf("invalid merchant ID: %d", c.MerchantID)
+		problems.Add("merchant_name", "no merchant name")
 	}
-	if c.MerchantPassword == "" {
-		return errors.New("invalid merchant password")
+	switch c.Source {
+	case "", SourcePrivat24:
+		if c.MerchantID < 1 {
+			problems.Add("merchant_id", "invalid merchant ID: %d", c.MerchantID)
+		}
+		if c.MerchantPassword == "" {
+			problems.Add("merchant_password", "invalid merchant password")
+		}
+	case SourcePrivat24Business:
+		if c.BusinessToken == "" {
+			problems.Add("business_token", "no business token")
+		}
+	case SourceMonobank:
+		if c.MonobankToken == "" {
+			problems.Add("monobank_token", "no monobank token")
+		}
+	default:
+		problems.Add("source", "invalid source: %s", c.Source)
 	}
 	if c.CardNumber == "" {
-		return errors.New("invalid card number")
+		problems.Add("card_number", "invalid card number")
+	}
+	c.validateCardMask(&problems)
+	if c.APIURL != "" {
+		if u, err := url.Parse(c.APIURL); err != nil || u.Host == "" ||
+			(u.Scheme != "http" && u.Scheme != "https") {
+			problems.Add("api_url", "invalid API URL: %#v", c.APIURL)
+		}
 	}
 	if c.DedupDir == "" {
-		return fmt.Errorf("invalid deduplicator dir: %#v", c.DedupDir)
+		problems.Add("dedup_dir", "invalid deduplicator dir: %#v", c.DedupDir)
 	}
 	if fd, err := os.Open(c.RulesPath); err != nil {
-		return fmt.Errorf("invalid path to rules file: %w", err)
+		problems.Add("rules_path", "invalid path to rules file: %s", err)
 	} else {
 		_ = fd.Close()
 	}
+	if c.RulesOverlay != "" {
+		if fd, err := os.Open(c.RulesOverlay); err != nil {
+			problems.Add("rules_overlay", "invalid path to rules overlay: %s", err)
+		} else {
+			_ = fd.Close()
+		}
+	}
 	if c.ResultsDir == "" {
-		return fmt.Errorf("invalid results dir: %#v", c.ResultsDir)
+		problems.Add("results_dir", "invalid results dir: %#v", c.ResultsDir)
 	}
 	if c.Days < 1 {
-		return fmt.Errorf("invalid days number: %d", c.Days)
+		problems.Add("days", "invalid days number: %d", c.Days)
+	}
+	if c.Schedule != "" {
+		if _, err := schedule.Parse(c.Schedule); err != nil {
+			problems.Add("schedule", "invalid schedule: %s", err)
+		}
 	}
 	switch c.ExportFormat {
 	case schema.JSON:
 	case schema.QIF:
 		if c.SrcAccountName == "" {
-			return errors.New("no source account name")
+			problems.Add("src_account_name", "no source account name")
 		}
 		if c.ComissionAccountName == "" {
-			return errors.New("no comission account name")
+			problems.Add("comission_account_name", "no comission account name")
 		}
 	default:
-		return fmt.Errorf("invalid export format: %s", c.ExportFormat)
+		problems.Add("export_format", "invalid export format: %s", c.ExportFormat)
 	}
 
 	if s := c.SlackToken; s != "" && !strings.HasPrefix(s, "xoxp-") {
-		return errors.New("invalid Slack token")
+		problems.Add("slack_token", "invalid Slack token")
+	}
+	for i := range c.Notifiers {
+		problems.Merge(conffile.Index("notifiers", i), c.Notifiers[i].Validate())
+	}
+	if c.Alerts != nil {
+		problems.Merge("alerts", c.Alerts.Validate())
+	}
+	for i, key := range c.AgeRecipients {
+		if _, err := crypt.ParseRecipients([]string{key}); err != nil {
+			problems.Add(conffile.Index("age_recipients", i), "invalid age recipient")
+		}
 	}
-	return nil
+	return problems.Err()
 }
 
-func (c *Config) Logf(format string, v ...interface{}) {
+// Log returns Logger when set, otherwise the default
+// logger with merchant and card fields.
+func (c *Config) Log() *logger.Logger {
 	if c.Logger != nil {
-		c.Logger.Printf(format, v...)
+		return c.Logger
 	}
+	return logger.Default().With(logger.FieldMerchant, c.MerchantName,
+		logger.FieldCard, c.MaskedCard())
 }
@@ -1,34 +1,130 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"path"
+
+	"github.com/tuxofil/p24fetch/conffile"
+	"github.com/tuxofil/p24fetch/crypt"
 )
 
 // Merchants represents an array of merchants configurations
 type Merchants struct {
+	// Merchants files to include, relative to the file.
+	// Glob patterns are allowed. Defaults of the file apply
+	// to included merchants after their own defaults.
+	Include []string `json:"include"`
 	// List of configured merchants
 	Entries []*Config `json:"merchants"`
 	// Default values for merchants configurations
 	Defaults Config `json:"defaults"`
 }
 
-// NewConfigs reads merchants configurations from JSON file.
+// Merchant configuration with locations of its
+// settings: the entry itself and defaults applied
+type merchantEntry struct {
+	config    *Config
+	locations []conffile.Location
+}
+
+// NewConfigs reads merchants configurations from the file.
+// The file is JSON, YAML or TOML depending on its extension,
+// may include other merchants files and may be encrypted with
+// age, see crypt.IdentityEnv. Secret references are resolved,
+// see secretEnv, and the card key secret is loaded. Validation
+// problems of all merchants are reported at once as *conffile.Error.
 func NewConfigs(path string) ([]*Config, error) {
-	data, err := ioutil.ReadFile(path)
+	var includer conffile.Includer
+	entries, err := readMerchants(path, &includer)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		problems conffile.Problems
+		configs  []*Config
+	)
+	resolver := secretResolver{}
+	for _, e := range entries {
+		problems.Merge("", conffile.LocateAny(resolver.resolveConfig(e.config), e.locations))
+		problems.Merge("", conffile.LocateAny(e.config.Validate(), e.locations))
+		configs = append(configs, e.config)
+	}
+	if err := problems.Err(); err != nil {
+		return nil, err
+	}
+	for _, cfg := range configs {
+		if err := cfg.loadCardKeySecret(); err != nil {
+			return nil, fmt.Errorf("merchant %s: %w", cfg.MerchantName, err)
+		}
+	}
+	if err := checkExportNames(entries); err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// Exported files are named by the masked card number, so
+// different cards must not be masked to the same name.
+func checkExportNames(entries []merchantEntry) error {
+	var problems conffile.Problems
+	names := map[string]*Config{}
+	for _, e := range entries {
+		name := path.Join(e.config.ResultsDir, e.config.MaskedCard())
+		other, ok := names[name]
+		if !ok {
+			names[name] = e.config
+			continue
+		}
+		if other.CardNumber == e.config.CardNumber {
+			continue
+		}
+		var p conffile.Problems
+		p.Add("card_mask", "card is masked to %s as the card of merchant %s, "+
+			"use hash or alias card mask", e.config.MaskedCard(), other.MerchantName)
+		problems.Merge("", conffile.LocateAny(p.Err(), e.locations))
+	}
+	return problems.Err()
+}
+
+// Read merchants of the file and of files included by it
+// with defaults applied.
+func readMerchants(path string, includer *conffile.Includer) ([]merchantEntry, error) {
+	if ok, err := includer.Enter(path); err != nil || !ok {
+		return nil, err
+	}
+	defer includer.Leave()
+	data, err := crypt.ReadFile(path, "")
 	if err != nil {
 		return nil, fmt.Errorf("read file: %w", err)
 	}
 	var merchants Merchants
-	if err := json.Unmarshal(data, &merchants); err != nil {
-		return nil, fmt.Errorf("json decode: %w", err)
+	pos, err := conffile.Decode(path, data, &merchants)
+	if err != nil {
+		return nil, err
 	}
+	var entries []merchantEntry
 	for i, m := range merchants.Entries {
-		m.SetDefaultsFrom(merchants.Defaults)
-		if err := m.Validate(); err != nil {
-			return nil, fmt.Errorf("merchant #%d validate: %w", i, err)
+		entries = append(entries, merchantEntry{
+			config: m,
+			locations: []conffile.Location{{
+				File: path, Pos: pos, Field: conffile.Index("merchants", i)}},
+		})
+	}
+	includes, err := conffile.Includes(path, pos, merchants.Include)
+	if err != nil {
+		return nil, err
+	}
+	for _, include := range includes {
+		included, err := readMerchants(include, includer)
+		if err != nil {
+			return nil, err
 		}
+		entries = append(entries, included...)
+	}
+	defaults := conffile.Location{File: path, Pos: pos, Field: "defaults"}
+	for i := range entries {
+		entries[i].config.SetDefaultsFrom(merchants.Defaults)
+		entries[i].locations = append(entries[i].locations, defaults)
 	}
-	return merchants.Entries, nil
+	return entries, nil
 }
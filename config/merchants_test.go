@@ -0,0 +1,153 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tuxofil/p24fetch/conffile"
+)
+
+func TestNewConfigs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	rules := filepath.Join(dir, "rules.yaml")
+	require.NoError(t, ioutil.WriteFile(rules, nil, 0600))
+
+	write := func(name, data string) string {
+		file := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(file, []byte(data), 0600))
+		return file
+	}
+
+	file := write("merchants.yaml", `# Cards
+defaults:
+  days: 30
+  dedup_dir: `+filepath.Join(dir, "dedup")+`
+  results_dir: run/results
+  rules_path: `+rules+`
+  export_format: JSON
+merchants:
+  - merchant_name: main
+    merchant_id: 123
+    merchant_password: secret
+    card_number: "4149000000001234"
+`)
+	configs, err := NewConfigs(file)
+	require.NoError(t, err)
+	require.Len(t, configs, 1)
+	assert.Equal(t, 123, configs[0].MerchantID)
+	assert.Equal(t, "4149000000001234", configs[0].CardNumber)
+	assert.Equal(t, 30, configs[0].Days)
+
+	// The card key secret is generated once
+	assert.Len(t, configs[0].CardKeySecret, 64)
+	assert.FileExists(t, filepath.Join(dir, "dedup", cardKeySecretFile))
+	again, err := NewConfigs(file)
+	require.NoError(t, err)
+	assert.Equal(t, configs[0].CardKey(), again[0].CardKey())
+
+	file = write("merchants.toml", `
+[defaults]
+days = 0
+dedup_dir = "run/dedup"
+results_dir = "run/results"
+rules_path = "`+rules+`"
+export_format = "xls"
+
+[[merchants]]
+merchant_name = "main"
+merchant_id = 123
+merchant_password = "secret"
+card_number = "1234"
+
+[[merchants]]
+merchant_name = "other"
+merchant_id = -1
+card_number = "5678"
+`)
+	_, err = NewConfigs(file)
+	var cerr *conffile.Error
+	require.True(t, errors.As(err, &cerr), err)
+	var messages []string
+	for _, p := range cerr.Problems {
+		messages = append(messages, p.String())
+	}
+	assert.Equal(t, []string{
+		file + ":3: defaults.days: invalid days number: 0",
+		file + ":7: defaults.export_format: invalid export format: xls",
+		file + ":15: merchants[1].merchant_password: invalid merchant password",
+		file + ":17: merchants[1].merchant_id: invalid merchant ID: -1",
+	}, messages)
+
+	// Included merchants get defaults of their own
+	// file first, then of the including file
+	write("family.json", `{
+  "defaults": {"days": 7},
+  "merchants": [
+    {"merchant_name": "family", "merchant_id": 456,
+     "merchant_password": "secret", "card_number": "5168000000005678"}
+  ]
+}`)
+	file = write("all.yaml", `include: [family.json, "cards/*.yaml"]
+defaults:
+  days: 30
+  dedup_dir: run/dedup
+  card_key_secret: secret
+  results_dir: run/results
+  rules_path: `+rules+`
+  export_format: JSON
+merchants:
+  - merchant_name: main
+    merchant_id: 123
+    merchant_password: secret
+    card_number: "4149000000001234"
+`)
+	configs, err = NewConfigs(file)
+	require.NoError(t, err)
+	require.Len(t, configs, 2)
+	assert.Equal(t, "family", configs[1].MerchantName)
+	assert.Equal(t, 7, configs[1].Days)
+	assert.Equal(t, "run/dedup", configs[1].DedupDir)
+
+	write("family.json", `{
+  "merchants": [
+    {"merchant_name": "family", "merchant_id": 456,
+     "card_number": "5168000000005678", "export_format": "xls"}
+  ]
+}`)
+	_, err = NewConfigs(file)
+	require.Error(t, err)
+	assert.Equal(t, "2 problems:\n"+
+		"  "+filepath.Join(dir, "family.json")+":3: merchants[0].merchant_password: invalid merchant password\n"+
+		"  "+filepath.Join(dir, "family.json")+":4: merchants[0].export_format: invalid export format: xls",
+		err.Error())
+
+	// Cards masked to the same export file name are rejected
+	write("family.json", `{
+  "merchants": [
+    {"merchant_name": "family", "merchant_id": 456,
+     "merchant_password": "secret", "card_number": "5168000000001234"}
+  ]
+}`)
+	_, err = NewConfigs(file)
+	require.Error(t, err)
+	assert.Equal(t, filepath.Join(dir, "family.json")+":3: merchants[0].card_mask: card is masked to "+
+		"XXXX1234 as the card of merchant main, use hash or alias card mask", err.Error())
+
+	write("family.json", `{"include": ["all.yaml"]}`)
+	_, err = NewConfigs(file)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "include cycle")
+
+	write("family.json", `{"include": ["missing.json"]}`)
+	_, err = NewConfigs(file)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "family.json:1: include[0]: invalid include")
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"net/url"
+	"text/template"
+
+	"github.com/tuxofil/p24fetch/conffile"
+)
+
+// Notifier types
+const (
+	NotifierTelegram = "telegram"
+	NotifierEmail    = "email"
+	NotifierWebhook  = "webhook"
+)
+
+// NotifierConfig configures a single notification backend.
+// Slack is configured with the slack_* settings of the merchant.
+type NotifierConfig struct {
+	// Backend type. One of: telegram, email, webhook.
+	Type string `json:"type"`
+
+	// Mandatory for telegram. Telegram Bot API token.
+	TelegramToken string `json:"telegram_token"`
+	// Mandatory for telegram. Chat ID to write messages to.
+	TelegramChatID string `json:"telegram_chat_id"`
+
+	// Mandatory for email. SMTP server address (host:port).
+	SMTPAddr string `json:"smtp_addr"`
+	// SMTP user name. When empty, no authentication performed.
+	SMTPUser string `json:"smtp_user"`
+	// SMTP user password.
+	SMTPPassword string `json:"smtp_password"`
+	// Mandatory for email. Sender address.
+	EmailFrom string `json:"email_from"`
+	// Mandatory for email. Recipient addresses.
+	EmailTo []string `json:"email_to"`
+
+	// Mandatory for webhook. URL to POST JSON messages to.
+	WebhookURL string `json:"webhook_url"`
+	// Additional HTTP headers for webhook requests.
+	WebhookHeaders map[string]string `json:"webhook_headers"`
+
+	// Message templates (Go text/template syntax) by kind:
+	//  unsorted -- report on unsorted transactions;
+	//  message -- free-form message.
+	// Defaults are used for missing kinds.
+	Templates map[string]string `json:"templates"`
+}
+
+// Validate checks values of the notifier configuration.
+// Returns *conffile.Error listing all problems found.
+func (n *NotifierConfig) Validate() error {
+	var problems conffile.Problems
+	switch n.Type {
+	case NotifierTelegram:
+		if n.TelegramToken == "" {
+			problems.Add("telegram_token", "no Telegram token")
+		}
+		if n.TelegramChatID == "" {
+			problems.Add("telegram_chat_id", "no Telegram chat ID")
+		}
+	case NotifierEmail:
+		if n.SMTPAddr == "" {
+			problems.Add("smtp_addr", "no SMTP server address")
+		}
+		if n.EmailFrom == "" {
+			problems.Add("email_from", "no sender address")
+		}
+		if len(n.EmailTo) == 0 {
+			problems.Add("email_to", "no recipient addresses")
+		}
+	case NotifierWebhook:
+		if u, err := url.Parse(n.WebhookURL); err != nil {
+			problems.Add("webhook_url", "invalid webhook URL: %s", err)
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			problems.Add("webhook_url", "invalid webhook URL: %#v", n.WebhookURL)
+		}
+	default:
+		problems.Add("type", "invalid notifier type: %#v", n.Type)
+	}
+	for kind, text := range n.Templates {
+		if _, err := template.New(kind).Parse(text); err != nil {
+			problems.Add(conffile.Join("templates", kind), "invalid template: %s", err)
+		}
+	}
+	return problems.Err()
+}
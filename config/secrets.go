@@ -0,0 +1,224 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/tuxofil/p24fetch/conffile"
+)
+
+// Secret reference prefixes. A secret setting starting with
+// one of the prefixes is replaced with the referenced value:
+//
+//	env:NAME      value of the environment variable
+//	file:PATH     content of the file, trailing newlines removed
+//	pass:NAME     first line of `pass show NAME`
+//	gopass:NAME   output of `gopass show -o NAME`
+//
+// Any other value is used as is.
+const (
+	secretEnv    = "env:"
+	secretFile   = "file:"
+	secretPass   = "pass:"
+	secretGopass = "gopass:"
+)
+
+// Placeholder for secrets in logs and error messages
+const redacted = "[REDACTED]"
+
+// Secrets shorter than that are not redacted to
+// avoid mangling unrelated text
+const minRedactLen = 4
+
+// Runs an external command and returns its standard output.
+// Replaced in tests.
+var runCommand = func(name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+// Known secret values to redact
+var secrets struct {
+	sync.RWMutex
+	values   map[string]bool
+	replacer *strings.Replacer
+}
+
+// Resolves secret references caching resolved values,
+// so a reference shared by several merchants is resolved once.
+type secretResolver map[string]string
+
+// Resolve the secret setting in place and register its value
+// for redaction. Failures are added to problems and never
+// include the secret value.
+func (r secretResolver) resolve(problems *conffile.Problems, field string, value *string) {
+	ref := *value
+	if ref == "" {
+		return
+	}
+	resolved, ok := r[ref]
+	if !ok {
+		var err error
+		if resolved, err = resolveSecret(ref); err != nil {
+			problems.Add(field, "resolve secret: %s", err)
+			return
+		}
+		r[ref] = resolved
+	}
+	*value = resolved
+	registerSecret(resolved)
+}
+
+// Resolve secret settings of the configuration.
+// Returns *conffile.Error listing all settings failed to resolve.
+func (r secretResolver) resolveConfig(c *Config) error {
+	var problems conffile.Problems
+	r.resolve(&problems, "merchant_password", &c.MerchantPassword)
+	r.resolve(&problems, "business_token", &c.BusinessToken)
+	r.resolve(&problems, "monobank_token", &c.MonobankToken)
+	r.resolve(&problems, "card_key_secret", &c.CardKeySecret)
+	r.resolve(&problems, "slack_token", &c.SlackToken)
+	r.resolve(&problems, "slack_signing_secret", &c.SlackSigningSecret)
+	// Notifiers may be shared with the defaults,
+	// so resolve a copy
+	notifiers := make([]NotifierConfig, len(c.Notifiers))
+	copy(notifiers, c.Notifiers)
+	for i := range notifiers {
+		r.resolveNotifier(&problems, conffile.Index("notifiers", i), &notifiers[i])
+	}
+	if c.Notifiers != nil {
+		c.Notifiers = notifiers
+	}
+	return problems.Err()
+}
+
+// Resolve secret settings of the notifier configuration.
+func (r secretResolver) resolveNotifier(problems *conffile.Problems, field string, n *NotifierConfig) {
+	r.resolve(problems, conffile.Join(field, "telegram_token"), &n.TelegramToken)
+	r.resolve(problems, conffile.Join(field, "smtp_password"), &n.SMTPPassword)
+	if n.WebhookHeaders == nil {
+		return
+	}
+	headers := make(map[string]string, len(n.WebhookHeaders))
+	for key, value := range n.WebhookHeaders {
+		r.resolve(problems, conffile.Join(field, "webhook_headers."+key), &value)
+		headers[key] = value
+	}
+	n.WebhookHeaders = headers
+}
+
+// Resolve the secret reference.
+func resolveSecret(ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, secretEnv):
+		name := strings.TrimPrefix(ref, secretEnv)
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value, nil
+	case strings.HasPrefix(ref, secretFile):
+		path := strings.TrimPrefix(ref, secretFile)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return nonEmpty(strings.TrimRight(string(data), "\r\n"))
+	case strings.HasPrefix(ref, secretPass):
+		out, err := runCommand("pass", "show", strings.TrimPrefix(ref, secretPass))
+		if err != nil {
+			return "", fmt.Errorf("pass: %w", err)
+		}
+		return nonEmpty(strings.SplitN(string(out), "\n", 2)[0])
+	case strings.HasPrefix(ref, secretGopass):
+		out, err := runCommand("gopass", "show", "-o", strings.TrimPrefix(ref, secretGopass))
+		if err != nil {
+			return "", fmt.Errorf("gopass: %w", err)
+		}
+		return nonEmpty(strings.TrimRight(string(out), "\r\n"))
+	}
+	return ref, nil
+}
+
+func nonEmpty(s string) (string, error) {
+	if s == "" {
+		return "", errors.New("empty secret")
+	}
+	return s, nil
+}
+
+// Register the value to be redacted by Redact.
+func registerSecret(value string) {
+	if len(value) < minRedactLen {
+		return
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	if secrets.values[value] {
+		return
+	}
+	if secrets.values == nil {
+		secrets.values = map[string]bool{}
+	}
+	secrets.values[value] = true
+	// Replace longer secrets first as they may
+	// contain shorter ones
+	values := make([]string, 0, len(secrets.values))
+	for v := range secrets.values {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+	pairs := make([]string, 0, 2*len(values))
+	for _, v := range values {
+		pairs = append(pairs, v, redacted)
+	}
+	secrets.replacer = strings.NewReplacer(pairs...)
+}
+
+// Redact replaces secrets of all configurations read
+// with NewConfigs in the text.
+func Redact(s string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+	if secrets.replacer == nil {
+		return s
+	}
+	return secrets.replacer.Replace(s)
+}
+
+// RedactingWriter returns a writer passing everything
+// written to w with secrets redacted. Intended for
+// log output.
+func RedactingWriter(w io.Writer) io.Writer {
+	return redactingWriter{w}
+}
+
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (r redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	secretPath := filepath.Join(dir, "secret")
+	require.NoError(t, ioutil.WriteFile(secretPath, []byte("from-file\n"), 0600))
+	require.NoError(t, os.Setenv("P24FETCH_TEST_SECRET", "from-env"))
+	defer os.Unsetenv("P24FETCH_TEST_SECRET")
+
+	defer func(orig func(string, ...string) ([]byte, error)) { runCommand = orig }(runCommand)
+	var calls [][]string
+	runCommand = func(name string, args ...string) ([]byte, error) {
+		calls = append(calls, append([]string{name}, args...))
+		if args[len(args)-1] == "missing" {
+			return nil, errors.New("not found")
+		}
+		return []byte("from-" + name + "\nlogin: me\n"), nil
+	}
+
+	testset := []struct {
+		Ref    string
+		Expect string
+		Error  bool
+	}{
+		{"plain", "plain", false},
+		{"env:P24FETCH_TEST_SECRET", "from-env", false},
+		{"env:P24FETCH_TEST_MISSING", "", true},
+		{"file:" + secretPath, "from-file", false},
+		{"file:" + filepath.Join(dir, "missing"), "", true},
+		{"pass:p24/merchant", "from-pass", false},
+		{"pass:missing", "", true},
+		{"gopass:p24/merchant", "from-gopass\nlogin: me", false},
+	}
+	for n, test := range testset {
+		value, err := resolveSecret(test.Ref)
+		if test.Error {
+			assert.Error(t, err, "test case #%d: %+v", n, test)
+			continue
+		}
+		require.NoError(t, err, "test case #%d: %+v", n, test)
+		assert.Equal(t, test.Expect, value, "test case #%d: %+v", n, test)
+	}
+	assert.Equal(t, []string{"gopass", "show", "-o", "p24/merchant"}, calls[len(calls)-1])
+}
+
+func TestResolveConfig(t *testing.T) {
+	require.NoError(t, os.Setenv("P24FETCH_TEST_PASSWORD", "s3cr3t-password"))
+	defer os.Unsetenv("P24FETCH_TEST_PASSWORD")
+	defaults := Config{
+		Notifiers: []NotifierConfig{{
+			Type:           NotifierWebhook,
+			WebhookHeaders: map[string]string{"Authorization": "env:P24FETCH_TEST_PASSWORD"},
+		}},
+	}
+	cfg := &Config{MerchantPassword: "env:P24FETCH_TEST_PASSWORD"}
+	cfg.SetDefaultsFrom(defaults)
+	require.NoError(t, secretResolver{}.resolveConfig(cfg))
+	assert.Equal(t, "s3cr3t-password", cfg.MerchantPassword)
+	assert.Equal(t, "s3cr3t-password", cfg.Notifiers[0].WebhookHeaders["Authorization"])
+	// Defaults are left intact
+	assert.Equal(t, "env:P24FETCH_TEST_PASSWORD",
+		defaults.Notifiers[0].WebhookHeaders["Authorization"])
+
+	cfg = &Config{SlackToken: "env:P24FETCH_TEST_MISSING"}
+	err := secretResolver{}.resolveConfig(cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "slack_token: resolve secret")
+
+	// Resolved secrets are redacted
+	assert.Equal(t, "login failed: password [REDACTED] is wrong",
+		Redact("login failed: password s3cr3t-password is wrong"))
+	var buf bytes.Buffer
+	w := RedactingWriter(&buf)
+	n, err := w.Write([]byte("s3cr3t-password\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 16, n)
+	assert.Equal(t, "[REDACTED]\n", buf.String())
+}
@@ -0,0 +1,147 @@
+// Package crypt encrypts files at rest with age
+// (https://age-encryption.org) X25519 keys.
+//
+// Encrypted files are compatible with the age command line tool:
+// they can be created with `age -r age1...` and decrypted with
+// `age -d -i identity.txt`.
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"filippo.io/age"
+)
+
+// Ext is the extension added to names of encrypted files.
+const Ext = ".age"
+
+// IdentityEnv is the environment variable with path to the identity
+// file used when no identity file is configured explicitly.
+const IdentityEnv = "P24FETCH_AGE_IDENTITY"
+
+// Every age file starts with the header
+const magic = "age-encryption.org/v1\n"
+
+// IsEncrypted reports whether the data is an age file.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(magic))
+}
+
+// ParseRecipients parses public keys (age1...).
+func ParseRecipients(keys []string) ([]age.Recipient, error) {
+	res := make([]age.Recipient, len(keys))
+	for i, key := range keys {
+		r, err := age.ParseX25519Recipient(key)
+		if err != nil {
+			return nil, fmt.Errorf("recipient #%d: %w", i, err)
+		}
+		res[i] = r
+	}
+	return res, nil
+}
+
+// Encrypt the data to the recipients (public keys).
+// Returns the data as is when there are no recipients.
+func Encrypt(data []byte, recipients []string) ([]byte, error) {
+	if len(recipients) == 0 {
+		return data, nil
+	}
+	rs, err := ParseRecipients(recipients)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, rs...)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("encrypt: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("encrypt: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decrypt the data with identities (private keys) from the file.
+// Identity file defaults to the one named by IdentityEnv.
+// Returns the data as is when it is not encrypted.
+func Decrypt(data []byte, identityFile string) ([]byte, error) {
+	return NewIdentities(identityFile).Decrypt(data)
+}
+
+// Identities are private keys read from the identity file on
+// first use, so decrypting many values reads the file once.
+type Identities struct {
+	file string
+	once sync.Once
+	ids  []age.Identity
+	err  error
+}
+
+// NewIdentities returns identities of the file.
+// Identity file defaults to the one named by IdentityEnv.
+func NewIdentities(identityFile string) *Identities {
+	return &Identities{file: identityFile}
+}
+
+// Decrypt the data. Returns the data as is when it is not encrypted.
+func (i *Identities) Decrypt(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+	i.once.Do(func() {
+		i.ids, i.err = LoadIdentities(i.file)
+	})
+	if i.err != nil {
+		return nil, i.err
+	}
+	r, err := age.Decrypt(bytes.NewReader(data), i.ids...)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt: %w", err)
+	}
+	res, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt: %w", err)
+	}
+	return res, nil
+}
+
+// ReadFile reads the file decrypting it when encrypted.
+// See Decrypt for identityFile.
+func ReadFile(path, identityFile string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Decrypt(data, identityFile)
+}
+
+// LoadIdentities reads private keys (AGE-SECRET-KEY-1...) from
+// the identity file. Identity file defaults to the one named
+// by IdentityEnv.
+func LoadIdentities(identityFile string) ([]age.Identity, error) {
+	if identityFile == "" {
+		identityFile = os.Getenv(IdentityEnv)
+	}
+	if identityFile == "" {
+		return nil, errors.New("data is encrypted but no identity file " +
+			"configured (set age_identity or " + IdentityEnv + ")")
+	}
+	fd, err := os.Open(identityFile)
+	if err != nil {
+		return nil, fmt.Errorf("read identity: %w", err)
+	}
+	defer fd.Close()
+	ids, err := age.ParseIdentities(fd)
+	if err != nil {
+		return nil, fmt.Errorf("parse identity: %w", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,70 @@
+package crypt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Write a new identity file to the dir.
+// Returns path to the file and the recipient.
+func newIdentity(t *testing.T, dir, name string) (string, string) {
+	id, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	file := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(file, []byte("# test\n"+id.String()+"\n"), 0600))
+	return file, id.Recipient().String()
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crypt")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	identity, recipient := newIdentity(t, dir, "identity.txt")
+	other, _ := newIdentity(t, dir, "other.txt")
+	plain := []byte(`{"date":"2020-09-20","time":"12:00:00"}`)
+
+	// No recipients
+	data, err := Encrypt(plain, nil)
+	require.NoError(t, err)
+	assert.Equal(t, plain, data)
+	assert.False(t, IsEncrypted(data))
+
+	data, err = Encrypt(plain, []string{recipient})
+	require.NoError(t, err)
+	assert.True(t, IsEncrypted(data))
+	assert.NotContains(t, string(data), "2020-09-20")
+
+	decrypted, err := Decrypt(data, identity)
+	require.NoError(t, err)
+	assert.Equal(t, plain, decrypted)
+
+	// Not encrypted data is returned as is
+	decrypted, err = Decrypt(plain, "")
+	require.NoError(t, err)
+	assert.Equal(t, plain, decrypted)
+
+	// Wrong key
+	_, err = Decrypt(data, other)
+	assert.Error(t, err)
+
+	// Identity from the environment
+	require.NoError(t, os.Unsetenv(IdentityEnv))
+	_, err = Decrypt(data, "")
+	assert.Error(t, err)
+	require.NoError(t, os.Setenv(IdentityEnv, identity))
+	defer os.Unsetenv(IdentityEnv)
+	file := filepath.Join(dir, "state.json")
+	require.NoError(t, ioutil.WriteFile(file, data, 0600))
+	decrypted, err = ReadFile(file, "")
+	require.NoError(t, err)
+	assert.Equal(t, plain, decrypted)
+
+	_, err = Encrypt(plain, []string{"age1invalid"})
+	assert.Error(t, err)
+}
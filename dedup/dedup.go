@@ -3,19 +3,24 @@ package dedup
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"path"
 
 	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/crypt"
 	"github.com/tuxofil/p24fetch/schema"
+	"github.com/tuxofil/p24fetch/txn"
 )
 
 type Deduplicator struct {
 	// Configuration used to create the instance
 	config config.Config
+	// Path to the state file
+	stateFile string
 	// Last processed entry
 	state state
+	// Transaction to stage state writes in.
+	// When nil, the state is written immediately.
+	tx *txn.Tx
 }
 
 type state struct {
@@ -28,8 +33,12 @@ func New(cfg *config.Config) (*Deduplicator, error) {
 	if err := os.MkdirAll(cfg.DedupDir, 0700); err != nil {
 		return nil, fmt.Errorf("create state dir: %w", err)
 	}
-	dedup := &Deduplicator{config: *cfg}
-	data, err := ioutil.ReadFile(dedup.stateFileName())
+	stateFile, err := cfg.StateFile(cfg.DedupDir, ".json")
+	if err != nil {
+		return nil, err
+	}
+	dedup := &Deduplicator{config: *cfg, stateFile: stateFile}
+	data, err := crypt.ReadFile(stateFile, cfg.AgeIdentity)
 	if err != nil {
 		if !os.IsNotExist(err) {
 			return nil, fmt.Errorf("read state file: %w", err)
@@ -40,6 +49,13 @@ func New(cfg *config.Config) (*Deduplicator, error) {
 	return dedup, nil
 }
 
+// WithTx makes the deduplicator stage state writes in
+// the transaction instead of writing the state immediately.
+func (d *Deduplicator) WithTx(tx *txn.Tx) *Deduplicator {
+	d.tx = tx
+	return d
+}
+
 // Filter filters transaction log according to deduplicator saved state.
 func (d *Deduplicator) Filter(trans []schema.XMLTransaction) []schema.XMLTransaction {
 	if d.state.IsZero() {
@@ -65,17 +81,16 @@ func (d *Deduplicator) Update(tran schema.XMLTransaction) error {
 	if err != nil {
 		return fmt.Errorf("marshal: %w", err)
 	}
-	if err := ioutil.WriteFile(d.stateFileName(), data, 0600); err != nil {
+	if data, err = crypt.Encrypt(data, d.config.AgeRecipients); err != nil {
+		return err
+	}
+	if err := d.tx.WriteFile(d.stateFile, data); err != nil {
 		return fmt.Errorf("write file: %w", err)
 	}
 	d.state = newState
 	return nil
 }
 
-func (d *Deduplicator) stateFileName() string {
-	return path.Join(d.config.DedupDir, d.config.CardNumber+".json")
-}
-
 func (s *state) IsZero() bool {
 	return s.Date == "" || s.Time == ""
 }
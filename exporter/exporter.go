@@ -4,18 +4,23 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path"
+	"strconv"
 	"time"
 
 	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/crypt"
 	"github.com/tuxofil/p24fetch/schema"
+	"github.com/tuxofil/p24fetch/txn"
 )
 
 type Exporter struct {
 	// Configuration used to create the instance.
 	config config.Config
+	// Transaction to stage writes in.
+	// When nil, files are written immediately.
+	tx *txn.Tx
 }
 
 // Create new exporter instance.
@@ -23,15 +28,30 @@ func New(cfg *config.Config) *Exporter {
 	return &Exporter{config: *cfg}
 }
 
+// WithTx makes the exporter stage writes in the transaction
+// instead of writing files immediately.
+func (e *Exporter) WithTx(tx *txn.Tx) *Exporter {
+	e.tx = tx
+	return e
+}
+
 // Export transaction log to external storage.
 func (e *Exporter) Export(trans []schema.Transaction) error {
+	_, err := e.ExportFile(trans)
+	return err
+}
+
+// ExportFile exports transaction log to external storage.
+// Returns path to the file transactions were written to.
+// Returns empty path when there is nothing to export.
+func (e *Exporter) ExportFile(trans []schema.Transaction) (string, error) {
 	if len(trans) == 0 {
-		return nil
+		return "", nil
 	}
 	if err := os.MkdirAll(e.config.ResultsDir, 0700); err != nil {
-		return fmt.Errorf("create results dir: %w", err)
+		return "", fmt.Errorf("create results dir: %w", err)
 	}
-	filePath := path.Join(e.config.ResultsDir, e.config.CardNumber)
+	filePath := path.Join(e.config.ResultsDir, e.config.MaskedCard())
 
 	switch e.config.ExportFormat {
 	case schema.JSON:
@@ -39,17 +59,64 @@ func (e *Exporter) Export(trans []schema.Transaction) error {
 		encoder := json.NewEncoder(&buf)
 		encoder.SetIndent("", "  ")
 		if err := encoder.Encode(trans); err != nil {
-			return fmt.Errorf("encode tran: %w", err)
+			return "", fmt.Errorf("encode tran: %w", err)
 		}
-		filePath += "-" + time.Now().Format("2006-01-02T15-04-05") + ".json"
-		if err := ioutil.WriteFile(filePath, buf.Bytes(), 0600); err != nil {
-			return fmt.Errorf("write file: %w", err)
-		}
-		return nil
+		return e.exportNew(filePath, ".json", buf.Bytes())
 	case schema.QIF:
+		if len(e.config.AgeRecipients) > 0 {
+			// Encrypted files can not be appended to,
+			// so every export goes to a new file
+			data := append([]byte(fmt.Sprintf(qifHeader, e.config.SrcAccountName)),
+				formatQIF(trans, e.config.ComissionAccountName)...)
+			return e.exportNew(filePath, ".qif", data)
+		}
 		filePath += ".qif"
-		return ExportToQIF(trans, e.config.SrcAccountName,
+		return filePath, exportToQIF(e.tx, trans, e.config.SrcAccountName,
 			e.config.ComissionAccountName, filePath)
 	}
-	return fmt.Errorf("not implemented: %s", e.config.ExportFormat)
+	return "", fmt.Errorf("not implemented: %s", e.config.ExportFormat)
+}
+
+// Export data to a new file named after the base name and the
+// current time. The data is encrypted when recipients are configured.
+// Returns path to the file written.
+func (e *Exporter) exportNew(base, ext string, data []byte) (string, error) {
+	if len(e.config.AgeRecipients) > 0 {
+		var err error
+		if data, err = crypt.Encrypt(data, e.config.AgeRecipients); err != nil {
+			return "", err
+		}
+		ext += crypt.Ext
+	}
+	base += "-" + time.Now().Format("2006-01-02T15-04-05")
+	defer txn.Lock(base)()
+	written, err := e.writeUnique(base, ext, data)
+	if err != nil {
+		return "", fmt.Errorf("write file: %w", err)
+	}
+	return written, nil
+}
+
+// Write data to a new file. When the file with given name already
+// exists, a numeric suffix is added to the base name.
+// Returns path to the file written.
+func (e *Exporter) writeUnique(base, ext string, data []byte) (string, error) {
+	filePath := base + ext
+	for i := 1; ; i++ {
+		var err error
+		if e.tx == nil {
+			err = txn.CreateFile(filePath, data)
+		} else if _, err = os.Stat(filePath); err == nil || e.tx.Staged(filePath) {
+			err = os.ErrExist
+		} else if os.IsNotExist(err) {
+			err = e.tx.WriteFile(filePath, data)
+		}
+		if os.IsExist(err) {
+			filePath = base + "-" + strconv.Itoa(i) + ext
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		return filePath, nil
+	}
 }
@@ -3,11 +3,12 @@
 package exporter
 
 import (
+	"bytes"
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/tuxofil/p24fetch/schema"
+	"github.com/tuxofil/p24fetch/txn"
 )
 
 // QIF templates
@@ -25,40 +26,40 @@ func ExportToQIF(
 	comissionsAccName string,
 	path string,
 ) error {
-	fd, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
-	if err != nil {
-		return fmt.Errorf("open file: %w", err)
-	}
-	fileInfo, err := fd.Stat()
-	if err != nil {
-		_ = fd.Close()
-		return fmt.Errorf("fstat: %w", err)
-	}
-	if fileInfo.Size() == 0 {
-		if _, err := fd.Write([]byte(fmt.Sprintf(qifHeader, srcAccName))); err != nil {
-			_ = fd.Close()
-			return fmt.Errorf("write header: %w", err)
-		}
+	return exportToQIF(nil, trans, srcAccName, comissionsAccName, path)
+}
+
+// Format transactions to QIF, appending them to the file
+// within the transaction.
+func exportToQIF(
+	tx *txn.Tx,
+	trans []schema.Transaction,
+	srcAccName string,
+	comissionsAccName string,
+	path string,
+) error {
+	header := fmt.Sprintf(qifHeader, srcAccName)
+	data := formatQIF(trans, comissionsAccName)
+	if err := tx.AppendFile(path, []byte(header), data); err != nil {
+		return fmt.Errorf("append to file: %w", err)
 	}
+	return nil
+}
+
+// Format transactions to QIF records without the account header.
+func formatQIF(trans []schema.Transaction, comissionsAccName string) []byte {
+	var buf bytes.Buffer
 	for _, tran := range trans {
-		var s string
 		if comission := tran.Comission(); comission > 0.01 {
-			s = fmt.Sprintf(qifWithComission, tran.Date.Format(dateLayout),
+			fmt.Fprintf(&buf, qifWithComission, tran.Date.Format(dateLayout),
 				tran.SrcVal, rmNLs(tran.Note), tran.Dst, tran.DstVal,
 				comissionsAccName, comission)
 		} else {
-			s = fmt.Sprintf(qifSimple, tran.Date.Format(dateLayout),
+			fmt.Fprintf(&buf, qifSimple, tran.Date.Format(dateLayout),
 				tran.SrcVal, rmNLs(tran.Note), tran.Dst, -tran.SrcVal)
 		}
-		if _, err := fd.Write([]byte(s)); err != nil {
-			_ = fd.Close()
-			return err
-		}
 	}
-	if err := fd.Close(); err != nil {
-		return fmt.Errorf("file close: %w", err)
-	}
-	return nil
+	return buf.Bytes()
 }
 
 // Replace all new line chars
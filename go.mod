@@ -3,6 +3,11 @@ module github.com/tuxofil/p24fetch
 go 1.14
 
 require (
+	filippo.io/age v1.0.0-rc.1
+	github.com/BurntSushi/toml v0.3.1
 	github.com/slack-go/slack v0.6.6
 	github.com/stretchr/testify v1.6.1
+	go.etcd.io/bbolt v1.3.5
+	golang.org/x/sys v0.0.0-20200202164722-d101bd2416d5
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
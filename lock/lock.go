@@ -0,0 +1,141 @@
+// Package lock implements inter-process locks based on flock(2).
+//
+// A lock is a file holding information about its owner. The
+// operating system releases the lock when the owner exits, so a
+// lock file left by a crashed process is taken over on the next
+// acquire and reported as stale.
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// ErrLocked is returned when the lock is held by another owner.
+var ErrLocked = errors.New("locked")
+
+// How often to retry acquiring a busy lock
+const retryInterval = 250 * time.Millisecond
+
+// Owner describes the process holding the lock.
+type Owner struct {
+	PID      int       `json:"pid"`
+	Host     string    `json:"host"`
+	Acquired time.Time `json:"acquired"`
+}
+
+func (o Owner) String() string {
+	return fmt.Sprintf("pid %d on %s since %s",
+		o.PID, o.Host, o.Acquired.Format(time.RFC3339))
+}
+
+// Lock is an acquired lock.
+type Lock struct {
+	fd *os.File
+	// Owner of the lock file left by a process exited
+	// without releasing it. Nil when there was none.
+	Stale *Owner
+}
+
+// Acquire the lock file, creating it when needed. When the lock
+// is busy, retries for up to wait or until the context is done.
+// The error wraps ErrLocked and names the current owner when
+// the lock was not acquired in time.
+func Acquire(ctx context.Context, path string, wait time.Duration) (*Lock, error) {
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		ok, err := tryLock(fd)
+		if err != nil {
+			_ = fd.Close()
+			return nil, err
+		}
+		if ok {
+			break
+		}
+		if !time.Now().Before(deadline) {
+			_ = fd.Close()
+			return nil, lockedError(path)
+		}
+		select {
+		case <-ctx.Done():
+			_ = fd.Close()
+			return nil, ctx.Err()
+		case <-time.After(retryInterval):
+		}
+	}
+	l := &Lock{fd: fd}
+	if owner, err := readOwner(path); err == nil {
+		l.Stale = owner
+	}
+	if err := l.writeOwner(); err != nil {
+		_ = l.Release()
+		return nil, fmt.Errorf("write owner: %w", err)
+	}
+	return l, nil
+}
+
+// Release the lock. The lock file is kept but emptied,
+// so it is not reported as stale by the next owner.
+func (l *Lock) Release() error {
+	err := l.fd.Truncate(0)
+	if uerr := unlock(l.fd); err == nil {
+		err = uerr
+	}
+	if cerr := l.fd.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Write information about the current process to the lock file.
+func (l *Lock) writeOwner() error {
+	host, _ := os.Hostname()
+	data, err := json.Marshal(Owner{
+		PID:      os.Getpid(),
+		Host:     host,
+		Acquired: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if err := l.fd.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := l.fd.WriteAt(data, 0); err != nil {
+		return err
+	}
+	return l.fd.Sync()
+}
+
+// Read the owner from the lock file.
+func readOwner(path string) (*Owner, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("no owner")
+	}
+	var owner Owner
+	if err := json.Unmarshal(data, &owner); err != nil {
+		return nil, err
+	}
+	return &owner, nil
+}
+
+// Build an error for the busy lock naming its owner.
+func lockedError(path string) error {
+	if owner, err := readOwner(path); err == nil {
+		return fmt.Errorf("%w by %s", ErrLocked, owner)
+	}
+	return ErrLocked
+}
@@ -0,0 +1,69 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcquire(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "card.lock")
+	ctx := context.Background()
+
+	l, err := Acquire(ctx, path, 0)
+	require.NoError(t, err)
+	assert.Nil(t, l.Stale)
+
+	// Busy lock
+	_, err = Acquire(ctx, path, 0)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrLocked))
+	assert.Contains(t, err.Error(), "locked by pid")
+
+	// Context cancelled while waiting
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = Acquire(cancelled, path, time.Minute)
+	assert.Equal(t, context.Canceled, err)
+
+	// Waiting for the lock
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		assert.NoError(t, l.Release())
+	}()
+	l, err = Acquire(ctx, path, time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, l.Stale)
+	require.NoError(t, l.Release())
+}
+
+func TestAcquireStale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "card.lock")
+
+	// Lock file left by a crashed process
+	require.NoError(t, ioutil.WriteFile(path,
+		[]byte(`{"pid":42,"host":"box","acquired":"2020-10-01T10:00:00Z"}`), 0600))
+	l, err := Acquire(context.Background(), path, 0)
+	require.NoError(t, err)
+	require.NotNil(t, l.Stale)
+	assert.Equal(t, 42, l.Stale.PID)
+	assert.Equal(t, "pid 42 on box since 2020-10-01T10:00:00Z", l.Stale.String())
+	require.NoError(t, l.Release())
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Empty(t, data)
+}
@@ -0,0 +1,26 @@
+//go:build windows
+// +build windows
+
+package lock
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// Try to lock the file without blocking.
+// Returns false when the file is locked by another owner.
+func tryLock(fd *os.File) (bool, error) {
+	err := windows.LockFileEx(windows.Handle(fd.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY,
+		0, 1, 0, &windows.Overlapped{})
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func unlock(fd *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(fd.Fd()), 0, 1, 0, &windows.Overlapped{})
+}
@@ -0,0 +1,212 @@
+// Package logger implements leveled structured logging.
+//
+// Every record has a level, a message and a list of fields (e.g.
+// merchant, card and run ID) and is written as a line of text
+// or as a JSON object.
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level of a log record
+type Level int
+
+// Levels in order of severity
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses the level name.
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return Info, fmt.Errorf("invalid log level: %#v", s)
+}
+
+// Output formats
+const (
+	Text = "text"
+	JSON = "json"
+)
+
+// Standard field names
+const (
+	FieldMerchant = "merchant"
+	FieldCard     = "card"
+	FieldRun      = "run"
+)
+
+// Time format of records
+const timeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// Logger writes log records. Loggers derived with With
+// share the output. A nil Logger writes to Default().
+type Logger struct {
+	out    *output
+	fields []field
+}
+
+type output struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+	level  Level
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+// New creates a logger writing records of the level
+// and above to w in the format.
+func New(w io.Writer, format string, level Level) (*Logger, error) {
+	switch format {
+	case Text, JSON:
+	default:
+		return nil, fmt.Errorf("invalid log format: %#v", format)
+	}
+	return &Logger{out: &output{w: w, format: format, level: level}}, nil
+}
+
+var std = struct {
+	sync.RWMutex
+	logger *Logger
+}{logger: &Logger{out: &output{w: os.Stderr, format: Text, level: Info}}}
+
+// Default returns the logger used when no other is given.
+// It writes text records of info level and above to stderr
+// until replaced with SetDefault.
+func Default() *Logger {
+	std.RLock()
+	defer std.RUnlock()
+	return std.logger
+}
+
+// SetDefault replaces the default logger.
+func SetDefault(l *Logger) {
+	std.Lock()
+	defer std.Unlock()
+	std.logger = l
+}
+
+// With returns a logger adding the fields to every record.
+// Arguments are key-value pairs.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	if l == nil {
+		l = Default()
+	}
+	fields := make([]field, len(l.fields), len(l.fields)+len(keyvals)/2)
+	copy(fields, l.fields)
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		fields = append(fields, field{key: fmt.Sprint(keyvals[i]), value: keyvals[i+1]})
+	}
+	return &Logger{out: l.out, fields: fields}
+}
+
+// Enabled reports whether records of the level are written.
+func (l *Logger) Enabled(level Level) bool {
+	if l == nil {
+		l = Default()
+	}
+	return level >= l.out.level
+}
+
+// Debugf writes a debug record.
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	l.log(Debug, format, v...)
+}
+
+// Infof writes an info record.
+func (l *Logger) Infof(format string, v ...interface{}) {
+	l.log(Info, format, v...)
+}
+
+// Warnf writes a warning record.
+func (l *Logger) Warnf(format string, v ...interface{}) {
+	l.log(Warn, format, v...)
+}
+
+// Errorf writes an error record.
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	l.log(Error, format, v...)
+}
+
+func (l *Logger) log(level Level, format string, v ...interface{}) {
+	if l == nil {
+		l = Default()
+	}
+	if !l.Enabled(level) {
+		return
+	}
+	msg := fmt.Sprintf(format, v...)
+	now := time.Now().Format(timeFormat)
+	var buf bytes.Buffer
+	if l.out.format == JSON {
+		buf.WriteString(`{"time":`)
+		writeJSON(&buf, now)
+		buf.WriteString(`,"level":`)
+		writeJSON(&buf, level.String())
+		buf.WriteString(`,"msg":`)
+		writeJSON(&buf, msg)
+		for _, f := range l.fields {
+			buf.WriteByte(',')
+			writeJSON(&buf, f.key)
+			buf.WriteByte(':')
+			writeJSON(&buf, f.value)
+		}
+		buf.WriteString("}\n")
+	} else {
+		fmt.Fprintf(&buf, "%s %-5s %s", now, strings.ToUpper(level.String()), msg)
+		for _, f := range l.fields {
+			fmt.Fprintf(&buf, " %s=%s", f.key, quote(fmt.Sprint(f.value)))
+		}
+		buf.WriteByte('\n')
+	}
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	_, _ = l.out.w.Write(buf.Bytes())
+}
+
+func writeJSON(buf *bytes.Buffer, v interface{}) {
+	if err, ok := v.(error); ok {
+		v = err.Error()
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(data)
+}
+
+// Quote the text field value when needed
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\r\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
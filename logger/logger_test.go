@@ -0,0 +1,81 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLevel(t *testing.T) {
+	testset := []struct {
+		Name   string
+		Expect Level
+		Error  bool
+	}{
+		{"debug", Debug, false},
+		{"INFO", Info, false},
+		{"warn", Warn, false},
+		{"error", Error, false},
+		{"verbose", Info, true},
+	}
+	for n, test := range testset {
+		level, err := ParseLevel(test.Name)
+		if test.Error {
+			assert.Error(t, err, "test case #%d: %+v", n, test)
+			continue
+		}
+		require.NoError(t, err, "test case #%d: %+v", n, test)
+		assert.Equal(t, test.Expect, level, "test case #%d: %+v", n, test)
+	}
+}
+
+func TestText(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(&buf, Text, Info)
+	require.NoError(t, err)
+	l.Debugf("hidden")
+	l.With(FieldMerchant, "My card", FieldRun, "r1").Warnf("low %s", "balance")
+	l.Errorf("failed")
+	assert.Regexp(t, regexp.MustCompile(`^\S+ WARN  low balance merchant="My card" run=r1\n`+
+		`\S+ ERROR failed\n$`), buf.String())
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(&buf, JSON, Debug)
+	require.NoError(t, err)
+	base := l.With(FieldMerchant, "main")
+	base.With(FieldRun, "r1").Debugf("fetched %d", 3)
+	base.With("err", errors.New("boom")).Infof("done")
+	var records []map[string]interface{}
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		var record map[string]interface{}
+		require.NoError(t, decoder.Decode(&record))
+		delete(record, "time")
+		records = append(records, record)
+	}
+	assert.Equal(t, []map[string]interface{}{
+		{"level": "debug", "msg": "fetched 3", "merchant": "main", "run": "r1"},
+		{"level": "info", "msg": "done", "merchant": "main", "err": "boom"},
+	}, records)
+
+	_, err = New(&buf, "xml", Info)
+	assert.Error(t, err)
+}
+
+func TestDefault(t *testing.T) {
+	defer SetDefault(Default())
+	var buf bytes.Buffer
+	l, err := New(&buf, Text, Info)
+	require.NoError(t, err)
+	SetDefault(l)
+	var nilLogger *Logger
+	nilLogger.Infof("to default")
+	assert.Contains(t, buf.String(), "INFO  to default\n")
+}
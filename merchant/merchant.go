@@ -14,8 +14,12 @@ import (
 
 	"github.com/tuxofil/p24fetch/config"
 	"github.com/tuxofil/p24fetch/schema"
+	"github.com/tuxofil/p24fetch/source"
 )
 
+// Privat24 API endpoint used when api_url is not configured
+const DefaultAPIURL = "https://api.privatbank.ua/p24api/rest_fiz"
+
 type Merchant struct {
 	// Configuration used to create the Merchant
 	config config.Config
@@ -41,15 +45,23 @@ func New(cfg *config.Config) (*Merchant, error) {
 	}, nil
 }
 
-// Fetch transaction log for the configured account.
-func (m *Merchant) FetchLog(ctx context.Context) ([]schema.XMLTransaction, error) {
+// Identity of the API client. Privat24 API limits
+// request rate per merchant ID.
+func (m *Merchant) Identity() string {
+	return fmt.Sprintf("privat24:%d", m.config.MerchantID)
+}
+
+// Limits of requests to the Privat24 API.
+func (m *Merchant) Limits() source.Limits {
+	return source.Limits{Interval: 10 * time.Second}
+}
+
+// Fetch transactions of the configured account made in the period.
+func (m *Merchant) Fetch(ctx context.Context, fromDate, toDate time.Time) ([]schema.XMLTransaction, error) {
 	var (
 		wait      = 10 // in seconds
 		test      = 0
 		paymentID = fmt.Sprintf("%x", rand.Uint64())
-		fromDate  = time.Now().UTC().Add(-time.Hour * 24 *
-			time.Duration(m.config.Days))
-		toDate = time.Now().UTC()
 	)
 
 	// Generate request body
@@ -62,8 +74,8 @@ func (m *Merchant) FetchLog(ctx context.Context) ([]schema.XMLTransaction, error
 		`  <prop name="card" value="%s" />`+
 		`</payment>`,
 		wait, test, paymentID,
-		fromDate.Format("02.01.2006"),
-		toDate.Format("02.01.2006"),
+		fromDate.UTC().Format("02.01.2006"),
+		toDate.UTC().Format("02.01.2006"),
 		m.config.CardNumber)
 	signature := sha1hex(md5hex(data + m.config.MerchantPassword))
 	reqBuf := bytes.NewBufferString(fmt.Sprintf(
@@ -78,8 +90,12 @@ func (m *Merchant) FetchLog(ctx context.Context) ([]schema.XMLTransaction, error
 		m.config.MerchantID, signature, data))
 
 	// Perform HTTP request
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
-		"https://api.privatbank.ua/p24api/rest_fiz", reqBuf)
+	started := time.Now()
+	url := m.config.APIURL
+	if url == "" {
+		url = DefaultAPIURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, reqBuf)
 	if err != nil {
 		return nil, fmt.Errorf("create request: %w", err)
 	}
@@ -104,6 +120,8 @@ func (m *Merchant) FetchLog(ctx context.Context) ([]schema.XMLTransaction, error
 	if reason := parsedXML.Data.Error.Message; reason != "" {
 		return nil, fmt.Errorf("API error: %s", reason)
 	}
+	m.config.Log().Debugf("API returned %d transactions in %s",
+		len(parsedXML.Data.Info.Statements.Statement), time.Since(started))
 
 	// Revert the list
 	var (
@@ -1,9 +1,17 @@
 package merchant
 
 import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tuxofil/p24fetch/config"
 )
 
 func TestMD5Hex(t *testing.T) {
@@ -15,3 +23,37 @@ func TestSHA1Hex(t *testing.T) {
 	assert.Equal(t, "da39a3ee5e6b4b0d3255bfef95601890afd80709", sha1hex(""))
 	assert.Equal(t, "86f7e437faa5a7fce15d1ddcb9eaeaea377667b8", sha1hex("a"))
 }
+
+func TestFetch(t *testing.T) {
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Signature string `xml:"merchant>signature"`
+		}
+		require.NoError(t, xml.NewDecoder(r.Body).Decode(&req))
+		signature = req.Signature
+		if r.URL.Query().Get("fail") != "" {
+			_, _ = w.Write([]byte(`<response><data><error message="invalid signature"/></data></response>`))
+			return
+		}
+		_, _ = w.Write([]byte(`<response><data><info><statements status="excellent">
+<statement card="1234" trandate="2020-01-02" amount="10.00 UAH" description="second"/>
+<statement card="1234" trandate="2020-01-01" amount="5.00 UAH" description="first"/>
+</statements></info></data></response>`))
+	}))
+	defer server.Close()
+
+	m, err := New(&config.Config{MerchantID: 1, MerchantPassword: "secret",
+		CardNumber: "1234", APIURL: server.URL})
+	require.NoError(t, err)
+	trans, err := m.Fetch(context.Background(), time.Now().AddDate(0, 0, -1), time.Now())
+	require.NoError(t, err)
+	require.Len(t, trans, 2)
+	assert.Equal(t, "first", trans[0].Description)
+	assert.Len(t, signature, 40)
+
+	m, err = New(&config.Config{MerchantID: 2, APIURL: server.URL + "?fail=1"})
+	require.NoError(t, err)
+	_, err = m.Fetch(context.Background(), time.Now().AddDate(0, 0, -1), time.Now())
+	assert.EqualError(t, err, "API error: invalid signature")
+}
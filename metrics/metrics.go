@@ -0,0 +1,230 @@
+// Package metrics implements a minimal set of metric types
+// exposed in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metric types
+const (
+	counter   = "counter"
+	gauge     = "gauge"
+	histogram = "histogram"
+)
+
+// DefBuckets are default histogram buckets (in seconds).
+var DefBuckets = []float64{.1, .25, .5, 1, 2.5, 5, 10, 25, 60}
+
+// Registry holds all registered metric families.
+type Registry struct {
+	// Guards families
+	mu sync.Mutex
+	// Registered families, in registration order
+	families []*family
+}
+
+// Counter is a family of monotonically increasing values.
+type Counter struct{ *family }
+
+// Gauge is a family of arbitrary values.
+type Gauge struct{ *family }
+
+// Histogram is a family of sampled observations counted
+// in configurable buckets.
+type Histogram struct{ *family }
+
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	// Guards series
+	mu sync.Mutex
+	// Label values joined with zero byte -> series
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	// Value for counters and gauges, sum for histograms
+	value float64
+	// Histogram only: count of observations per bucket
+	counts []uint64
+	// Histogram only: total count of observations
+	count uint64
+}
+
+// NewRegistry creates new empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewCounter registers new counter family.
+func (r *Registry) NewCounter(name, help string, labels ...string) Counter {
+	return Counter{r.register(name, help, counter, labels, nil)}
+}
+
+// NewGauge registers new gauge family.
+func (r *Registry) NewGauge(name, help string, labels ...string) Gauge {
+	return Gauge{r.register(name, help, gauge, labels, nil)}
+}
+
+// NewHistogram registers new histogram family.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) Histogram {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return Histogram{r.register(name, help, histogram, labels, b)}
+}
+
+func (r *Registry) register(name, help, kind string, labels []string, buckets []float64) *family {
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+	r.mu.Lock()
+	r.families = append(r.families, f)
+	r.mu.Unlock()
+	return f
+}
+
+// Add increases the counter by v. Negative values are ignored.
+func (c Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	c.update(labelValues, func(s *series) { s.value += v })
+}
+
+// Inc increases the counter by 1.
+func (c Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Set sets the gauge value.
+func (g Gauge) Set(v float64, labelValues ...string) {
+	g.update(labelValues, func(s *series) { s.value = v })
+}
+
+// Observe adds a single observation to the histogram.
+func (h Histogram) Observe(v float64, labelValues ...string) {
+	h.update(labelValues, func(s *series) {
+		for i, bound := range h.buckets {
+			if v <= bound {
+				s.counts[i]++
+			}
+		}
+		s.count++
+		s.value += v
+	})
+}
+
+func (f *family) update(labelValues []string, fun func(s *series)) {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("%s: expected %d label values, got %d",
+			f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\x00")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(f.buckets)),
+		}
+		f.series[key] = s
+	}
+	fun(s)
+}
+
+// WriteTo writes all metrics in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	r.mu.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mu.Unlock()
+	for _, f := range families {
+		f.write(&buf)
+	}
+	return buf.WriteTo(w)
+}
+
+// ServeHTTP implements http.Handler interface.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+func (f *family) write(buf *bytes.Buffer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fmt.Fprintf(buf, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(buf, "# TYPE %s %s\n", f.name, f.kind)
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != histogram {
+			fmt.Fprintf(buf, "%s%s %s\n", f.name,
+				formatLabels(f.labels, s.labelValues, "", ""),
+				formatValue(s.value))
+			continue
+		}
+		for i, bound := range f.buckets {
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name,
+				formatLabels(f.labels, s.labelValues, "le", formatValue(bound)),
+				s.counts[i])
+		}
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name,
+			formatLabels(f.labels, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(buf, "%s_sum%s %s\n", f.name,
+			formatLabels(f.labels, s.labelValues, "", ""), formatValue(s.value))
+		fmt.Fprintf(buf, "%s_count%s %d\n", f.name,
+			formatLabels(f.labels, s.labelValues, "", ""), s.count)
+	}
+}
+
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, name+"="+strconv.Quote(values[i]))
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+"="+strconv.Quote(extraValue))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
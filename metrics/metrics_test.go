@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "Test counter.", "merchant", "class")
+	g := r.NewGauge("test_gauge", "Test gauge.")
+	h := r.NewHistogram("test_seconds", "Test histogram.", []float64{1, 0.5}, "merchant")
+
+	c.Inc("card", "fetch")
+	c.Add(2, "card", "fetch")
+	c.Add(-1, "card", "fetch")
+	c.Inc(`a "quoted"`, "export")
+	g.Set(-3.5)
+	h.Observe(0.3, "card")
+	h.Observe(0.7, "card")
+	h.Observe(2, "card")
+
+	var buf bytes.Buffer
+	_, err := r.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{merchant="a \"quoted\"",class="export"} 1
+test_total{merchant="card",class="fetch"} 3
+# HELP test_gauge Test gauge.
+# TYPE test_gauge gauge
+test_gauge -3.5
+# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{merchant="card",le="0.5"} 1
+test_seconds_bucket{merchant="card",le="1"} 2
+test_seconds_bucket{merchant="card",le="+Inf"} 3
+test_seconds_sum{merchant="card"} 3
+test_seconds_count{merchant="card"} 3
+`, buf.String())
+}
+
+func TestLabelsMismatch(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "Test counter.", "merchant")
+	assert.Panics(t, func() { c.Inc() })
+}
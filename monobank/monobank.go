@@ -0,0 +1,306 @@
+// Package monobank fetches card statements from
+// the Monobank personal API.
+//
+// Transactions are converted to the form returned by the Privat24
+// merchant API, so they are deduplicated, sorted, exported and
+// archived the same way as transactions of Privat24 cards.
+package monobank
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/schema"
+	"github.com/tuxofil/p24fetch/source"
+)
+
+// Monobank API endpoint used when api_url is not configured
+const DefaultAPIURL = "https://api.monobank.ua"
+
+// Monobank API allows a request per minute per token
+// and returns up to 500 transactions of 31 days at most.
+const (
+	requestInterval = time.Minute
+	maxPeriod       = 31 * 24 * time.Hour
+	pageLimit       = 500
+)
+
+// Currencies by ISO 4217 numeric code
+var currencies = map[int]schema.Currency{
+	980: schema.UAH,
+	840: schema.USD,
+	978: schema.EUR,
+}
+
+// Statements use Kyiv time as Privat24 API does
+var location = loadLocation()
+
+func loadLocation() *time.Location {
+	if loc, err := time.LoadLocation("Europe/Kiev"); err == nil {
+		return loc
+	}
+	return time.Local
+}
+
+type Client struct {
+	// Configuration used to create the Client
+	config config.Config
+	// HTTP client
+	httpClient *http.Client
+	// Account of the configured card. Found on the first fetch.
+	account *account
+	// Interval between requests of statement pages
+	pageInterval time.Duration
+}
+
+// Create new Client instance.
+func New(cfg *config.Config) (*Client, error) {
+	return &Client{
+		config: *cfg,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		pageInterval: requestInterval,
+	}, nil
+}
+
+// Client information. Only fields used are listed.
+type clientInfo struct {
+	Accounts []account `json:"accounts"`
+}
+
+type account struct {
+	ID           string   `json:"id"`
+	CurrencyCode int      `json:"currencyCode"`
+	MaskedPan    []string `json:"maskedPan"`
+	IBAN         string   `json:"iban"`
+}
+
+// Statement item. Only fields used are listed.
+type statementItem struct {
+	ID string `json:"id"`
+	// Unix time
+	Time        int64  `json:"time"`
+	Description string `json:"description"`
+	MCC         int    `json:"mcc"`
+	// In minor units of the account currency, negative for debit
+	Amount int64 `json:"amount"`
+	// In minor units of the operation currency
+	OperationAmount int64 `json:"operationAmount"`
+	// Operation currency
+	CurrencyCode int `json:"currencyCode"`
+	// Account balance after the operation
+	Balance       int64  `json:"balance"`
+	Comment       string `json:"comment"`
+	CounterEdrpou string `json:"counterEdrpou"`
+	CounterIban   string `json:"counterIban"`
+	CounterName   string `json:"counterName"`
+}
+
+// Identity of the API client. Requests are rate limited per token.
+func (c *Client) Identity() string {
+	return "monobank:" + source.SecretKey(c.config.MonobankToken)
+}
+
+// Limits of requests to the Monobank API.
+func (c *Client) Limits() source.Limits {
+	return source.Limits{Interval: requestInterval, MaxPeriod: maxPeriod}
+}
+
+// Fetch transactions of the configured card made in the period,
+// the oldest first. When the period has more transactions than
+// returned at once, the rest is fetched with more requests
+// separated by the rate limit interval.
+func (c *Client) Fetch(ctx context.Context, from, to time.Time) ([]schema.XMLTransaction, error) {
+	if c.account == nil {
+		acc, err := c.findAccount(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.account = acc
+	}
+	// Periods of consecutive fetches meet at the boundary
+	to = to.Add(-time.Second)
+	var (
+		items []statementItem
+		seen  = map[string]bool{}
+	)
+	for {
+		var page []statementItem
+		path := fmt.Sprintf("/personal/statement/%s/%d/%d",
+			c.account.ID, from.Unix(), to.Unix())
+		if err := c.get(ctx, path, &page); err != nil {
+			return nil, fmt.Errorf("fetch statement: %w", err)
+		}
+		for _, item := range page {
+			if !seen[item.ID] {
+				seen[item.ID] = true
+				items = append(items, item)
+			}
+		}
+		if len(page) < pageLimit {
+			break
+		}
+		// Items are sorted from the newest one. When the whole
+		// page is made in the same second, the rest of the second
+		// can't be fetched, so the next page starts before it.
+		next := time.Unix(page[len(page)-1].Time, 0)
+		if !next.Before(to) {
+			c.config.Log().Warnf("more than %d transactions made at %s, "+
+				"some of them may be missing", pageLimit, to.Format(time.RFC3339))
+			next = to.Add(-time.Second)
+		}
+		if next.Before(from) {
+			break
+		}
+		to = next
+		if err := sleep(ctx, c.pageInterval); err != nil {
+			return nil, err
+		}
+	}
+	c.config.Log().Debugf("API returned %d transactions", len(items))
+	res := make([]schema.XMLTransaction, len(items))
+	for i, item := range items {
+		res[len(items)-1-i] = item.toXML(c.config.CardNumber, c.account.CurrencyCode)
+	}
+	return res, nil
+}
+
+// Find the account of the configured card
+// by the card number or by IBAN.
+func (c *Client) findAccount(ctx context.Context) (*account, error) {
+	var info clientInfo
+	if err := c.get(ctx, "/personal/client-info", &info); err != nil {
+		return nil, fmt.Errorf("fetch client info: %w", err)
+	}
+	card := c.config.CardNumber
+	for i, acc := range info.Accounts {
+		if acc.IBAN == card {
+			return &info.Accounts[i], nil
+		}
+		for _, pan := range acc.MaskedPan {
+			if panMatches(pan, card) {
+				return &info.Accounts[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no account of card %s", c.config.MaskedCard())
+}
+
+// Check if the masked card number (e.g. 537541******1234)
+// matches the card number.
+func panMatches(pan, card string) bool {
+	i := strings.Index(pan, "*")
+	j := strings.LastIndex(pan, "*")
+	if i < 0 {
+		return pan == card
+	}
+	return len(card) == len(pan) &&
+		strings.HasPrefix(card, pan[:i]) && strings.HasSuffix(card, pan[j+1:])
+}
+
+// Send GET request and parse JSON response.
+func (c *Client) get(ctx context.Context, path string, v interface{}) error {
+	apiURL := c.config.APIURL
+	if apiURL == "" {
+		apiURL = DefaultAPIURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		strings.TrimSuffix(apiURL, "/")+path, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "p24fetch")
+	req.Header.Set("X-Token", c.config.MonobankToken)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("do request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Description string `json:"errorDescription"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Description != "" {
+			return fmt.Errorf("API error: %s", apiErr.Description)
+		}
+		return fmt.Errorf("invalid response status: %s", resp.Status)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("parse json: %w", err)
+	}
+	return nil
+}
+
+// Convert the item to the form of the Privat24 merchant API.
+func (i statementItem) toXML(card string, accountCurrency int) schema.XMLTransaction {
+	date := time.Unix(i.Time, 0).In(location)
+	operationAmount := i.OperationAmount
+	if operationAmount < 0 {
+		operationAmount = -operationAmount
+	}
+	// Description is the merchant name for card payments
+	// and the payment purpose for transfers
+	terminal, note := i.Description, i.Comment
+	if i.CounterName != "" {
+		terminal = i.CounterName
+		note = strings.TrimSpace(i.Description + " " + i.Comment)
+	}
+	var mcc string
+	if i.MCC != 0 {
+		mcc = fmt.Sprintf("%04d", i.MCC)
+	}
+	return schema.XMLTransaction{
+		Card:                card,
+		AppCode:             i.ID,
+		TranDate:            date.Format("2006-01-02"),
+		TranTime:            date.Format("15:04:05"),
+		Amount:              formatAmount(operationAmount, i.CurrencyCode),
+		CardAmount:          formatAmount(i.Amount, accountCurrency),
+		Rest:                formatAmount(i.Balance, accountCurrency),
+		Terminal:            terminal,
+		Description:         note,
+		CounterpartyCode:    i.CounterEdrpou,
+		CounterpartyAccount: i.CounterIban,
+		MCC:                 mcc,
+	}
+}
+
+// Format amount in minor units as "-12.34 UAH". Unknown
+// currencies are left as numeric codes, so the transaction
+// fails to parse and is reported as unsorted.
+func formatAmount(minor int64, currencyCode int) string {
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	currency, ok := currencies[currencyCode]
+	if !ok {
+		currency = schema.Currency(strconv.Itoa(currencyCode))
+	}
+	return fmt.Sprintf("%s%d.%02d %s", sign, minor/100, minor%100, currency)
+}
+
+// Wait for the duration or until the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
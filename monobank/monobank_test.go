@@ -0,0 +1,164 @@
+package monobank
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/schema"
+	"github.com/tuxofil/p24fetch/source"
+)
+
+func TestFetch(t *testing.T) {
+	var statements []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errorDescription":"Unknown 'X-Token'"}`)
+			return
+		}
+		switch {
+		case r.URL.Path == "/personal/client-info":
+			fmt.Fprint(w, `{"accounts":[
+				{"id":"uah1","currencyCode":980,"maskedPan":["537541******5678"],"iban":"UA01"},
+				{"id":"usd1","currencyCode":840,"maskedPan":["537541******1234"],"iban":"UA02"}]}`)
+		case strings.HasPrefix(r.URL.Path, "/personal/statement/usd1/"):
+			statements = append(statements, r.URL.Path)
+			fmt.Fprint(w, `[
+				{"id":"b","time":1614600000,"description":"Silpo","mcc":5411,
+				 "amount":-1000,"operationAmount":-28000,"currencyCode":980,"balance":99000},
+				{"id":"a","time":1614500000,"description":"From: John","counterName":"John Doe",
+				 "counterEdrpou":"1234567890","counterIban":"UA03","comment":"gift",
+				 "amount":10000,"operationAmount":10000,"currencyCode":840,"balance":100000}]`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		Source:        config.SourceMonobank,
+		MonobankToken: "secret",
+		CardNumber:    "5375410000001234",
+		APIURL:        server.URL,
+	}
+	c, err := New(cfg)
+	require.NoError(t, err)
+	to := time.Unix(1614700000, 0)
+	trans, err := c.Fetch(context.Background(), to.Add(-48*time.Hour), to)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/personal/statement/usd1/1614527200/1614699999"}, statements)
+	require.Len(t, trans, 2)
+
+	// The oldest first
+	assert.Equal(t, "a", trans[0].AppCode)
+	assert.Equal(t, "100.00 USD", trans[0].CardAmount)
+	assert.Equal(t, "John Doe", trans[0].Terminal)
+	assert.Equal(t, "From: John gift", trans[0].Description)
+	assert.Equal(t, "1234567890", trans[0].CounterpartyCode)
+	assert.Equal(t, "UA03", trans[0].CounterpartyAccount)
+	assert.Equal(t, "", trans[0].MCC)
+
+	tran := schema.ParseTransaction(trans[1])
+	assert.Equal(t, "", tran.Error)
+	assert.Equal(t, float32(-10), tran.SrcVal)
+	assert.Equal(t, schema.USD, tran.SrcCur)
+	assert.Equal(t, float32(280), tran.DstVal)
+	assert.Equal(t, schema.UAH, tran.DstCur)
+	assert.Equal(t, "Silpo", tran.Dst)
+	assert.Equal(t, "5411", tran.MCC)
+	assert.Equal(t, "990.00 USD", trans[1].Rest)
+
+	// The account is looked up once
+	_, err = c.Fetch(context.Background(), to.Add(-time.Hour), to)
+	require.NoError(t, err)
+	assert.Len(t, statements, 2)
+
+	// Unknown cards and API errors are reported
+	cfg.CardNumber = "4149000000000000"
+	c, err = New(cfg)
+	require.NoError(t, err)
+	_, err = c.Fetch(context.Background(), to.Add(-time.Hour), to)
+	assert.EqualError(t, err, "no account of card XXXX0000")
+
+	cfg.MonobankToken = "invalid"
+	c, err = New(cfg)
+	require.NoError(t, err)
+	_, err = source.FetchLog(context.Background(), c, 1)
+	assert.EqualError(t, err, "fetch client info: API error: Unknown 'X-Token'")
+}
+
+func TestFetchPages(t *testing.T) {
+	const ts = 1614600000
+	var periods []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/personal/client-info":
+			fmt.Fprint(w, `{"accounts":[{"id":"uah1","currencyCode":980,"maskedPan":["537541******1234"]}]}`)
+		case strings.HasPrefix(r.URL.Path, "/personal/statement/uah1/"):
+			periods = append(periods, strings.TrimPrefix(r.URL.Path, "/personal/statement/uah1/"))
+			// A full page of transactions made at the same second
+			var items []string
+			if len(periods) == 1 {
+				for i := 0; i < pageLimit; i++ {
+					items = append(items, fmt.Sprintf(`{"id":"a%d","time":%d,"amount":-100,`+
+						`"operationAmount":-100,"currencyCode":980,"balance":0}`, i, ts))
+				}
+			} else {
+				items = append(items, fmt.Sprintf(`{"id":"b","time":%d,"amount":-100,`+
+					`"operationAmount":-100,"currencyCode":980,"balance":0}`, ts-10))
+			}
+			fmt.Fprint(w, "["+strings.Join(items, ",")+"]")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c, err := New(&config.Config{
+		Source:        config.SourceMonobank,
+		MonobankToken: "secret",
+		CardNumber:    "5375410000001234",
+		APIURL:        server.URL,
+	})
+	require.NoError(t, err)
+	c.pageInterval = 0
+	trans, err := c.Fetch(context.Background(), time.Unix(ts-3600, 0), time.Unix(ts+1, 0))
+	require.NoError(t, err)
+	assert.Len(t, trans, pageLimit+1)
+	assert.Equal(t, []string{
+		fmt.Sprintf("%d/%d", ts-3600, ts),
+		fmt.Sprintf("%d/%d", ts-3600, ts-1),
+	}, periods)
+}
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		minor    int64
+		currency int
+		expected string
+	}{
+		{0, 980, "0.00 UAH"},
+		{5, 980, "0.05 UAH"},
+		{-12345, 978, "-123.45 EUR"},
+		{100, 985, "1.00 985"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, formatAmount(test.minor, test.currency))
+	}
+}
+
+func TestPanMatches(t *testing.T) {
+	assert.True(t, panMatches("537541******1234", "5375410000001234"))
+	assert.False(t, panMatches("537541******1234", "5375410000005678"))
+	assert.False(t, panMatches("537541******1234", "53754101234"))
+	assert.True(t, panMatches("5375410000001234", "5375410000001234"))
+}
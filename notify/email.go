@@ -0,0 +1,83 @@
+package notify
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+
+	"github.com/tuxofil/p24fetch/config"
+)
+
+// Email sends notifications with SMTP.
+type Email struct {
+	base
+	// SMTP server address (host:port)
+	addr string
+	// SMTP authentication. Nil when not configured.
+	auth smtp.Auth
+	// Sender address
+	from string
+	// Recipient addresses
+	to []string
+	// Sends the mail. Replaced in tests.
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func newEmail(b base, cfg config.NotifierConfig) *Email {
+	e := &Email{
+		base:     b,
+		addr:     cfg.SMTPAddr,
+		from:     cfg.EmailFrom,
+		to:       cfg.EmailTo,
+		sendMail: smtp.SendMail,
+	}
+	if cfg.SMTPUser != "" {
+		host, _, _ := net.SplitHostPort(cfg.SMTPAddr)
+		e.auth = smtp.PlainAuth("", cfg.SMTPUser, cfg.SMTPPassword, host)
+	}
+	return e
+}
+
+// Name implements Notifier interface.
+func (e *Email) Name() string {
+	return "email"
+}
+
+// ReportRun implements Notifier interface.
+func (e *Email) ReportRun(run Run) error {
+	if len(run.Unsorted) == 0 {
+		return nil
+	}
+	text, err := e.render(TemplateUnsorted, run)
+	if err != nil {
+		return err
+	}
+	return e.send(fmt.Sprintf("p24fetch: %d unsorted transaction(s) from %s",
+		len(run.Unsorted), run.Merchant), text)
+}
+
+// Send implements Notifier interface.
+func (e *Email) Send(message string) error {
+	text, err := e.render(TemplateMessage, Message{Merchant: e.merchant, Text: message})
+	if err != nil {
+		return err
+	}
+	return e.send("p24fetch: "+e.merchant, text)
+}
+
+func (e *Email) send(subject, body string) error {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", e.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	if err := e.sendMail(e.addr, e.auth, e.from, e.to, []byte(msg.String())); err != nil {
+		return fmt.Errorf("send mail: %w", err)
+	}
+	return nil
+}
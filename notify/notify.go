@@ -0,0 +1,125 @@
+// Package notify defines the notifier interface and implements
+// Telegram, email and webhook notification backends.
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/schema"
+)
+
+// Template kinds
+const (
+	// Executed with Run as data
+	TemplateUnsorted = "unsorted"
+	// Executed with Message as data
+	TemplateMessage = "message"
+)
+
+// Run describes results of a single merchant processing.
+type Run struct {
+	// Merchant name
+	Merchant string
+	// Transactions mapped to accounts
+	Sorted []schema.Transaction
+	// Transactions not mapped to any account
+	Unsorted []schema.Transaction
+	// Ignored transactions
+	Ignored []schema.Transaction
+	// Mapping: ShortID -> GnuCash Account ID
+	Accounts map[string]string
+}
+
+// Message is a free-form message.
+type Message struct {
+	// Merchant name
+	Merchant string
+	// Message text
+	Text string
+}
+
+// Notifier delivers notifications to users.
+type Notifier interface {
+	// Name returns notifier name for logging.
+	Name() string
+	// ReportRun reports results of a merchant processing.
+	// Called once per processed merchant.
+	ReportRun(run Run) error
+	// Send delivers a free-form message.
+	Send(message string) error
+}
+
+// Default templates
+var defaultTemplates = map[string]string{
+	TemplateUnsorted: `{{len .Unsorted}} unsorted transaction(s) from {{.Merchant}}:
+{{range .Unsorted}}
+{{.Date.Format "2006-01-02 15:04"}}  {{printf "%.2f" .SrcVal}} {{.SrcCur}}  {{.Dst}}
+  {{.Note}}{{if .Error}} ({{.Error}}){{end}}
+{{end}}`,
+	TemplateMessage: `{{.Merchant}}: {{.Text}}`,
+}
+
+// New creates notifiers configured for the merchant.
+func New(cfg *config.Config) ([]Notifier, error) {
+	var res []Notifier
+	for i, ncfg := range cfg.Notifiers {
+		templates, err := parseTemplates(ncfg.Templates)
+		if err != nil {
+			return nil, fmt.Errorf("notifier #%d: %w", i, err)
+		}
+		base := base{merchant: cfg.MerchantName, templates: templates}
+		var n Notifier
+		switch ncfg.Type {
+		case config.NotifierTelegram:
+			n = newTelegram(base, ncfg)
+		case config.NotifierEmail:
+			n = newEmail(base, ncfg)
+		case config.NotifierWebhook:
+			n = newWebhook(base, ncfg)
+		default:
+			return nil, fmt.Errorf("notifier #%d: unknown type: %#v", i, ncfg.Type)
+		}
+		res = append(res, n)
+	}
+	return res, nil
+}
+
+// ParseTemplates parses message templates, using defaults
+// for missing ones.
+func parseTemplates(custom map[string]string) (*template.Template, error) {
+	root := template.New("")
+	for kind, text := range defaultTemplates {
+		if s, ok := custom[kind]; ok {
+			text = s
+		}
+		if _, err := root.New(kind).Parse(text); err != nil {
+			return nil, fmt.Errorf("template %#v: %w", kind, err)
+		}
+	}
+	for kind := range custom {
+		if _, ok := defaultTemplates[kind]; !ok {
+			return nil, fmt.Errorf("unknown template: %#v", kind)
+		}
+	}
+	return root, nil
+}
+
+// Common part of all notifiers
+type base struct {
+	// Merchant name
+	merchant string
+	// Message templates
+	templates *template.Template
+}
+
+// Render the message of given kind.
+func (b *base) render(kind string, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := b.templates.ExecuteTemplate(&buf, kind, data); err != nil {
+		return "", fmt.Errorf("render %s: %w", kind, err)
+	}
+	return buf.String(), nil
+}
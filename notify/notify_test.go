@@ -0,0 +1,160 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/schema"
+)
+
+var testRun = Run{
+	Merchant: "card",
+	Unsorted: []schema.Transaction{
+		{Date: time.Date(2020, 9, 20, 12, 0, 0, 0, time.UTC),
+			SrcVal: -10, SrcCur: schema.UAH, Dst: "Bakery", Note: "bread"},
+	},
+}
+
+// Start HTTP server recording decoded JSON requests.
+func newRecorder(t *testing.T, response string) (*httptest.Server, *[]map[string]interface{}) {
+	var requests []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		req["path"] = r.URL.Path
+		req["auth"] = r.Header.Get("Authorization")
+		requests = append(requests, req)
+		_, _ = w.Write([]byte(response))
+	}))
+	return server, &requests
+}
+
+func TestNew(t *testing.T) {
+	testset := []struct {
+		Notifiers     []config.NotifierConfig
+		ExpectSuccess bool
+	}{
+		{nil, true},
+		{[]config.NotifierConfig{{Type: "pigeon"}}, false},
+		{[]config.NotifierConfig{{Type: config.NotifierWebhook,
+			Templates: map[string]string{"unknown": "text"}}}, false},
+		{[]config.NotifierConfig{{Type: config.NotifierWebhook,
+			Templates: map[string]string{TemplateUnsorted: "{{"}}}, false},
+		{[]config.NotifierConfig{
+			{Type: config.NotifierWebhook,
+				Templates: map[string]string{TemplateUnsorted: "{{.Merchant}}"}},
+			{Type: config.NotifierTelegram},
+			{Type: config.NotifierEmail},
+		}, true},
+	}
+	for n, test := range testset {
+		res, err := New(&config.Config{Notifiers: test.Notifiers})
+		if test.ExpectSuccess {
+			assert.NoError(t, err, "test #%d: %+v", n, test)
+			assert.Len(t, res, len(test.Notifiers), "test #%d: %+v", n, test)
+		} else {
+			assert.Error(t, err, "test #%d: %+v", n, test)
+		}
+	}
+}
+
+func TestTelegram(t *testing.T) {
+	server, requests := newRecorder(t, `{"ok": true}`)
+	defer server.Close()
+	notifiers, err := New(&config.Config{
+		MerchantName: "card",
+		Notifiers: []config.NotifierConfig{{
+			Type:           config.NotifierTelegram,
+			TelegramToken:  "123:abc",
+			TelegramChatID: "-100",
+		}},
+	})
+	require.NoError(t, err)
+	tg := notifiers[0].(*Telegram)
+	tg.apiURL = server.URL
+
+	require.NoError(t, tg.ReportRun(Run{Merchant: "card"}))
+	assert.Len(t, *requests, 0)
+	require.NoError(t, tg.ReportRun(testRun))
+	require.NoError(t, tg.Send("hello"))
+	require.Len(t, *requests, 2)
+	assert.Equal(t, "/bot123:abc/sendMessage", (*requests)[0]["path"])
+	assert.Equal(t, "-100", (*requests)[0]["chat_id"])
+	assert.Equal(t, "1 unsorted transaction(s) from card:\n\n"+
+		"2020-09-20 12:00  -10.00 UAH  Bakery\n  bread\n",
+		(*requests)[0]["text"])
+	assert.Equal(t, "card: hello", (*requests)[1]["text"])
+
+	tg.apiURL = "http://127.0.0.1:1"
+	assert.Error(t, tg.Send("hello"))
+}
+
+func TestWebhook(t *testing.T) {
+	server, requests := newRecorder(t, "")
+	defer server.Close()
+	notifiers, err := New(&config.Config{
+		MerchantName: "card",
+		Notifiers: []config.NotifierConfig{{
+			Type:           config.NotifierWebhook,
+			WebhookURL:     server.URL + "/hook",
+			WebhookHeaders: map[string]string{"Authorization": "Bearer x"},
+			Templates: map[string]string{
+				TemplateUnsorted: "{{.Merchant}}: {{len .Unsorted}}",
+			},
+		}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, notifiers[0].ReportRun(testRun))
+	require.Len(t, *requests, 1)
+	req := (*requests)[0]
+	assert.Equal(t, "/hook", req["path"])
+	assert.Equal(t, "Bearer x", req["auth"])
+	assert.Equal(t, "unsorted", req["event"])
+	assert.Equal(t, "card: 1", req["text"])
+	assert.Len(t, req["transactions"], 1)
+}
+
+func TestEmail(t *testing.T) {
+	notifiers, err := New(&config.Config{
+		MerchantName: "card",
+		Notifiers: []config.NotifierConfig{{
+			Type:         config.NotifierEmail,
+			SMTPAddr:     "smtp.example.com:587",
+			SMTPUser:     "user",
+			SMTPPassword: "secret",
+			EmailFrom:    "p24fetch@example.com",
+			EmailTo:      []string{"a@example.com", "b@example.com"},
+		}},
+	})
+	require.NoError(t, err)
+	email := notifiers[0].(*Email)
+	var sent []string
+	email.sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		assert.Equal(t, "smtp.example.com:587", addr)
+		assert.NotNil(t, a)
+		assert.Equal(t, []string{"a@example.com", "b@example.com"}, to)
+		sent = append(sent, string(msg))
+		return nil
+	}
+	require.NoError(t, email.ReportRun(testRun))
+	require.Len(t, sent, 1)
+	assert.Contains(t, sent[0], "Subject: p24fetch: 1 unsorted transaction(s) from card\r\n")
+	assert.Contains(t, sent[0], "\r\n\r\n1 unsorted transaction(s) from card:\r\n")
+}
+
+func TestSplit(t *testing.T) {
+	assert.Equal(t, []string{"abc"}, split("abc", 5))
+	assert.Equal(t, []string{"abcde", "fg"}, split("abcdefg", 5))
+	assert.Equal(t, []string{"ab\ncd\n", "ef"}, split("ab\ncd\nef", 7))
+	text := strings.Repeat("ю", 10)
+	assert.Equal(t, []string{text[:8], text[8:16], text[16:]}, split(text, 4))
+}
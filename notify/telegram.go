@@ -0,0 +1,103 @@
+package notify
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tuxofil/p24fetch/config"
+)
+
+// Telegram Bot API limits message length
+const telegramMaxLength = 4096
+
+// Telegram sends notifications to a chat with Telegram Bot API.
+type Telegram struct {
+	base
+	// Bot API token
+	token string
+	// Chat ID to write messages to
+	chatID string
+	// Bot API base URL
+	apiURL string
+	// HTTP client
+	httpClient *http.Client
+}
+
+func newTelegram(b base, cfg config.NotifierConfig) *Telegram {
+	return &Telegram{
+		base:       b,
+		token:      cfg.TelegramToken,
+		chatID:     cfg.TelegramChatID,
+		apiURL:     "https://api.telegram.org",
+		httpClient: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// Name implements Notifier interface.
+func (t *Telegram) Name() string {
+	return "telegram"
+}
+
+// ReportRun implements Notifier interface.
+func (t *Telegram) ReportRun(run Run) error {
+	if len(run.Unsorted) == 0 {
+		return nil
+	}
+	text, err := t.render(TemplateUnsorted, run)
+	if err != nil {
+		return err
+	}
+	return t.send(text)
+}
+
+// Send implements Notifier interface.
+func (t *Telegram) Send(message string) error {
+	text, err := t.render(TemplateMessage, Message{Merchant: t.merchant, Text: message})
+	if err != nil {
+		return err
+	}
+	return t.send(text)
+}
+
+// Send the text splitting it to several messages when needed.
+func (t *Telegram) send(text string) error {
+	url := fmt.Sprintf("%s/bot%s/sendMessage", t.apiURL, t.token)
+	for _, chunk := range split(text, telegramMaxLength) {
+		var resp struct {
+			OK          bool   `json:"ok"`
+			Description string `json:"description"`
+		}
+		err := postJSON(t.httpClient, url, nil, map[string]interface{}{
+			"chat_id":                  t.chatID,
+			"text":                     chunk,
+			"disable_web_page_preview": true,
+		}, &resp)
+		if err != nil {
+			return fmt.Errorf("send message: %w", err)
+		}
+		if !resp.OK {
+			return fmt.Errorf("send message: %s", resp.Description)
+		}
+	}
+	return nil
+}
+
+// Split the text to chunks of at most n runes,
+// preferably on line boundaries.
+func split(text string, n int) []string {
+	var chunks []string
+	runes := []rune(text)
+	for len(runes) > n {
+		cut := n
+		for i := n - 1; i > n/2; i-- {
+			if runes[i] == '\n' {
+				cut = i + 1
+				break
+			}
+		}
+		chunks = append(chunks, string(runes[:cut]))
+		runes = runes[cut:]
+	}
+	return append(chunks, string(runes))
+}
@@ -0,0 +1,121 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/schema"
+)
+
+// Webhook events
+const (
+	eventUnsorted = "unsorted"
+	eventMessage  = "message"
+)
+
+// Webhook posts notifications as JSON documents to an URL.
+type Webhook struct {
+	base
+	// URL to post to
+	url string
+	// Additional HTTP headers
+	headers map[string]string
+	// HTTP client
+	httpClient *http.Client
+}
+
+// WebhookPayload is a JSON document posted to the webhook URL.
+type WebhookPayload struct {
+	// Event type: "unsorted" or "message"
+	Event string `json:"event"`
+	// Merchant name
+	Merchant string `json:"merchant"`
+	// Message text rendered with the template
+	Text string `json:"text"`
+	// Unsorted transactions. Set only for "unsorted" event.
+	Transactions []schema.Transaction `json:"transactions,omitempty"`
+}
+
+func newWebhook(b base, cfg config.NotifierConfig) *Webhook {
+	return &Webhook{
+		base:       b,
+		url:        cfg.WebhookURL,
+		headers:    cfg.WebhookHeaders,
+		httpClient: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// Name implements Notifier interface.
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+// ReportRun implements Notifier interface.
+func (w *Webhook) ReportRun(run Run) error {
+	if len(run.Unsorted) == 0 {
+		return nil
+	}
+	text, err := w.render(TemplateUnsorted, run)
+	if err != nil {
+		return err
+	}
+	return postJSON(w.httpClient, w.url, w.headers, WebhookPayload{
+		Event:        eventUnsorted,
+		Merchant:     run.Merchant,
+		Text:         text,
+		Transactions: run.Unsorted,
+	}, nil)
+}
+
+// Send implements Notifier interface.
+func (w *Webhook) Send(message string) error {
+	text, err := w.render(TemplateMessage, Message{Merchant: w.merchant, Text: message})
+	if err != nil {
+		return err
+	}
+	return postJSON(w.httpClient, w.url, w.headers, WebhookPayload{
+		Event:    eventMessage,
+		Merchant: w.merchant,
+		Text:     text,
+	}, nil)
+}
+
+// POST the JSON document and decode the response to result, if not nil.
+func postJSON(client *http.Client, url string, headers map[string]string,
+	body interface{}, result interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("do request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("invalid response status: %s", resp.Status)
+	}
+	if result != nil {
+		if err := json.Unmarshal(respBody, result); err != nil {
+			return fmt.Errorf("decode response: %w", err)
+		}
+	}
+	return nil
+}
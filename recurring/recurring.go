@@ -0,0 +1,207 @@
+// Package recurring detects recurring payments (subscriptions)
+// in the transaction history.
+package recurring
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode"
+
+	"github.com/tuxofil/p24fetch/schema"
+)
+
+const (
+	// Minimum count of charges to consider payments recurring
+	minCount = 3
+	// Maximum relative deviation of an amount from the median
+	amountTolerance = 0.25
+	// Maximum relative deviation of an interval from the median
+	intervalTolerance = 0.2
+	// Minimum interval between charges
+	minInterval = 6 * 24 * time.Hour
+)
+
+// Payment is a detected recurring payment.
+type Payment struct {
+	// Beneficiary name as seen in the latest charge
+	Payee string
+	// Payment currency
+	Currency schema.Currency
+	// Amount of the latest charge
+	Amount float32
+	// Amount of the charge before the latest one
+	PrevAmount float32
+	// Count of charges found
+	Count int
+	// Typical interval between charges
+	Interval time.Duration
+	// Date of the latest charge
+	Last time.Time
+	// Expected date of the next charge
+	Next time.Time
+	// Estimated yearly cost
+	YearlyCost float32
+	// The next charge is overdue
+	Missing bool
+	// The latest charge amount differs from the previous one
+	PriceChanged bool
+}
+
+// Flagged reports whether the payment needs attention.
+func (p Payment) Flagged() bool {
+	return p.Missing || p.PriceChanged
+}
+
+// Flags returns comma separated list of flags set.
+func (p Payment) Flags() string {
+	var flags []string
+	if p.Missing {
+		flags = append(flags, "missing")
+	}
+	if p.PriceChanged {
+		flags = append(flags, fmt.Sprintf("price changed from %.2f", p.PrevAmount))
+	}
+	return strings.Join(flags, ", ")
+}
+
+// String returns a single line description of the payment.
+func (p Payment) String() string {
+	s := fmt.Sprintf("%s: %.2f %s every %dd, last %s, next %s",
+		p.Payee, p.Amount, p.Currency, int(p.Interval.Hours()/24+0.5),
+		p.Last.Format("2006-01-02"), p.Next.Format("2006-01-02"))
+	if p.Flagged() {
+		s += " (" + p.Flags() + ")"
+	}
+	return s
+}
+
+// Detect recurring payments among the transactions. Charges are
+// considered recurring when they are paid to the same payee in the
+// same currency with similar amounts at regular intervals. The now
+// argument is used to decide whether the next charge is overdue.
+// The result is sorted by payee.
+func Detect(trans []schema.Transaction, now time.Time) []Payment {
+	type key struct {
+		payee    string
+		currency schema.Currency
+	}
+	groups := map[key][]schema.Transaction{}
+	for _, tran := range trans {
+		if tran.Raw != nil || tran.SrcVal >= 0 {
+			continue
+		}
+		k := key{normalize(tran.Dst), tran.SrcCur}
+		groups[k] = append(groups[k], tran)
+	}
+	var res []Payment
+	for _, group := range groups {
+		if p, ok := detect(group, now); ok {
+			res = append(res, p)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Payee != res[j].Payee {
+			return res[i].Payee < res[j].Payee
+		}
+		return res[i].Currency < res[j].Currency
+	})
+	return res
+}
+
+// Check if charges to the same payee are recurring.
+func detect(trans []schema.Transaction, now time.Time) (Payment, bool) {
+	if len(trans) < minCount {
+		return Payment{}, false
+	}
+	sort.Slice(trans, func(i, j int) bool {
+		return trans[i].Date.Before(trans[j].Date)
+	})
+	intervals := make([]float64, len(trans)-1)
+	for i := 1; i < len(trans); i++ {
+		intervals[i-1] = float64(trans[i].Date.Sub(trans[i-1].Date))
+	}
+	interval := median(intervals)
+	if interval < float64(minInterval) {
+		return Payment{}, false
+	}
+	for _, v := range intervals {
+		if math.Abs(v-interval) > interval*intervalTolerance {
+			return Payment{}, false
+		}
+	}
+	// The latest amount may differ (price change), the rest
+	// should be similar.
+	amounts := make([]float64, len(trans)-1)
+	for i, tran := range trans[:len(trans)-1] {
+		amounts[i] = float64(-tran.SrcVal)
+	}
+	amount := median(amounts)
+	for _, v := range amounts {
+		if math.Abs(v-amount) > amount*amountTolerance {
+			return Payment{}, false
+		}
+	}
+	last := trans[len(trans)-1]
+	prev := trans[len(trans)-2]
+	p := Payment{
+		Payee:      last.Dst,
+		Currency:   last.SrcCur,
+		Amount:     -last.SrcVal,
+		PrevAmount: -prev.SrcVal,
+		Count:      len(trans),
+		Interval:   time.Duration(interval),
+		Last:       last.Date,
+		Next:       last.Date.Add(time.Duration(interval)),
+	}
+	p.YearlyCost = p.Amount * float32(365*24*time.Hour) / float32(p.Interval)
+	p.PriceChanged = math.Abs(float64(p.Amount-p.PrevAmount)) >= 0.01
+	tolerance := time.Duration(interval * intervalTolerance)
+	if tolerance < 3*24*time.Hour {
+		tolerance = 3 * 24 * time.Hour
+	}
+	p.Missing = now.After(p.Next.Add(tolerance))
+	return p, true
+}
+
+// Normalize payee name: terminals often add varying
+// numbers (order IDs, phone numbers) to the name.
+func normalize(payee string) string {
+	payee = strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return -1
+		}
+		return unicode.ToUpper(r)
+	}, payee)
+	return strings.Join(strings.Fields(payee), " ")
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// Format payments as a text table.
+func Format(payments []Payment) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "PAYEE\t%10s\t\tEVERY\tLAST\tNEXT\t%10s\tFLAGS\n", "AMOUNT", "YEARLY")
+	for _, p := range payments {
+		fmt.Fprintf(w, "%s\t%10.2f\t%s\t%dd\t%s\t%s\t%10.2f\t%s\n",
+			p.Payee, p.Amount, p.Currency,
+			int(p.Interval.Hours()/24+0.5),
+			p.Last.Format("2006-01-02"), p.Next.Format("2006-01-02"),
+			p.YearlyCost, p.Flags())
+	}
+	_ = w.Flush()
+	return buf.String()
+}
@@ -0,0 +1,97 @@
+package recurring
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/schema"
+)
+
+func charge(date string, val float32, dst string) schema.Transaction {
+	d, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		panic(err)
+	}
+	return schema.Transaction{Date: d, SrcVal: val, SrcCur: schema.UAH, Dst: dst}
+}
+
+func TestDetect(t *testing.T) {
+	trans := []schema.Transaction{
+		charge("2020-06-05", -199, "NETFLIX.COM 8665797251"),
+		charge("2020-07-05", -199, "NETFLIX.COM 8665797252"),
+		charge("2020-08-04", -199, "NETFLIX.COM 8665797253"),
+		charge("2020-09-05", -249, "NETFLIX.COM 8665797254"),
+		// Irregular intervals
+		charge("2020-06-01", -50, "Bakery"),
+		charge("2020-06-03", -50, "Bakery"),
+		charge("2020-08-20", -50, "Bakery"),
+		// Amounts differ too much
+		charge("2020-06-10", -100, "Silpo"),
+		charge("2020-07-10", -1000, "Silpo"),
+		charge("2020-08-10", -100, "Silpo"),
+		charge("2020-09-10", -100, "Silpo"),
+		// Not enough charges
+		charge("2020-07-15", -10, "Music"),
+		charge("2020-08-15", -10, "Music"),
+		// Deposits are skipped
+		charge("2020-07-01", 10, "Salary"),
+		charge("2020-08-01", 10, "Salary"),
+		charge("2020-09-01", 10, "Salary"),
+		// Weekly
+		charge("2020-08-01", -30, "Gym"),
+		charge("2020-08-08", -30, "Gym"),
+		charge("2020-08-15", -30, "Gym"),
+	}
+	now := time.Date(2020, 9, 20, 0, 0, 0, 0, time.UTC)
+	res := Detect(trans, now)
+	require.Len(t, res, 2)
+
+	gym := res[0]
+	assert.Equal(t, "Gym", gym.Payee)
+	assert.Equal(t, 7*24*time.Hour, gym.Interval)
+	assert.Equal(t, "2020-08-22", gym.Next.Format("2006-01-02"))
+	assert.True(t, gym.Missing)
+	assert.False(t, gym.PriceChanged)
+	assert.InDelta(t, 30.0*365/7, gym.YearlyCost, 0.1)
+
+	netflix := res[1]
+	assert.Equal(t, "NETFLIX.COM 8665797254", netflix.Payee)
+	assert.Equal(t, float32(249), netflix.Amount)
+	assert.Equal(t, 4, netflix.Count)
+	assert.False(t, netflix.Missing)
+	assert.True(t, netflix.PriceChanged)
+	assert.Equal(t, "NETFLIX.COM 8665797254: 249.00 UAH every 30d, "+
+		"last 2020-09-05, next 2020-10-05 (price changed from 199.00)",
+		netflix.String())
+}
+
+func TestAlerted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recurring")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cfg := &config.Config{CardNumber: "1234", DedupDir: dir}
+
+	gym := Payment{Payee: "Gym", Currency: schema.UAH, Missing: true,
+		Next: time.Date(2020, 8, 22, 0, 0, 0, 0, time.UTC)}
+	netflix := Payment{Payee: "NETFLIX.COM 8665797254", Currency: schema.UAH, PriceChanged: true}
+	alerted, err := LoadAlerted(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []Payment{gym, netflix}, alerted.Filter([]Payment{gym, netflix}))
+	require.NoError(t, alerted.Save())
+
+	// The overdue charge is reported once
+	alerted, err = LoadAlerted(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []Payment{netflix}, alerted.Filter([]Payment{gym, netflix}))
+	assert.Empty(t, alerted.Filter([]Payment{gym}))
+
+	// The next overdue charge is reported again
+	gym.Next = gym.Next.AddDate(0, 0, 7)
+	assert.Equal(t, []Payment{gym}, alerted.Filter([]Payment{gym}))
+}
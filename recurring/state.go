@@ -0,0 +1,77 @@
+package recurring
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/crypt"
+	"github.com/tuxofil/p24fetch/txn"
+)
+
+// Alerted remembers missing payments already reported,
+// so every overdue charge is reported once.
+type Alerted struct {
+	// Configuration used to create the instance
+	config config.Config
+	// Path to the state file
+	stateFile string
+	// Reported payments. Mapping: payee and currency ->
+	// expected date of the overdue charge.
+	missing map[string]string
+}
+
+// LoadAlerted reads missing payments reported before.
+func LoadAlerted(cfg *config.Config) (*Alerted, error) {
+	stateFile, err := cfg.StateFile(cfg.DedupDir, "-recurring.json")
+	if err != nil {
+		return nil, err
+	}
+	a := &Alerted{config: *cfg, stateFile: stateFile, missing: map[string]string{}}
+	data, err := crypt.ReadFile(stateFile, cfg.AgeIdentity)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("read state file: %w", err)
+		}
+	} else if err := json.Unmarshal(data, &a.missing); err != nil {
+		return nil, fmt.Errorf("parse state: %w", err)
+	}
+	return a, nil
+}
+
+// Filter clears the missing flag of payments whose overdue
+// charge was reported before and remembers the rest. Returns
+// payments still needing attention.
+func (a *Alerted) Filter(payments []Payment) []Payment {
+	missing := map[string]string{}
+	var res []Payment
+	for _, p := range payments {
+		if p.Missing {
+			key := normalize(p.Payee) + " " + string(p.Currency)
+			period := p.Next.Format("2006-01-02")
+			p.Missing = a.missing[key] != period
+			missing[key] = period
+		}
+		if p.Flagged() {
+			res = append(res, p)
+		}
+	}
+	a.missing = missing
+	return res
+}
+
+// Save the state.
+func (a *Alerted) Save() error {
+	data, err := json.Marshal(a.missing)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	if data, err = crypt.Encrypt(data, a.config.AgeRecipients); err != nil {
+		return err
+	}
+	if err := txn.WriteFile(a.stateFile, data); err != nil {
+		return fmt.Errorf("write file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"text/tabwriter"
+)
+
+// Valid output formats
+const (
+	Text = "text"
+	CSV  = "csv"
+	JSON = "json"
+	HTML = "html"
+)
+
+// Write the report in the given format.
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case Text:
+		return r.WriteText(w)
+	case CSV:
+		return r.WriteCSV(w)
+	case JSON:
+		return r.WriteJSON(w)
+	case HTML:
+		return r.WriteHTML(w)
+	}
+	return fmt.Errorf("invalid format: %#v", format)
+}
+
+// WriteText writes the report as text tables, one per month.
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, month := range r.Months {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "%s\n", month.Month)
+		fmt.Fprintf(tw, "ACCOUNT\t%10s\t\t%10s\t%8s\t%5s\n", "AMOUNT", "PREV", "CHANGE", "COUNT")
+		write := func(label string, row Row) {
+			fmt.Fprintf(tw, "%s\t%10.2f\t%s\t%10.2f\t%8s\t%5d\n",
+				label, row.Amount, row.Currency, row.Prev, formatChange(row), row.Count)
+		}
+		for _, row := range month.Accounts {
+			write(row.Account, row)
+		}
+		for _, row := range month.Comissions {
+			write("COMISSIONS", row)
+		}
+		for _, row := range month.Totals {
+			write("TOTAL", row)
+		}
+	}
+	return tw.Flush()
+}
+
+// WriteCSV writes the report as CSV. The kind column is one of:
+// account, comission, total.
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"month", "kind", "account", "currency",
+		"amount", "count", "prev"})
+	money := func(v float32) string {
+		return strconv.FormatFloat(float64(v), 'f', 2, 32)
+	}
+	for _, month := range r.Months {
+		write := func(kind string, rows []Row) {
+			for _, row := range rows {
+				_ = cw.Write([]string{month.Month, kind, row.Account,
+					string(row.Currency), money(row.Amount),
+					strconv.Itoa(row.Count), money(row.Prev)})
+			}
+		}
+		write("account", month.Accounts)
+		write("comission", month.Comissions)
+		write("total", month.Totals)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes the report as JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteHTML writes the report as a self-contained HTML page
+// with SVG charts.
+func (r *Report) WriteHTML(w io.Writer) error {
+	return htmlTemplate.Execute(w, r)
+}
+
+// Format change with the previous month.
+func formatChange(row Row) string {
+	change, ok := row.Change()
+	if !ok {
+		return "-"
+	}
+	return fmt.Sprintf("%+.1f%%", change)
+}
+
+// Bar of a chart
+type bar struct {
+	Label string
+	// Bar lengths, in pixels
+	Width, PrevWidth int
+	Y                int
+	Row              Row
+}
+
+// Chart is a horizontal bar chart of amounts in a single currency.
+type chart struct {
+	Currency string
+	Height   int
+	Bars     []bar
+}
+
+// Chart geometry, in pixels
+const (
+	chartLabelWidth = 220
+	chartBarsWidth  = 400
+	chartBarHeight  = 22
+)
+
+// Build charts for the rows, one per currency.
+func charts(rows []Row) []chart {
+	var (
+		res []chart
+		idx = map[string]int{}
+		max = map[string]float32{}
+	)
+	for _, row := range rows {
+		cur := string(row.Currency)
+		if _, ok := idx[cur]; !ok {
+			idx[cur] = len(res)
+			res = append(res, chart{Currency: cur})
+		}
+		if row.Amount > max[cur] {
+			max[cur] = row.Amount
+		}
+		if row.Prev > max[cur] {
+			max[cur] = row.Prev
+		}
+	}
+	for _, row := range rows {
+		cur := string(row.Currency)
+		if max[cur] == 0 {
+			continue
+		}
+		c := &res[idx[cur]]
+		label := row.Account
+		if label == "" {
+			label = "TOTAL"
+		}
+		c.Bars = append(c.Bars, bar{
+			Label:     label,
+			Width:     int(row.Amount / max[cur] * chartBarsWidth),
+			PrevWidth: int(row.Prev / max[cur] * chartBarsWidth),
+			Y:         len(c.Bars) * chartBarHeight,
+			Row:       row,
+		})
+		c.Height = len(c.Bars) * chartBarHeight
+	}
+	return res
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"charts":     charts,
+	"change":     formatChange,
+	"labelWidth": func() int { return chartLabelWidth },
+	"barX":       func() int { return chartLabelWidth + 5 },
+	"width":      func() int { return chartLabelWidth + chartBarsWidth + 140 },
+	"plus":       func(a, b int) int { return a + b },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>p24fetch: spending report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { padding: 0.2em 0.8em; border-bottom: 1px solid #ddd; }
+td.num { text-align: right; font-family: monospace; }
+tr.total td { font-weight: bold; }
+svg text { font-size: 12px; }
+</style>
+</head>
+<body>
+<h1>Spending report</h1>
+{{range .Months}}
+<h2>{{.Month}}</h2>
+<table>
+<tr><th>Account</th><th>Amount</th><th>Currency</th><th>Previous month</th><th>Change</th><th>Count</th></tr>
+{{range .Accounts}}<tr><td>{{.Account}}</td><td class="num">{{printf "%.2f" .Amount}}</td><td>{{.Currency}}</td><td class="num">{{printf "%.2f" .Prev}}</td><td class="num">{{change .}}</td><td class="num">{{.Count}}</td></tr>
+{{end}}{{range .Comissions}}<tr><td>Comissions</td><td class="num">{{printf "%.2f" .Amount}}</td><td>{{.Currency}}</td><td class="num">{{printf "%.2f" .Prev}}</td><td class="num">{{change .}}</td><td class="num">{{.Count}}</td></tr>
+{{end}}{{range .Totals}}<tr class="total"><td>Total</td><td class="num">{{printf "%.2f" .Amount}}</td><td>{{.Currency}}</td><td class="num">{{printf "%.2f" .Prev}}</td><td class="num">{{change .}}</td><td class="num">{{.Count}}</td></tr>
+{{end}}</table>
+{{range charts .Accounts}}
+<h3>{{.Currency}}</h3>
+<svg xmlns="http://www.w3.org/2000/svg" width="{{width}}" height="{{.Height}}">
+{{range .Bars}}<g transform="translate(0,{{.Y}})">
+<text x="{{labelWidth}}" y="15" text-anchor="end">{{.Label}}</text>
+<rect x="{{barX}}" y="2" width="{{.PrevWidth}}" height="18" fill="#ddd"><title>previous month: {{printf "%.2f" .Row.Prev}}</title></rect>
+<rect x="{{barX}}" y="6" width="{{.Width}}" height="10" fill="#4a7ebb"><title>{{printf "%.2f" .Row.Amount}}</title></rect>
+<text x="{{plus barX (plus .Width 5)}}" y="15">{{printf "%.2f" .Row.Amount}}</text>
+</g>
+{{end}}</svg>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
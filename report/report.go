@@ -0,0 +1,149 @@
+// Package report aggregates the transaction archive into
+// monthly spending reports per GnuCash account.
+package report
+
+import (
+	"sort"
+	"time"
+
+	"github.com/tuxofil/p24fetch/archive"
+	"github.com/tuxofil/p24fetch/schema"
+	"github.com/tuxofil/p24fetch/sorter"
+)
+
+// Account name used for unsorted transactions
+const UnsortedAccount = "(unsorted)"
+
+// Layout of month names
+const monthLayout = "2006-01"
+
+// Report is a spending report for a sequence of months.
+type Report struct {
+	Months []Month `json:"months"`
+}
+
+// Month is a spending report for a single month.
+type Month struct {
+	// Month in YYYY-MM format
+	Month string `json:"month"`
+	// Spend per account and currency, sorted by account
+	Accounts []Row `json:"accounts"`
+	// Total spend per currency (comissions included)
+	Totals []Row `json:"totals"`
+	// Comissions per currency
+	Comissions []Row `json:"comissions"`
+}
+
+// Row is an amount spent in a month.
+type Row struct {
+	// GnuCash Account ID. Empty for totals and comissions.
+	Account string `json:"account,omitempty"`
+	// Currency of amounts
+	Currency schema.Currency `json:"currency"`
+	// Amount spent
+	Amount float32 `json:"amount"`
+	// Count of transactions
+	Count int `json:"count"`
+	// Amount spent in the previous month
+	Prev float32 `json:"prev"`
+}
+
+// Change returns difference with the previous month in percents.
+// Returns false when there was no spend in the previous month.
+func (r Row) Change() (float32, bool) {
+	if r.Prev == 0 {
+		return 0, false
+	}
+	return (r.Amount - r.Prev) / r.Prev * 100, true
+}
+
+// Build the report from archived records. Ignored transactions
+// are skipped, unsorted ones are reported as UnsortedAccount.
+// Only months in [from, to) are included, zero values mean
+// no limit.
+func Build(records []archive.Record, from, to time.Time) *Report {
+	type key struct {
+		month    string
+		account  string
+		currency schema.Currency
+	}
+	var (
+		accounts   = map[key]*Row{}
+		totals     = map[key]*Row{}
+		comissions = map[key]*Row{}
+		months     = map[string]bool{}
+	)
+	add := func(m map[key]*Row, k key, amount float32) {
+		row, ok := m[k]
+		if !ok {
+			row = &Row{Account: k.account, Currency: k.currency}
+			m[k] = row
+		}
+		row.Amount += amount
+		row.Count++
+	}
+	for _, record := range records {
+		tran := record.Transaction
+		if tran.Raw != nil || record.Outcome == sorter.Ignored || tran.SrcVal >= 0 {
+			continue
+		}
+		month := tran.Date.Format(monthLayout)
+		months[month] = true
+		account := record.Account
+		if record.Outcome != sorter.Sorted {
+			account = UnsortedAccount
+		}
+		comission := tran.Comission()
+		add(accounts, key{month, account, tran.SrcCur}, -tran.SrcVal-comission)
+		add(totals, key{month, "", tran.SrcCur}, -tran.SrcVal)
+		if comission > 0.01 {
+			add(comissions, key{month, "", tran.SrcCur}, comission)
+		}
+	}
+
+	// Collect rows of every month adding rows present
+	// in the previous month only
+	collect := func(m map[key]*Row, month, prev string) []Row {
+		var res []Row
+		for k, row := range m {
+			if k.month == month {
+				if p, ok := m[key{prev, k.account, k.currency}]; ok {
+					row.Prev = p.Amount
+				}
+				res = append(res, *row)
+			} else if k.month == prev {
+				if _, ok := m[key{month, k.account, k.currency}]; !ok {
+					res = append(res, Row{Account: k.account,
+						Currency: k.currency, Prev: row.Amount})
+				}
+			}
+		}
+		sort.Slice(res, func(i, j int) bool {
+			if res[i].Account != res[j].Account {
+				return res[i].Account < res[j].Account
+			}
+			return res[i].Currency < res[j].Currency
+		})
+		return res
+	}
+	var names []string
+	for month := range months {
+		names = append(names, month)
+	}
+	sort.Strings(names)
+	r := &Report{}
+	for _, month := range names {
+		t, _ := time.Parse(monthLayout, month)
+		if (!from.IsZero() && t.Before(from)) || (!to.IsZero() && !t.Before(to)) {
+			continue
+		}
+		prev := t.AddDate(0, -1, 0).Format(monthLayout)
+		r.Months = append(r.Months, Month{
+			Month:      month,
+			Accounts:   collect(accounts, month, prev),
+			Totals:     collect(totals, month, prev),
+			Comissions: collect(comissions, month, prev),
+		})
+	}
+	return r
+}
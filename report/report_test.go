@@ -0,0 +1,111 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tuxofil/p24fetch/archive"
+	"github.com/tuxofil/p24fetch/schema"
+	"github.com/tuxofil/p24fetch/sorter"
+)
+
+func record(date string, src, dst float32, cur schema.Currency,
+	outcome sorter.Outcome, account string) archive.Record {
+	d, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		panic(err)
+	}
+	return archive.Record{
+		Transaction: schema.Transaction{
+			Date: d, SrcVal: src, SrcCur: cur, DstVal: dst, DstCur: cur,
+		},
+		Outcome: outcome,
+		Account: account,
+	}
+}
+
+var testRecords = []archive.Record{
+	record("2020-08-03", -100, 100, schema.UAH, sorter.Sorted, "Expenses:Food"),
+	record("2020-08-10", -50, 50, schema.UAH, sorter.Sorted, "Expenses:Fun"),
+	record("2020-09-01", -102, 100, schema.UAH, sorter.Sorted, "Expenses:Food"),
+	record("2020-09-02", -50, 50, schema.UAH, sorter.Sorted, "Expenses:Food"),
+	record("2020-09-05", -10, 10, schema.USD, sorter.Sorted, "Expenses:Games"),
+	record("2020-09-06", -20, 20, schema.UAH, sorter.Unsorted, ""),
+	record("2020-09-07", -1000, 1000, schema.UAH, sorter.Ignored, ""),
+	record("2020-09-08", 500, 500, schema.UAH, sorter.Unsorted, ""),
+}
+
+func TestBuild(t *testing.T) {
+	r := Build(testRecords, time.Time{}, time.Time{})
+	require.Len(t, r.Months, 2)
+	assert.Equal(t, "2020-08", r.Months[0].Month)
+
+	sep := r.Months[1]
+	assert.Equal(t, "2020-09", sep.Month)
+	assert.Equal(t, []Row{
+		{Account: UnsortedAccount, Currency: schema.UAH, Amount: 20, Count: 1},
+		{Account: "Expenses:Food", Currency: schema.UAH, Amount: 150, Count: 2, Prev: 100},
+		{Account: "Expenses:Fun", Currency: schema.UAH, Prev: 50},
+		{Account: "Expenses:Games", Currency: schema.USD, Amount: 10, Count: 1},
+	}, sep.Accounts)
+	assert.Equal(t, []Row{
+		{Currency: schema.UAH, Amount: 2, Count: 1},
+	}, sep.Comissions)
+	assert.Equal(t, []Row{
+		{Currency: schema.UAH, Amount: 172, Count: 3, Prev: 150},
+		{Currency: schema.USD, Amount: 10, Count: 1},
+	}, sep.Totals)
+	change, ok := sep.Accounts[1].Change()
+	assert.True(t, ok)
+	assert.Equal(t, float32(50), change)
+
+	// Months filter
+	r = Build(testRecords, time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC), time.Time{})
+	require.Len(t, r.Months, 1)
+	assert.Equal(t, "2020-09", r.Months[0].Month)
+	assert.Equal(t, float32(100), r.Months[0].Accounts[1].Prev)
+	r = Build(testRecords, time.Time{}, time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC))
+	require.Len(t, r.Months, 1)
+	assert.Equal(t, "2020-08", r.Months[0].Month)
+}
+
+func TestWrite(t *testing.T) {
+	r := Build(testRecords, time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC), time.Time{})
+
+	var buf bytes.Buffer
+	require.NoError(t, r.Write(&buf, Text))
+	assert.Equal(t, `2020-09
+ACCOUNT             AMOUNT             PREV    CHANGE  COUNT
+(unsorted)           20.00  UAH        0.00         -      1
+Expenses:Food       150.00  UAH      100.00    +50.0%      2
+Expenses:Fun          0.00  UAH       50.00   -100.0%      0
+Expenses:Games       10.00  USD        0.00         -      1
+COMISSIONS            2.00  UAH        0.00         -      1
+TOTAL               172.00  UAH      150.00    +14.7%      3
+TOTAL                10.00  USD        0.00         -      1
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, r.Write(&buf, CSV))
+	assert.Contains(t, buf.String(), "month,kind,account,currency,amount,count,prev\n"+
+		"2020-09,account,(unsorted),UAH,20.00,1,0.00\n")
+	assert.Contains(t, buf.String(), "2020-09,comission,,UAH,2.00,1,0.00\n")
+
+	buf.Reset()
+	require.NoError(t, r.Write(&buf, JSON))
+	var decoded Report
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, *r, decoded)
+
+	buf.Reset()
+	require.NoError(t, r.Write(&buf, HTML))
+	assert.Contains(t, buf.String(), "<h2>2020-09</h2>")
+	assert.Contains(t, buf.String(), `<rect x="225" y="6" width="400" height="10"`)
+
+	assert.Error(t, r.Write(&buf, "pdf"))
+}
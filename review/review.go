@@ -0,0 +1,265 @@
+// Package review implements manual categorisation of
+// unsorted transactions.
+package review
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/crypt"
+	"github.com/tuxofil/p24fetch/exporter"
+	"github.com/tuxofil/p24fetch/schema"
+	"github.com/tuxofil/p24fetch/sorter"
+	"github.com/tuxofil/p24fetch/txn"
+)
+
+// ErrNotFound is returned when no unsorted transaction
+// with given ID exists.
+var ErrNotFound = errors.New("transaction not found")
+
+// How long to wait for the card locked by a run
+const lockWait = 10 * time.Second
+
+// Item is an unsorted transaction waiting for review.
+type Item struct {
+	// Transaction ID, see ID()
+	ID string
+	// Configuration of the merchant the transaction belongs to
+	Config *config.Config
+	// Path to the file the transaction is stored in
+	File string
+	// The transaction itself
+	Tran schema.Transaction
+}
+
+// Reviewer lists and categorises unsorted transactions
+// of the configured merchants.
+type Reviewer struct {
+	// Merchants configurations
+	configs []*config.Config
+	// Serialises modifications of unsorted transactions files
+	mu sync.Mutex
+}
+
+// New creates new Reviewer instance.
+func New(configs []*config.Config) *Reviewer {
+	return &Reviewer{configs: configs}
+}
+
+// SetConfigs replaces merchants configurations.
+func (r *Reviewer) SetConfigs(configs []*config.Config) {
+	r.mu.Lock()
+	r.configs = configs
+	r.mu.Unlock()
+}
+
+// ID returns stable identifier of the transaction.
+func ID(tran schema.Transaction) string {
+	data, err := json.Marshal(tran)
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%x", sha1.Sum(data))[:16]
+}
+
+// UnsortedDir returns path to the directory with unsorted
+// transactions of the merchant.
+func UnsortedDir(cfg *config.Config) string {
+	return path.Join(cfg.ResultsDir, "unsorted")
+}
+
+// CanCategorize returns true when the transaction can be
+// exported to an account.
+func (i *Item) CanCategorize() bool {
+	return i.Tran.Error == "" && i.Tran.Raw == nil
+}
+
+// Unsorted lists all unsorted transactions ordered by date.
+func (r *Reviewer) Unsorted() ([]*Item, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.unsorted()
+}
+
+func (r *Reviewer) unsorted() ([]*Item, error) {
+	var items []*Item
+	seen := map[string]bool{}
+	for _, cfg := range r.configs {
+		// Files are named by the masked card number,
+		// previous versions used the card number
+		var files []string
+		for _, name := range []string{cfg.MaskedCard(), cfg.CardNumber} {
+			pattern := path.Join(UnsortedDir(cfg), name+"-*.json")
+			if seen[pattern] {
+				continue
+			}
+			seen[pattern] = true
+			for _, p := range []string{pattern, pattern + crypt.Ext} {
+				found, err := filepath.Glob(p)
+				if err != nil {
+					return nil, fmt.Errorf("list files: %w", err)
+				}
+				files = append(files, found...)
+			}
+		}
+		for _, file := range files {
+			trans, err := readFile(cfg, file)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			for _, tran := range trans {
+				items = append(items, &Item{
+					ID:     ID(tran),
+					Config: cfg,
+					File:   file,
+					Tran:   tran,
+				})
+			}
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Tran.Date.Before(items[j].Tran.Date)
+	})
+	return items, nil
+}
+
+// Find returns unsorted transaction by its ID.
+func (r *Reviewer) Find(id string) (*Item, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.find(id)
+}
+
+func (r *Reviewer) find(id string) (*Item, error) {
+	items, err := r.unsorted()
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if item.ID == id {
+			return item, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Categorize exports the unsorted transaction to the account with
+// given short ID and removes it from the unsorted transactions.
+// When the pattern is not empty, new sorting rule mapping the
+// pattern to the account is appended to the rules file
+// (the rules overlay of the merchant when configured).
+// The card is locked for the time, as runs write the same files,
+// and all the files are written in a single transaction.
+func (r *Reviewer) Categorize(id, shortID, pattern string) (*Item, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, err := r.find(id)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := item.Config.LockCard(context.Background(), lockWait)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	// Complete writes of an interrupted run or categorisation
+	journal, err := item.Config.StateFile(item.Config.DedupDir, ".txn")
+	if err != nil {
+		return nil, err
+	}
+	if err := txn.Recover(journal); err != nil {
+		return nil, fmt.Errorf("recover interrupted run: %w", err)
+	}
+	// The run could have changed the files before the lock was taken
+	if item, err = r.find(id); err != nil {
+		return nil, err
+	}
+	if !item.CanCategorize() {
+		return nil, fmt.Errorf("transaction can not be categorised: %s",
+			item.Tran.Error)
+	}
+	rules, err := sorter.LoadRules(item.Config)
+	if err != nil {
+		return nil, fmt.Errorf("read rules: %w", err)
+	}
+	account, ok := rules.Accounts[shortID]
+	if !ok {
+		return nil, fmt.Errorf("undefined account: %#v", shortID)
+	}
+	tx := txn.Begin(journal)
+	if pattern = strings.TrimSpace(pattern); pattern != "" {
+		if err := rules.AddRule(shortID, pattern); err != nil {
+			return nil, fmt.Errorf("add rule: %w", err)
+		}
+		if err := rules.WithTx(tx).Save(); err != nil {
+			return nil, fmt.Errorf("write rules: %w", err)
+		}
+	}
+	tran := sorter.Assign(item.Tran, account)
+	if err := exporter.New(item.Config).WithTx(tx).Export([]schema.Transaction{tran}); err != nil {
+		return nil, fmt.Errorf("export: %w", err)
+	}
+	if err := removeFromFile(tx, item.Config, item.File, item.ID); err != nil {
+		return nil, fmt.Errorf("remove from unsorted: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	return item, nil
+}
+
+// Read transactions from the JSON file written by the exporter.
+func readFile(cfg *config.Config, file string) ([]schema.Transaction, error) {
+	data, err := crypt.ReadFile(file, cfg.AgeIdentity)
+	if err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	var trans []schema.Transaction
+	if err := json.Unmarshal(data, &trans); err != nil {
+		return nil, fmt.Errorf("parse JSON: %w", err)
+	}
+	return trans, nil
+}
+
+// Remove the transaction with given ID from the file.
+// The file is removed when no transactions left.
+// Encrypted files are encrypted again.
+func removeFromFile(tx *txn.Tx, cfg *config.Config, file, id string) error {
+	trans, err := readFile(cfg, file)
+	if err != nil {
+		return err
+	}
+	for i, tran := range trans {
+		if ID(tran) == id {
+			trans = append(trans[:i], trans[i+1:]...)
+			break
+		}
+	}
+	if len(trans) == 0 {
+		return tx.Remove(file)
+	}
+	data, err := json.MarshalIndent(trans, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode JSON: %w", err)
+	}
+	data = append(data, '\n')
+	if strings.HasSuffix(file, crypt.Ext) {
+		if len(cfg.AgeRecipients) == 0 {
+			return errors.New("no age recipients to encrypt the file")
+		}
+		if data, err = crypt.Encrypt(data, cfg.AgeRecipients); err != nil {
+			return err
+		}
+	}
+	return tx.WriteFile(file, data)
+}
@@ -0,0 +1,86 @@
+package review
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/exporter"
+	"github.com/tuxofil/p24fetch/schema"
+	"github.com/tuxofil/p24fetch/sorter"
+)
+
+func TestCategorize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p24fetch-review")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := &config.Config{
+		MerchantName:         "test",
+		CardNumber:           "abcd",
+		RulesPath:            path.Join(dir, "rules.json"),
+		DedupDir:             path.Join(dir, "dedup"),
+		ResultsDir:           path.Join(dir, "results"),
+		ExportFormat:         schema.QIF,
+		SrcAccountName:       "Assets:Card",
+		ComissionAccountName: "Expenses:Comissions",
+	}
+	require.NoError(t, sorter.WriteRules(cfg.RulesPath, &sorter.Rules{
+		Accounts: map[string]string{"food": "Expenses:Food"},
+		Rules:    []map[string][]string{{"food": {"supermarket"}}},
+	}))
+
+	// Store unsorted transactions the way the main loop does
+	trans := []schema.Transaction{
+		{Date: time.Date(2020, 9, 20, 12, 0, 0, 0, time.UTC),
+			Src: "abcd", SrcVal: -10, SrcCur: schema.UAH,
+			Dst: "Bakery (Kyiv)", DstVal: 10, DstCur: schema.UAH,
+			Note: "bread"},
+		{Date: time.Date(2020, 9, 19, 12, 0, 0, 0, time.UTC),
+			Src: "abcd", SrcVal: 10, SrcCur: schema.UAH,
+			Dst: "Salary", DstVal: 10, DstCur: schema.UAH,
+			Error: "deposits are not implemented"},
+	}
+	unsortedCfg := *cfg
+	unsortedCfg.ExportFormat = schema.JSON
+	unsortedCfg.ResultsDir = UnsortedDir(cfg)
+	require.NoError(t, exporter.New(&unsortedCfg).Export(trans))
+
+	reviewer := New([]*config.Config{cfg})
+	items, err := reviewer.Unsorted()
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	assert.Equal(t, "Salary", items[0].Tran.Dst)
+	assert.False(t, items[0].CanCategorize())
+	assert.True(t, items[1].CanCategorize())
+
+	_, err = reviewer.Categorize(items[0].ID, "food", "")
+	assert.Error(t, err)
+	_, err = reviewer.Categorize(items[1].ID, "clothes", "")
+	assert.Error(t, err)
+	_, err = reviewer.Categorize("nonexistent", "food", "")
+	assert.Equal(t, ErrNotFound, err)
+
+	_, err = reviewer.Categorize(items[1].ID, "food", `^Bakery \(Kyiv\)$`)
+	require.NoError(t, err)
+
+	// The transaction is exported and removed from unsorted
+	data, err := ioutil.ReadFile(path.Join(cfg.ResultsDir, cfg.MaskedCard()+".qif"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "PBakery (Kyiv): bread\nSExpenses:Food\n")
+	items, err = reviewer.Unsorted()
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, "Salary", items[0].Tran.Dst)
+
+	// New rule is added
+	rules, err := sorter.ReadRules(cfg.RulesPath)
+	require.NoError(t, err)
+	assert.Equal(t, "Expenses:Food", rules.Map("Bakery (Kyiv)"))
+}
@@ -0,0 +1,217 @@
+// Package schedule implements parsing of cron-like expressions
+// and intervals used to schedule merchant processing.
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule calculates activation times.
+type Schedule interface {
+	// Next returns the next activation time after t.
+	Next(t time.Time) time.Time
+}
+
+// Interval is a schedule activating with a fixed period.
+type Interval time.Duration
+
+// Cron is a schedule defined by a standard 5-field cron expression:
+//
+//	minute hour day-of-month month day-of-week
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+}
+
+// Shorthands for popular cron expressions.
+var shorthands = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+// Field bounds
+type bounds struct {
+	min, max int
+}
+
+var (
+	minuteBounds = bounds{0, 59}
+	hourBounds   = bounds{0, 23}
+	domBounds    = bounds{1, 31}
+	monthBounds  = bounds{1, 12}
+	dowBounds    = bounds{0, 7}
+)
+
+// Parse parses schedule definition. Supported forms are:
+//   - Go duration, e.g. "6h30m";
+//   - interval, e.g. "@every 6h";
+//   - shorthand, e.g. "@daily";
+//   - 5-field cron expression, e.g. "0 */6 * * 1-5".
+func Parse(s string) (Schedule, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, errors.New("empty schedule")
+	}
+	if strings.HasPrefix(s, "@every ") {
+		return parseInterval(strings.TrimSpace(strings.TrimPrefix(s, "@every ")))
+	}
+	if expr, ok := shorthands[s]; ok {
+		s = expr
+	}
+	if _, err := time.ParseDuration(s); err == nil {
+		return parseInterval(s)
+	}
+	return parseCron(s)
+}
+
+func parseInterval(s string) (Schedule, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid interval: %w", err)
+	}
+	if d < time.Minute {
+		return nil, fmt.Errorf("interval is too short: %s", d)
+	}
+	return Interval(d), nil
+}
+
+func parseCron(s string) (Schedule, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid fields count in %#v: %d", s, len(fields))
+	}
+	var (
+		c   Cron
+		err error
+	)
+	if c.minute, err = parseField(fields[0], minuteBounds); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if c.hour, err = parseField(fields[1], hourBounds); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if c.dom, err = parseField(fields[2], domBounds); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if c.month, err = parseField(fields[3], monthBounds); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if c.dow, err = parseField(fields[4], dowBounds); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+	// Both 0 and 7 mean Sunday
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return &c, nil
+}
+
+// Parse comma separated list of ranges with optional steps
+// to a bit set.
+func parseField(s string, b bounds) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step: %#v", item)
+			}
+			item = item[:i]
+		}
+		from, to := b.min, b.max
+		switch {
+		case item == "*":
+		case strings.Contains(item, "-"):
+			tokens := strings.SplitN(item, "-", 2)
+			var err1, err2 error
+			from, err1 = strconv.Atoi(tokens[0])
+			to, err2 = strconv.Atoi(tokens[1])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range: %#v", item)
+			}
+		default:
+			n, err := strconv.Atoi(item)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value: %#v", item)
+			}
+			from = n
+			if step == 1 {
+				to = n
+			}
+		}
+		if from < b.min || to > b.max || from > to {
+			return 0, fmt.Errorf("out of range [%d-%d]: %#v", b.min, b.max, item)
+		}
+		for n := from; n <= to; n += step {
+			bits |= 1 << uint(n)
+		}
+	}
+	return bits, nil
+}
+
+// Next implements Schedule interface.
+func (i Interval) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+// Next implements Schedule interface.
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Give up when no match found in 5 years
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// Day matching follows the cron convention: when both day of month
+// and day of week are restricted, the day matches any of them.
+func (c *Cron) matchDay(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	domAny := c.dom == fullBits(domBounds)
+	dowAny := c.dow|1<<7 == fullBits(dowBounds)
+	switch {
+	case domAny && dowAny:
+		return true
+	case domAny:
+		return dowMatch
+	case dowAny:
+		return domMatch
+	}
+	return domMatch || dowMatch
+}
+
+func fullBits(b bounds) uint64 {
+	var bits uint64
+	for n := b.min; n <= b.max; n++ {
+		bits |= 1 << uint(n)
+	}
+	return bits
+}
@@ -0,0 +1,74 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	testset := []struct {
+		Subject       string
+		ExpectSuccess bool
+	}{
+		{"", false},
+		{"6h", true},
+		{"1s", false},
+		{"@every 30m", true},
+		{"@every", false},
+		{"@daily", true},
+		{"@fortnightly", false},
+		{"0 */6 * * *", true},
+		{"0 8 * * 1-5", true},
+		{"0,30 8-18/2 1,15 * 0,7", true},
+		{"0 8 * *", false},
+		{"60 8 * * *", false},
+		{"0 24 * * *", false},
+		{"0 8 0 * *", false},
+		{"0 8 * 13 *", false},
+		{"0 8 * * 8", false},
+		{"0 8-6 * * *", false},
+		{"0 */0 * * *", false},
+		{"a b c d e", false},
+	}
+	for n, test := range testset {
+		_, err := Parse(test.Subject)
+		if test.ExpectSuccess {
+			assert.NoError(t, err, "test #%d: %+v", n, test)
+		} else {
+			assert.Error(t, err, "test #%d: %+v", n, test)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// Saturday
+	base := time.Date(2020, 9, 19, 12, 13, 14, 0, time.UTC)
+	testset := []struct {
+		Schedule string
+		Expect   time.Time
+	}{
+		{"6h", base.Add(6 * time.Hour)},
+		{"@every 90m", base.Add(90 * time.Minute)},
+		{"* * * * *", time.Date(2020, 9, 19, 12, 14, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2020, 9, 19, 13, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2020, 9, 20, 0, 0, 0, 0, time.UTC)},
+		{"30 12 * * *", time.Date(2020, 9, 19, 12, 30, 0, 0, time.UTC)},
+		{"0 */6 * * *", time.Date(2020, 9, 19, 18, 0, 0, 0, time.UTC)},
+		{"0 8 * * 1-5", time.Date(2020, 9, 21, 8, 0, 0, 0, time.UTC)},
+		{"0 8 * * 7", time.Date(2020, 9, 20, 8, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2020, 10, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Day of month OR day of week
+		{"0 0 1 * 1", time.Date(2020, 9, 21, 0, 0, 0, 0, time.UTC)},
+	}
+	for n, test := range testset {
+		s, err := Parse(test.Schedule)
+		require.NoError(t, err, "test #%d: %+v", n, test)
+		assert.Equal(t, test.Expect, s.Next(base), "test #%d: %+v", n, test)
+	}
+}
@@ -38,6 +38,12 @@ type Transaction struct {
 	SrcCur Currency
 	// To account name
 	Dst string
+	// Counterparty code (EDRPOU). Optional.
+	DstCode string `json:"DstCode,omitempty"`
+	// Counterparty account (IBAN). Optional.
+	DstAccount string `json:"DstAccount,omitempty"`
+	// Merchant category code (ISO 18245). Optional.
+	MCC string `json:"MCC,omitempty"`
 	// To amount
 	DstVal float32
 	// To currency
@@ -62,6 +68,11 @@ type XMLTransaction struct {
 	Rest        string `xml:"rest,attr"`
 	Terminal    string `xml:"terminal,attr"`
 	Description string `xml:"description,attr"`
+	// Not returned by the Privat24 merchant API. Set by
+	// sources providing counterparty details.
+	CounterpartyCode    string `xml:"cntr_code,attr,omitempty"`
+	CounterpartyAccount string `xml:"cntr_acc,attr,omitempty"`
+	MCC                 string `xml:"mcc,attr,omitempty"`
 }
 
 // Parse currency
@@ -126,14 +137,17 @@ func ParseTransaction(xmlTran XMLTransaction) Transaction {
 		}
 	}
 	return Transaction{
-		Date:   date,
-		Src:    xmlTran.Card,
-		SrcVal: fromAmount,
-		SrcCur: fromCurrency,
-		Dst:    html.UnescapeString(xmlTran.Terminal),
-		DstVal: toAmount,
-		DstCur: toCurrency,
-		Note:   html.UnescapeString(xmlTran.Description),
+		Date:       date,
+		Src:        xmlTran.Card,
+		SrcVal:     fromAmount,
+		SrcCur:     fromCurrency,
+		Dst:        html.UnescapeString(xmlTran.Terminal),
+		DstCode:    xmlTran.CounterpartyCode,
+		DstAccount: xmlTran.CounterpartyAccount,
+		MCC:        xmlTran.MCC,
+		DstVal:     toAmount,
+		DstCur:     toCurrency,
+		Note:       html.UnescapeString(xmlTran.Description),
 	}
 }
 
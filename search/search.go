@@ -0,0 +1,221 @@
+// Package search implements queries over archived transactions.
+//
+// A query is a list of space separated terms; all terms must match.
+// Terms with spaces are enclosed in double quotes. Supported terms:
+//
+//	payee:TEXT          beneficiary name contains TEXT
+//	note:TEXT           transaction note contains TEXT
+//	account:TEXT        GnuCash Account ID contains TEXT
+//	card:DIGITS         card number ends with DIGITS
+//	amount:A..B         absolute amount in [A, B]; A or B may be omitted
+//	amount:A            absolute amount equals A
+//	date:FROM..TO       date in [FROM, TO]; FROM or TO may be omitted
+//	date:DATE           date matches DATE
+//	TEXT                beneficiary name or note contains TEXT
+//
+// Dates are YYYY, YYYY-MM or YYYY-MM-DD. Text matching is
+// case insensitive.
+package search
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tuxofil/p24fetch/archive"
+)
+
+// Query is a parsed search query.
+type Query struct {
+	terms []term
+}
+
+// A single query term
+type term func(record archive.Record) bool
+
+// Parse the query.
+func Parse(query string) (*Query, error) {
+	tokens, err := split(query)
+	if err != nil {
+		return nil, err
+	}
+	q := &Query{}
+	for _, token := range tokens {
+		t, err := parseTerm(token)
+		if err != nil {
+			return nil, fmt.Errorf("term %#v: %w", token, err)
+		}
+		q.terms = append(q.terms, t)
+	}
+	return q, nil
+}
+
+// Match reports whether the record matches all terms of the query.
+func (q *Query) Match(record archive.Record) bool {
+	for _, t := range q.terms {
+		if !t(record) {
+			return false
+		}
+	}
+	return true
+}
+
+// Split the query to terms.
+func split(query string) ([]string, error) {
+	var (
+		res    []string
+		cur    strings.Builder
+		quoted bool
+		found  bool
+	)
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			found = true
+		case r == ' ' && !quoted:
+			if found {
+				res = append(res, cur.String())
+			}
+			cur.Reset()
+			found = false
+		default:
+			cur.WriteRune(r)
+			found = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quote")
+	}
+	if found {
+		res = append(res, cur.String())
+	}
+	return res, nil
+}
+
+func parseTerm(token string) (term, error) {
+	field, value := "", token
+	if i := strings.Index(token, ":"); i > 0 {
+		switch name := token[:i]; name {
+		case "payee", "note", "account", "card", "amount", "date":
+			field, value = name, token[i+1:]
+		}
+	}
+	if value == "" {
+		return nil, errors.New("empty value")
+	}
+	text := strings.ToLower(value)
+	contains := func(s string) bool {
+		return strings.Contains(strings.ToLower(s), text)
+	}
+	switch field {
+	case "payee":
+		return func(r archive.Record) bool { return contains(r.Transaction.Dst) }, nil
+	case "note":
+		return func(r archive.Record) bool { return contains(r.Transaction.Note) }, nil
+	case "account":
+		return func(r archive.Record) bool { return contains(r.Account) }, nil
+	case "card":
+		return func(r archive.Record) bool {
+			return strings.HasSuffix(r.Card, value) ||
+				strings.HasSuffix(r.Transaction.Src, value)
+		}, nil
+	case "amount":
+		min, max, err := parseAmountRange(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(r archive.Record) bool {
+			v := math.Abs(float64(r.Transaction.SrcVal))
+			return r.Transaction.Raw == nil && v >= min-0.005 && v <= max+0.005
+		}, nil
+	case "date":
+		from, to, err := parseDateRange(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(r archive.Record) bool {
+			date := r.Transaction.Date
+			return r.Transaction.Raw == nil && !date.Before(from) && date.Before(to)
+		}, nil
+	}
+	return func(r archive.Record) bool {
+		return contains(r.Transaction.Dst) || contains(r.Transaction.Note)
+	}, nil
+}
+
+// Parse amount or amount range.
+func parseAmountRange(s string) (min, max float64, err error) {
+	parse := func(s string, def float64) (float64, error) {
+		if s == "" {
+			return def, nil
+		}
+		return strconv.ParseFloat(s, 64)
+	}
+	lo, hi := s, s
+	if i := strings.Index(s, ".."); i >= 0 {
+		lo, hi = s[:i], s[i+2:]
+		if lo == "" && hi == "" {
+			return 0, 0, errors.New("empty range")
+		}
+	}
+	if min, err = parse(lo, 0); err != nil {
+		return 0, 0, fmt.Errorf("invalid amount: %w", err)
+	}
+	if max, err = parse(hi, math.Inf(1)); err != nil {
+		return 0, 0, fmt.Errorf("invalid amount: %w", err)
+	}
+	if min > max {
+		return 0, 0, errors.New("empty range")
+	}
+	return min, max, nil
+}
+
+// Parse date or date range. Returns [from, to) interval.
+func parseDateRange(s string) (from, to time.Time, err error) {
+	lo, hi := s, s
+	if i := strings.Index(s, ".."); i >= 0 {
+		lo, hi = s[:i], s[i+2:]
+		if lo == "" && hi == "" {
+			return from, to, errors.New("empty range")
+		}
+	}
+	if lo != "" {
+		if from, _, err = parseDate(lo); err != nil {
+			return from, to, err
+		}
+	}
+	if hi != "" {
+		if _, to, err = parseDate(hi); err != nil {
+			return from, to, err
+		}
+	} else {
+		to = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if !from.Before(to) {
+		return from, to, errors.New("empty range")
+	}
+	return from, to, nil
+}
+
+// Parse date of any supported precision.
+// Returns [start, end) interval covered by the date.
+func parseDate(s string) (start, end time.Time, err error) {
+	for _, layout := range []struct {
+		layout        string
+		years, months int
+		days          int
+	}{
+		{"2006-01-02", 0, 0, 1},
+		{"2006-01", 0, 1, 0},
+		{"2006", 1, 0, 0},
+	} {
+		if start, err = time.Parse(layout.layout, s); err == nil {
+			return start, start.AddDate(layout.years, layout.months, layout.days), nil
+		}
+	}
+	return start, end, fmt.Errorf("invalid date: %#v", s)
+}
@@ -0,0 +1,80 @@
+package search
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tuxofil/p24fetch/archive"
+	"github.com/tuxofil/p24fetch/schema"
+	"github.com/tuxofil/p24fetch/sorter"
+)
+
+func TestQuery(t *testing.T) {
+	record := archive.Record{
+		Card: "4149000011112222",
+		Transaction: schema.Transaction{
+			Date:   time.Date(2020, 9, 20, 12, 0, 0, 0, time.UTC),
+			SrcVal: -350.5, SrcCur: schema.UAH,
+			Dst:  "Dental Clinic Smile",
+			Note: "Payment for services",
+		},
+		Outcome: sorter.Sorted,
+		Account: "Expenses:Medical",
+	}
+	testset := []struct {
+		Query       string
+		ExpectMatch bool
+	}{
+		{"", true},
+		{"clinic", true},
+		{"CLINIC services", true},
+		{"clinic bakery", false},
+		{`"clinic smile"`, true},
+		{`"clinic  smile"`, false},
+		{"payee:dental", true},
+		{"payee:services", false},
+		{"note:services", true},
+		{"account:medical", true},
+		{"account:food", false},
+		{"card:2222", true},
+		{"card:1111", false},
+		{"amount:350.5", true},
+		{"amount:350", false},
+		{"amount:300..400", true},
+		{"amount:..300", false},
+		{"amount:300..", true},
+		{"date:2020", true},
+		{"date:2020-09", true},
+		{"date:2020-09-20", true},
+		{"date:2020-09-21", false},
+		{"date:2020-09-01..2020-09-20", true},
+		{"date:..2020-08", false},
+		{"date:2020-09-20..", true},
+		{"payee:dental amount:300..400 date:2020-09", true},
+		{"payee:dental amount:..300", false},
+	}
+	for n, test := range testset {
+		q, err := Parse(test.Query)
+		if assert.NoError(t, err, "test #%d: %+v", n, test) {
+			assert.Equal(t, test.ExpectMatch, q.Match(record),
+				"test #%d: %+v", n, test)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, query := range []string{
+		`"unterminated`,
+		"payee:",
+		"amount:abc",
+		"amount:..",
+		"amount:20..10",
+		"date:20-09",
+		"date:2020-10..2020-09",
+	} {
+		_, err := Parse(query)
+		assert.Error(t, err, "query: %#v", query)
+	}
+}
@@ -0,0 +1,82 @@
+package slack
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/slack-go/slack"
+
+	"github.com/tuxofil/p24fetch/review"
+	"github.com/tuxofil/p24fetch/schema"
+)
+
+// Action IDs of the account choice menus
+const (
+	actionCategorize         = "categorize"
+	actionCategorizeWithRule = "categorize_with_rule"
+)
+
+// Slack limits count of options in a select menu
+const maxOptions = 100
+
+// Build Block Kit message for the unsorted transaction.
+// Block ID of the actions block is the transaction ID,
+// values of the options are account short IDs.
+func unsortedBlocks(text string, tran schema.Transaction, accounts map[string]string) []slack.Block {
+	blocks := []slack.Block{
+		slack.NewSectionBlock(
+			slack.NewTextBlockObject(slack.MarkdownType, text, false, false),
+			nil, nil),
+	}
+	if tran.Error != "" || tran.Raw != nil || len(accounts) == 0 {
+		return blocks
+	}
+	shortIDs := make([]string, 0, len(accounts))
+	for shortID := range accounts {
+		shortIDs = append(shortIDs, shortID)
+	}
+	sort.Slice(shortIDs, func(i, j int) bool {
+		return accounts[shortIDs[i]] < accounts[shortIDs[j]]
+	})
+	if len(shortIDs) > maxOptions {
+		shortIDs = shortIDs[:maxOptions]
+	}
+	var options []*slack.OptionBlockObject
+	for _, shortID := range shortIDs {
+		options = append(options, slack.NewOptionBlockObject(shortID,
+			slack.NewTextBlockObject(slack.PlainTextType,
+				truncate(accounts[shortID], 75), false, false)))
+	}
+	return append(blocks, slack.NewActionBlock(review.ID(tran),
+		slack.NewOptionsSelectBlockElement(slack.OptTypeStatic,
+			slack.NewTextBlockObject(slack.PlainTextType,
+				"Categorise", false, false),
+			actionCategorize, options...),
+		slack.NewOptionsSelectBlockElement(slack.OptTypeStatic,
+			slack.NewTextBlockObject(slack.PlainTextType,
+				"Categorise and add rule", false, false),
+			actionCategorizeWithRule, options...),
+	))
+}
+
+// Replace the account choice menus of the transaction
+// with the text, leaving other blocks of the message intact.
+func categorizedBlocks(blocks []slack.Block, id, text string) []slack.Block {
+	result := make([]slack.Block, 0, len(blocks))
+	for _, block := range blocks {
+		if action, ok := block.(*slack.ActionBlock); ok && action.BlockID == id {
+			block = slack.NewContextBlock("", slack.NewTextBlockObject(
+				slack.MarkdownType, text, false, false))
+		}
+		result = append(result, block)
+	}
+	return result
+}
+
+// Truncate the string to n runes.
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return fmt.Sprintf("%s…", string(r[:n-1]))
+	}
+	return s
+}
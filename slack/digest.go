@@ -0,0 +1,156 @@
+package slack
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/slack-go/slack"
+
+	"github.com/tuxofil/p24fetch/notify"
+	"github.com/tuxofil/p24fetch/schema"
+)
+
+// Digests longer than this are uploaded as files
+const maxDigestLength = 3500
+
+// Count of unsorted transactions posted with account choice
+// menus in a single message. Every transaction takes two
+// blocks and Slack allows up to 50 blocks in a message.
+const digestBatchSize = 10
+
+// ReportDigest posts a single message summarising the merchant run:
+// a table of unsorted transactions, totals per currency and counts
+// of sorted and ignored transactions. When the table does not fit
+// into a message, it is uploaded as a file.
+// Runs with no transactions at all are not reported.
+// When interactivity is configured (slack_signing_secret is set),
+// unsorted transactions are posted to the digest thread with
+// account choice menus, several transactions per message.
+func (s *Slack) ReportDigest(run notify.Run) error {
+	if !s.IsActive() ||
+		len(run.Unsorted)+len(run.Sorted)+len(run.Ignored) == 0 {
+		return nil
+	}
+	header := digestHeader(run)
+	if len(run.Unsorted) == 0 {
+		_, _, err := s.client.PostMessage(s.config.SlackChannel,
+			slack.MsgOptionText(header, false))
+		if err != nil {
+			return &DeliveryError{Failed: 1, Total: 1, Err: err}
+		}
+		return nil
+	}
+	table := digestTable(run.Unsorted)
+	var (
+		ts  string
+		err error
+	)
+	if len(header)+len(table) <= maxDigestLength {
+		_, ts, err = s.client.PostMessage(s.config.SlackChannel,
+			slack.MsgOptionText(header+"\n```"+table+"```", false))
+	} else {
+		_, ts, err = s.client.PostMessage(s.config.SlackChannel,
+			slack.MsgOptionText(header+"\nThe table is too large, see the attached file.", false))
+		if err == nil {
+			_, err = s.client.UploadFile(slack.FileUploadParameters{
+				Content:         table,
+				Filetype:        "text",
+				Filename:        "unsorted-" + time.Now().Format("2006-01-02T15-04-05") + ".txt",
+				Title:           "Unsorted transactions from " + run.Merchant,
+				Channels:        []string{s.config.SlackChannel},
+				ThreadTimestamp: ts,
+			})
+		}
+	}
+	if err != nil {
+		return &DeliveryError{Failed: 1, Total: 1, Err: err}
+	}
+	if s.config.SlackSigningSecret == "" {
+		return nil
+	}
+	var derr *DeliveryError
+	total := (len(run.Unsorted) + digestBatchSize - 1) / digestBatchSize
+	for i := 0; i < len(run.Unsorted); i += digestBatchSize {
+		end := i + digestBatchSize
+		if end > len(run.Unsorted) {
+			end = len(run.Unsorted)
+		}
+		time.Sleep(time.Second)
+		var (
+			texts  []string
+			blocks []slack.Block
+		)
+		for _, tran := range run.Unsorted[i:end] {
+			text := fmt.Sprintf("```%s```", tran.String())
+			texts = append(texts, text)
+			blocks = append(blocks, unsortedBlocks(text, tran, run.Accounts)...)
+		}
+		_, _, err := s.client.PostMessage(s.config.SlackChannel,
+			slack.MsgOptionTS(ts),
+			slack.MsgOptionText(strings.Join(texts, "\n"), false),
+			slack.MsgOptionBlocks(blocks...))
+		if err != nil {
+			if derr == nil {
+				derr = &DeliveryError{Total: total}
+			}
+			derr.Failed++
+			derr.Err = err
+		}
+	}
+	if derr != nil {
+		return derr
+	}
+	return nil
+}
+
+// Format the digest header.
+func digestHeader(run notify.Run) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Transactions from `%s`: %d unsorted, %d sorted, %d ignored.",
+		run.Merchant, len(run.Unsorted), len(run.Sorted), len(run.Ignored))
+	totals := map[schema.Currency]float32{}
+	var currencies []string
+	for _, tran := range run.Unsorted {
+		if tran.Raw != nil {
+			continue
+		}
+		if _, ok := totals[tran.SrcCur]; !ok {
+			currencies = append(currencies, string(tran.SrcCur))
+		}
+		totals[tran.SrcCur] += tran.SrcVal
+	}
+	sort.Strings(currencies)
+	if len(currencies) > 0 {
+		buf.WriteString("\nUnsorted totals:")
+		for i, cur := range currencies {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			fmt.Fprintf(&buf, " %.2f %s", totals[schema.Currency(cur)], cur)
+		}
+	}
+	return buf.String()
+}
+
+// Format unsorted transactions as a text table.
+func digestTable(trans []schema.Transaction) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "DATE\t%10s\t\tBENEFICIARY\n", "AMOUNT")
+	for _, tran := range trans {
+		if tran.Raw != nil {
+			fmt.Fprintf(w, "%s %s\t\t\t%s\n", tran.Raw.TranDate,
+				tran.Raw.TranTime, tran.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%10.2f\t%s\t%s\n",
+			tran.Date.Format("2006-01-02 15:04"), tran.SrcVal,
+			tran.SrcCur, truncate(tran.Dst, 40))
+	}
+	_ = w.Flush()
+	return buf.String()
+}
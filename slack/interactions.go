@@ -0,0 +1,193 @@
+package slack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/logger"
+	"github.com/tuxofil/p24fetch/review"
+)
+
+// Maximum size of an interaction payload
+const maxPayloadSize = 1 << 20
+
+// Interactions is an HTTP handler for Slack interaction payloads
+// sent when a user chooses an account for an unsorted transaction.
+type Interactions struct {
+	// Unsorted transactions manager
+	reviewer *review.Reviewer
+	// HTTP client used to post to response URLs
+	httpClient *http.Client
+	// Guards secrets
+	mu sync.Mutex
+	// Signing secrets of the configured Slack apps
+	secrets []string
+	// Tracks interactions being processed
+	running sync.WaitGroup
+}
+
+// NewInteractions creates new Slack interactions handler.
+func NewInteractions(configs []*config.Config, reviewer *review.Reviewer) *Interactions {
+	h := &Interactions{
+		reviewer:   reviewer,
+		httpClient: &http.Client{Timeout: 15 * time.Second},
+	}
+	h.SetConfigs(configs)
+	return h
+}
+
+// SetConfigs updates signing secrets from merchants configurations.
+func (h *Interactions) SetConfigs(configs []*config.Config) {
+	var secrets []string
+	seen := map[string]bool{}
+	for _, cfg := range configs {
+		if s := cfg.SlackSigningSecret; s != "" && !seen[s] {
+			seen[s] = true
+			secrets = append(secrets, s)
+		}
+	}
+	h.mu.Lock()
+	h.secrets = secrets
+	h.mu.Unlock()
+}
+
+// ServeHTTP implements http.Handler interface. Slack expects the
+// request to be acknowledged within 3 seconds, so chosen accounts
+// are recorded in background and the result is posted to the
+// response URL.
+func (h *Interactions) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPayloadSize))
+	if err != nil {
+		http.Error(w, "read body", http.StatusBadRequest)
+		return
+	}
+	if !h.verify(r.Header, body) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	var callback slack.InteractionCallback
+	if err := json.Unmarshal([]byte(form.Get("payload")), &callback); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if callback.Type != slack.InteractionTypeBlockActions {
+		return
+	}
+	for _, action := range callback.ActionCallback.BlockActions {
+		switch action.ActionID {
+		case actionCategorize, actionCategorizeWithRule:
+			h.running.Add(1)
+			go func(action *slack.BlockAction) {
+				defer h.running.Done()
+				h.categorize(&callback, action)
+			}(action)
+		}
+	}
+}
+
+// Wait for interactions being processed.
+func (h *Interactions) Wait() {
+	h.running.Wait()
+}
+
+// Check the request signature against all known signing secrets.
+func (h *Interactions) verify(header http.Header, body []byte) bool {
+	h.mu.Lock()
+	secrets := h.secrets
+	h.mu.Unlock()
+	for _, secret := range secrets {
+		sv, err := slack.NewSecretsVerifier(header, secret)
+		if err != nil {
+			return false
+		}
+		if _, err := sv.Write(body); err != nil {
+			return false
+		}
+		if sv.Ensure() == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Record the account chosen for the transaction and
+// report the result back to the channel.
+func (h *Interactions) categorize(callback *slack.InteractionCallback, action *slack.BlockAction) {
+	id, shortID := action.BlockID, action.SelectedOption.Value
+	item, err := h.reviewer.Find(id)
+	if err == nil {
+		var pattern string
+		if action.ActionID == actionCategorizeWithRule {
+			pattern = regexp.QuoteMeta(item.Tran.Dst)
+		}
+		item, err = h.reviewer.Categorize(id, shortID, pattern)
+	}
+	var reply map[string]interface{}
+	if err != nil {
+		logger.Default().Errorf("Slack: categorise %s as %s: %s", id, shortID, err)
+		reply = map[string]interface{}{
+			"response_type":    "ephemeral",
+			"replace_original": false,
+			"text":             fmt.Sprintf("Failed to categorise: %s", err),
+		}
+	} else {
+		item.Config.Log().Infof("transaction %s categorised as %s by %s",
+			id, shortID, callback.User.Name)
+		reply = map[string]interface{}{
+			"replace_original": true,
+			"text": fmt.Sprintf(
+				"Transaction from `%s` categorised as `%s` by <@%s>:\n```%s```",
+				item.Config.MerchantName, shortID, callback.User.ID,
+				item.Tran.String()),
+		}
+		// Keep menus of other transactions posted in the same message
+		if blocks := callback.Message.Blocks.BlockSet; len(blocks) > 0 {
+			reply["blocks"] = categorizedBlocks(blocks, id, fmt.Sprintf(
+				"Categorised as `%s` by <@%s>", shortID, callback.User.ID))
+		}
+	}
+	if callback.ResponseURL == "" {
+		return
+	}
+	if err := h.respond(callback.ResponseURL, reply); err != nil {
+		logger.Default().Errorf("Slack: respond: %s", err)
+	}
+}
+
+// Post the message to the interaction response URL.
+func (h *Interactions) respond(responseURL string, msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+	resp, err := h.httpClient.Post(responseURL, "application/json",
+		bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("do request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("invalid response status: %s", resp.Status)
+	}
+	return nil
+}
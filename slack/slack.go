@@ -2,11 +2,14 @@ package slack
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/slack-go/slack"
 
+	"github.com/tuxofil/p24fetch/alerts"
 	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/notify"
 	"github.com/tuxofil/p24fetch/schema"
 )
 
@@ -26,21 +29,96 @@ func New(cfg *config.Config) (*Slack, error) {
 	return s, nil
 }
 
-// Send Slack notifications for unsorted transactions
-func (s *Slack) ReportUnsorted(trans []schema.Transaction) {
+// DeliveryError is returned when some of messages were not delivered.
+type DeliveryError struct {
+	// Count of messages failed to deliver
+	Failed int
+	// Total count of messages
+	Total int
+	// The last delivery error
+	Err error
+}
+
+func (e *DeliveryError) Error() string {
+	return fmt.Sprintf("%d of %d messages not delivered: %s",
+		e.Failed, e.Total, e.Err)
+}
+
+func (e *DeliveryError) Unwrap() error {
+	return e.Err
+}
+
+// Send Slack notifications for unsorted transactions.
+// Every message contains account choice menus built from
+// the accounts mapping (ShortID -> GnuCash Account ID).
+// Returns *DeliveryError when some messages were not delivered.
+func (s *Slack) ReportUnsorted(trans []schema.Transaction, accounts map[string]string) error {
 	if !s.IsActive() {
-		return
+		return nil
 	}
+	var derr *DeliveryError
 	for _, tran := range trans {
 		time.Sleep(time.Second)
+		text := fmt.Sprintf("Unsorted transaction from `%s`:\n```%s```",
+			s.config.MerchantName, tran.String())
 		_, _, err := s.client.PostMessage(s.config.SlackChannel,
-			slack.MsgOptionText(fmt.Sprintf(
-				"Unsorted transaction from `%s`:\n```%s```",
-				s.config.MerchantName, tran.String()), false))
+			slack.MsgOptionText(text, false),
+			slack.MsgOptionBlocks(unsortedBlocks(text, tran, accounts)...))
 		if err != nil {
-			s.config.Logf("post to Slack: %s", err)
+			s.config.Log().Errorf("post to Slack: %s", err)
+			if derr == nil {
+				derr = &DeliveryError{Total: len(trans)}
+			}
+			derr.Failed++
+			derr.Err = err
 		}
 	}
+	if derr != nil {
+		return derr
+	}
+	return nil
+}
+
+// Send a single message listing all spending alerts raised.
+// Returns *DeliveryError when the message was not delivered.
+func (s *Slack) ReportAlerts(list []alerts.Alert) error {
+	if !s.IsActive() || len(list) == 0 {
+		return nil
+	}
+	var buf strings.Builder
+	fmt.Fprintf(&buf, ":warning: Spending alerts for `%s`:", s.config.MerchantName)
+	for _, alert := range list {
+		fmt.Fprintf(&buf, "\n• *%s*: %s", alertTitles[alert.Kind], alert.Message)
+	}
+	_, _, err := s.client.PostMessage(s.config.SlackChannel,
+		slack.MsgOptionText(buf.String(), false))
+	if err != nil {
+		return &DeliveryError{Failed: 1, Total: 1, Err: err}
+	}
+	return nil
+}
+
+// Titles of alerts by kind
+var alertTitles = map[string]string{
+	alerts.LargeTransaction: "Large transaction",
+	alerts.DailyLimit:       "Daily limit exceeded",
+	alerts.WeeklyLimit:      "Weekly limit exceeded",
+	alerts.NewCountry:       "New country",
+	alerts.UnknownTerminal:  "Unknown terminal",
+	alerts.LowBalance:       "Low balance",
+}
+
+// Name implements notify.Notifier interface.
+func (s *Slack) Name() string {
+	return "slack"
+}
+
+// ReportRun implements notify.Notifier interface.
+func (s *Slack) ReportRun(run notify.Run) error {
+	if s.config.SlackDigest {
+		return s.ReportDigest(run)
+	}
+	return s.ReportUnsorted(run.Unsorted, run.Accounts)
 }
 
 // Send message to a configured channel.
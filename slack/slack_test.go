@@ -0,0 +1,302 @@
+package slack
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/exporter"
+	"github.com/tuxofil/p24fetch/notify"
+	"github.com/tuxofil/p24fetch/review"
+	"github.com/tuxofil/p24fetch/schema"
+	"github.com/tuxofil/p24fetch/sorter"
+)
+
+const testSecret = "8f742231b10e8888abcd99yyyzzz85a5"
+
+// Local Slack stand-in recording all requests.
+type fakeSlack struct {
+	*httptest.Server
+	mu        sync.Mutex
+	posted    []url.Values
+	uploaded  []url.Values
+	responses []map[string]interface{}
+}
+
+func newFakeSlack() *fakeSlack {
+	f := &fakeSlack{}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	return f
+}
+
+func (f *fakeSlack) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.URL.Path {
+	case "/chat.postMessage":
+		_ = r.ParseForm()
+		f.posted = append(f.posted, r.PostForm)
+		fmt.Fprint(w, `{"ok": true, "channel": "C1", "ts": "1600000000.000100"}`)
+	case "/auth.test":
+		fmt.Fprint(w, `{"ok": true}`)
+	case "/files.upload":
+		_ = r.ParseForm()
+		f.uploaded = append(f.uploaded, r.PostForm)
+		fmt.Fprint(w, `{"ok": true, "file": {"id": "F1"}}`)
+	case "/response":
+		var msg map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&msg)
+		f.responses = append(f.responses, msg)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestInteractiveCategorisation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "p24fetch-slack")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	fake := newFakeSlack()
+	defer fake.Close()
+
+	cfg := &config.Config{
+		MerchantName:         "test",
+		CardNumber:           "abcd",
+		RulesPath:            path.Join(dir, "rules.json"),
+		DedupDir:             path.Join(dir, "dedup"),
+		ResultsDir:           path.Join(dir, "results"),
+		ExportFormat:         schema.QIF,
+		SrcAccountName:       "Assets:Card",
+		ComissionAccountName: "Expenses:Comissions",
+		SlackToken:           "xoxp-test",
+		SlackChannel:         "C1",
+		SlackSigningSecret:   testSecret,
+	}
+	accounts := map[string]string{
+		"food":    "Expenses:Food",
+		"clothes": "Expenses:Clothes",
+	}
+	require.NoError(t, sorter.WriteRules(cfg.RulesPath, &sorter.Rules{
+		Accounts: accounts,
+		Rules:    []map[string][]string{{"food": {"supermarket"}}},
+	}))
+	tran := schema.Transaction{
+		Date: time.Date(2020, 9, 20, 12, 0, 0, 0, time.UTC),
+		Src:  "abcd", SrcVal: -10, SrcCur: schema.UAH,
+		Dst: "Bakery", DstVal: 10, DstCur: schema.UAH,
+		Note: "bread",
+	}
+	unsortedCfg := *cfg
+	unsortedCfg.ExportFormat = schema.JSON
+	unsortedCfg.ResultsDir = review.UnsortedDir(cfg)
+	require.NoError(t, exporter.New(&unsortedCfg).Export([]schema.Transaction{tran}))
+
+	// Post the unsorted transaction
+	s := &Slack{
+		config: *cfg,
+		client: slack.New(cfg.SlackToken, slack.OptionAPIURL(fake.URL+"/")),
+	}
+	require.NoError(t, s.ReportUnsorted([]schema.Transaction{tran}, accounts))
+	require.Len(t, fake.posted, 1)
+	blocks := fake.posted[0].Get("blocks")
+	assert.Contains(t, blocks, `"block_id":"`+review.ID(tran)+`"`)
+	assert.Contains(t, blocks, `"action_id":"categorize_with_rule"`)
+	assert.Contains(t, blocks, `"value":"food"`)
+
+	// Choose the account
+	payload, err := json.Marshal(map[string]interface{}{
+		"type":         "block_actions",
+		"response_url": fake.URL + "/response",
+		"user":         map[string]string{"id": "U1", "name": "bob"},
+		"message":      map[string]interface{}{"blocks": json.RawMessage(blocks)},
+		"actions": []map[string]interface{}{{
+			"type":            "static_select",
+			"action_id":       actionCategorizeWithRule,
+			"block_id":        review.ID(tran),
+			"selected_option": map[string]string{"value": "food"},
+		}},
+	})
+	require.NoError(t, err)
+	body := url.Values{"payload": {string(payload)}}.Encode()
+	handler := NewInteractions([]*config.Config{cfg},
+		review.New([]*config.Config{cfg}))
+
+	// Wrong signature
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, signedRequest(body, "wrong secret"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// The request is acknowledged without waiting for the card lock
+	unlock, err := cfg.LockCard(context.Background(), 0)
+	require.NoError(t, err)
+	started := time.Now()
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, signedRequest(body, testSecret))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, time.Since(started) < time.Second)
+	unlock()
+	handler.Wait()
+
+	// The transaction is exported, the rule is added
+	data, err := ioutil.ReadFile(path.Join(cfg.ResultsDir, cfg.MaskedCard()+".qif"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "PBakery: bread\nSExpenses:Food\n")
+	rules, err := sorter.ReadRules(cfg.RulesPath)
+	require.NoError(t, err)
+	assert.Equal(t, "Expenses:Food", rules.Map("Bakery"))
+
+	// The message is replaced
+	require.Len(t, fake.responses, 1)
+	assert.Equal(t, true, fake.responses[0]["replace_original"])
+	assert.Contains(t, fake.responses[0]["text"], "categorised as `food`")
+	assert.Len(t, fake.responses[0]["blocks"], 2)
+	assert.NotContains(t, fmt.Sprint(fake.responses[0]["blocks"]), "categorize_with_rule")
+
+	// Repeated choice is reported as an error
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, signedRequest(body, testSecret))
+	assert.Equal(t, http.StatusOK, w.Code)
+	handler.Wait()
+	require.Len(t, fake.responses, 2)
+	assert.Equal(t, false, fake.responses[1]["replace_original"])
+}
+
+// Create interaction request signed the way Slack does.
+func signedRequest(body, secret string) *http.Request {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte("v0:" + ts + ":" + body))
+	req := httptest.NewRequest(http.MethodPost, "/slack/interactions",
+		strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestReportDigest(t *testing.T) {
+	fake := newFakeSlack()
+	defer fake.Close()
+	s := &Slack{
+		config: config.Config{
+			SlackChannel: "C1",
+			SlackDigest:  true,
+		},
+		client: slack.New("xoxp-test", slack.OptionAPIURL(fake.URL+"/")),
+	}
+	date := time.Date(2020, 9, 20, 12, 0, 0, 0, time.UTC)
+	run := notify.Run{
+		Merchant: "card",
+		Sorted:   make([]schema.Transaction, 3),
+		Ignored:  make([]schema.Transaction, 1),
+		Unsorted: []schema.Transaction{
+			{Date: date, SrcVal: -10.5, SrcCur: schema.UAH, Dst: "Bakery"},
+			{Date: date, SrcVal: -2, SrcCur: schema.USD, Dst: "Steam"},
+			{Date: date, SrcVal: -1.25, SrcCur: schema.UAH, Dst: "Kiosk"},
+		},
+	}
+
+	// Nothing to report
+	require.NoError(t, s.ReportRun(notify.Run{Merchant: "card"}))
+	assert.Len(t, fake.posted, 0)
+
+	// Counts are reported when all transactions are sorted
+	require.NoError(t, s.ReportRun(notify.Run{Merchant: "card",
+		Sorted: run.Sorted, Ignored: run.Ignored}))
+	require.Len(t, fake.posted, 1)
+	assert.Equal(t, "Transactions from `card`: 0 unsorted, 3 sorted, 1 ignored.",
+		fake.posted[0].Get("text"))
+	fake.posted = nil
+
+	require.NoError(t, s.ReportRun(run))
+	require.Len(t, fake.posted, 1)
+	assert.Equal(t, "Transactions from `card`: 3 unsorted, 3 sorted, 1 ignored.\n"+
+		"Unsorted totals: -11.75 UAH, -2.00 USD\n"+
+		"```DATE                  AMOUNT       BENEFICIARY\n"+
+		"2020-09-20 12:00      -10.50  UAH  Bakery\n"+
+		"2020-09-20 12:00       -2.00  USD  Steam\n"+
+		"2020-09-20 12:00       -1.25  UAH  Kiosk\n```",
+		fake.posted[0].Get("text"))
+	assert.Len(t, fake.uploaded, 0)
+
+	// Large digests are uploaded as files
+	for i := 0; i < 100; i++ {
+		run.Unsorted = append(run.Unsorted, run.Unsorted[0])
+	}
+	require.NoError(t, s.ReportRun(run))
+	require.Len(t, fake.posted, 2)
+	assert.Contains(t, fake.posted[1].Get("text"), "see the attached file")
+	require.Len(t, fake.uploaded, 1)
+	assert.Equal(t, "1600000000.000100", fake.uploaded[0].Get("thread_ts"))
+	assert.Contains(t, fake.uploaded[0].Get("content"), "Bakery")
+}
+
+func TestReportDigestInteractive(t *testing.T) {
+	fake := newFakeSlack()
+	defer fake.Close()
+	s := &Slack{
+		config: config.Config{
+			SlackChannel:       "C1",
+			SlackDigest:        true,
+			SlackSigningSecret: testSecret,
+		},
+		client: slack.New("xoxp-test", slack.OptionAPIURL(fake.URL+"/")),
+	}
+	run := notify.Run{
+		Merchant: "card",
+		Accounts: map[string]string{"food": "Expenses:Food"},
+	}
+	for i := 0; i < digestBatchSize+2; i++ {
+		run.Unsorted = append(run.Unsorted, schema.Transaction{
+			Date:   time.Date(2020, 9, 20, 12, i, 0, 0, time.UTC),
+			SrcVal: -10, SrcCur: schema.UAH, Dst: "Bakery",
+		})
+	}
+
+	// Menus are posted to the digest thread in batches
+	require.NoError(t, s.ReportRun(run))
+	require.Len(t, fake.posted, 3)
+	for i, batch := range []int{digestBatchSize, 2} {
+		msg := fake.posted[i+1]
+		assert.Equal(t, "1600000000.000100", msg.Get("thread_ts"))
+		var blocks slack.Blocks
+		require.NoError(t, json.Unmarshal([]byte(msg.Get("blocks")), &blocks))
+		assert.Len(t, blocks.BlockSet, 2*batch)
+	}
+}
+
+func TestCategorizedBlocks(t *testing.T) {
+	accounts := map[string]string{"food": "Expenses:Food"}
+	date := time.Date(2020, 9, 20, 12, 0, 0, 0, time.UTC)
+	bakery := schema.Transaction{Date: date, SrcVal: -10, SrcCur: schema.UAH, Dst: "Bakery"}
+	kiosk := schema.Transaction{Date: date, SrcVal: -2, SrcCur: schema.UAH, Dst: "Kiosk"}
+	blocks := append(unsortedBlocks("bakery", bakery, accounts),
+		unsortedBlocks("kiosk", kiosk, accounts)...)
+
+	// Only menus of the chosen transaction are replaced
+	result := categorizedBlocks(blocks, review.ID(bakery), "Categorised as `food`")
+	require.Len(t, result, 4)
+	assert.Equal(t, blocks[0], result[0])
+	assert.IsType(t, &slack.ContextBlock{}, result[1])
+	assert.Equal(t, blocks[2], result[2])
+	assert.Equal(t, blocks[3], result[3])
+}
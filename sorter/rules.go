@@ -1,14 +1,22 @@
 package sorter
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"regexp"
+
+	"github.com/tuxofil/p24fetch/conffile"
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/txn"
 )
 
 type Rules struct {
+	// Files to include, relative to the file. Glob patterns
+	// are allowed. Accounts of the file override included ones
+	// and rules of the file are tried before included rules.
+	Include []string `json:"include,omitempty"`
 	// Mapping: ShortID -> GnuCash Account ID
 	Accounts map[string]string `json:"accounts"`
 	// Matcher rules. Transactions matching one of these
@@ -19,25 +27,190 @@ type Rules struct {
 	Rules []map[string][]string `json:"rules"`
 	// Compiled regexps cache
 	regexps map[string]*regexp.Regexp
+	// Own content of the file rules were read from, without
+	// included rules. New rules are added to it.
+	own *Rules
+	// Path to the file rules were read from
+	path string
+	// Transaction to stage writes in.
+	// When nil, files are written immediately.
+	tx *txn.Tx
+}
+
+// Rules read from a single file
+type ruleFile struct {
+	path  string
+	pos   conffile.Positions
+	rules *Rules
 }
 
-// Read rules from file and validate it.
+// Read rules from file and validate it. The file is JSON,
+// YAML or TOML depending on its extension and may include
+// other rules files. Validation problems are reported at
+// once as *conffile.Error.
 func ReadRules(path string) (*Rules, error) {
+	return readRules(path)
+}
+
+// LoadRules reads rules of the merchant: the rules overlay,
+// when configured, merged on top of the shared rules file.
+// New rules are saved to the overlay.
+func LoadRules(cfg *config.Config) (*Rules, error) {
+	if cfg.RulesOverlay == "" {
+		return readRules(cfg.RulesPath)
+	}
+	return readRules(cfg.RulesOverlay, cfg.RulesPath)
+}
+
+// Read rules files with files included by them, merge and
+// validate the result. Earlier files take precedence.
+func readRules(paths ...string) (*Rules, error) {
+	var (
+		files    []ruleFile
+		includer conffile.Includer
+	)
+	for _, path := range paths {
+		read, err := readRuleFiles(path, &includer)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, read...)
+	}
+	rules := mergeRules(files)
+	if len(files) > 0 {
+		rules.own, rules.path = files[0].rules, files[0].path
+	}
+	var merged, problems conffile.Problems
+	if len(rules.Accounts) == 0 {
+		merged.Add("accounts", "no accounts defined")
+	}
+	if len(rules.Rules) == 0 {
+		merged.Add("rules", "no rules defined")
+	}
+	if len(files) > 0 {
+		problems.Merge("", conffile.Locate(merged.Err(), files[0].path, files[0].pos, "", ""))
+	}
+	for _, f := range files {
+		problems.Merge("", conffile.Locate(f.rules.validatePatterns(rules.Accounts),
+			f.path, f.pos, "", ""))
+	}
+	if err := problems.Err(); err != nil {
+		return nil, err
+	}
+	if err := rules.CompilePatterns(); err != nil {
+		return nil, fmt.Errorf("compile regexps: %w", err)
+	}
+	return rules, nil
+}
+
+// Read the rules file and files included by it,
+// the including file first.
+func readRuleFiles(path string, includer *conffile.Includer) ([]ruleFile, error) {
+	if ok, err := includer.Enter(path); err != nil || !ok {
+		return nil, err
+	}
+	defer includer.Leave()
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("read file: %w", err)
 	}
 	rules := &Rules{}
-	if err := json.Unmarshal(data, rules); err != nil {
-		return nil, fmt.Errorf("parse JSON: %w", err)
+	pos, err := conffile.Decode(path, data, rules)
+	if err != nil {
+		return nil, err
 	}
-	if err := rules.Validate(); err != nil {
-		return nil, fmt.Errorf("validate: %w", err)
+	files := []ruleFile{{path: path, pos: pos, rules: rules}}
+	includes, err := conffile.Includes(path, pos, rules.Include)
+	if err != nil {
+		return nil, err
 	}
-	if err := rules.CompilePatterns(); err != nil {
-		return nil, fmt.Errorf("compile regexps: %w", err)
+	for _, include := range includes {
+		included, err := readRuleFiles(include, includer)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, included...)
 	}
-	return rules, nil
+	return files, nil
+}
+
+// Merge rules of the files. Accounts of earlier files
+// override later ones, ignore patterns and rules are
+// concatenated.
+func mergeRules(files []ruleFile) *Rules {
+	merged := &Rules{Accounts: map[string]string{}}
+	for _, f := range files {
+		for shortID, account := range f.rules.Accounts {
+			if _, ok := merged.Accounts[shortID]; !ok {
+				merged.Accounts[shortID] = account
+			}
+		}
+		merged.Ignore = append(merged.Ignore, f.rules.Ignore...)
+		merged.Rules = append(merged.Rules, f.rules.Rules...)
+	}
+	return merged
+}
+
+// WriteRules writes rules to the file in the format of the file.
+// Comments of YAML files are kept. Rules read from a file are
+// written without included rules.
+func WriteRules(path string, rules *Rules) error {
+	return writeRules(nil, path, rules)
+}
+
+func writeRules(tx *txn.Tx, path string, rules *Rules) error {
+	if rules.own != nil {
+		rules = rules.own
+	}
+	old, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read file: %w", err)
+	}
+	data, err := conffile.Encode(path, old, rules)
+	if err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+	if err := tx.WriteFile(path, data); err != nil {
+		return fmt.Errorf("write file: %w", err)
+	}
+	return nil
+}
+
+// Save writes rules to the file they were read from.
+// Included files are left intact.
+func (r *Rules) Save() error {
+	if r.path == "" {
+		return errors.New("rules were not read from a file")
+	}
+	return writeRules(r.tx, r.path, r)
+}
+
+// WithTx makes Save stage the write in the transaction
+// instead of writing the file immediately.
+func (r *Rules) WithTx(tx *txn.Tx) *Rules {
+	r.tx = tx
+	return r
+}
+
+// AddRule appends new rule mapping the pattern to the account.
+func (r *Rules) AddRule(shortID, pattern string) error {
+	if _, ok := r.Accounts[shortID]; !ok {
+		return fmt.Errorf("undefined account: %#v", shortID)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern %#v: %w", pattern, err)
+	}
+	rule := map[string][]string{shortID: {pattern}}
+	r.Rules = append(r.Rules, rule)
+	if r.own != nil {
+		r.own.Rules = append(r.own.Rules, rule)
+	}
+	if r.regexps == nil {
+		r.regexps = make(map[string]*regexp.Regexp)
+	}
+	r.regexps[pattern] = re
+	return nil
 }
 
 // Compile all regexp patterns.
@@ -67,45 +240,80 @@ func (r *Rules) CompilePatterns() error {
 	return nil
 }
 
-// Validate rules.
+// Validate rules. Returns *conffile.Error listing
+// all problems found.
 func (r *Rules) Validate() error {
+	var problems conffile.Problems
 	if len(r.Accounts) == 0 {
-		return errors.New("no accounts defined")
+		problems.Add("accounts", "no accounts defined")
 	}
 	if len(r.Rules) == 0 {
-		return errors.New("no rules defined")
+		problems.Add("rules", "no rules defined")
 	}
-	for _, rule := range r.Rules {
-		for name := range rule {
-			if _, ok := r.Accounts[name]; !ok {
-				return fmt.Errorf("rules: undefined account: %#v", name)
+	problems.Merge("", r.validatePatterns(r.Accounts))
+	return problems.Err()
+}
+
+// Validate patterns and account references of the
+// rules against the accounts.
+func (r *Rules) validatePatterns(accounts map[string]string) error {
+	var problems conffile.Problems
+	for i, pattern := range r.Ignore {
+		if _, err := regexp.Compile(pattern); err != nil {
+			problems.Add(conffile.Index("ignore", i), "invalid pattern %#v: %s", pattern, err)
+		}
+	}
+	for i, rule := range r.Rules {
+		for name, patterns := range rule {
+			field := conffile.Join(conffile.Index("rules", i), name)
+			if _, ok := accounts[name]; !ok {
+				problems.Add(field, "undefined account: %#v", name)
+			}
+			for j, pattern := range patterns {
+				if _, err := regexp.Compile(pattern); err != nil {
+					problems.Add(conffile.Index(field, j),
+						"invalid pattern %#v: %s", pattern, err)
+				}
 			}
 		}
 	}
-	return nil
+	return problems.Err()
 }
 
 // Ignore returns true when given string were matched to
 // one of 'ignore' rules.
 func (r *Rules) IsIgnored(s string) bool {
+	return r.MatchIgnore(s) != ""
+}
+
+// MatchIgnore returns the first 'ignore' pattern matching
+// given string or empty string when nothing matched.
+func (r *Rules) MatchIgnore(s string) string {
 	for _, pattern := range r.Ignore {
 		if r.regexps[pattern].MatchString(s) {
-			return true
+			return pattern
 		}
 	}
-	return false
+	return ""
 }
 
 // Traverse matching rules for GnuCash Account ID.
 func (r *Rules) Map(s string) string {
+	account, _ := r.Match(s)
+	return account
+}
+
+// Match traverses matching rules for GnuCash Account ID.
+// Returns the pattern matched as well.
+func (r *Rules) Match(s string) (account, pattern string) {
 	for _, rule := range r.Rules {
 		for shortID, patterns := range rule {
 			for _, pattern := range patterns {
 				if r.regexps[pattern].MatchString(s) {
-					return r.Accounts[shortID]
+					return r.Accounts[shortID], pattern
 				}
 			}
 		}
 	}
-	return ""
+	return "", ""
 }
@@ -1,10 +1,15 @@
 package sorter
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/tuxofil/p24fetch/config"
 )
 
 func TestRulesValidate(t *testing.T) {
@@ -125,3 +130,137 @@ func TestRulesMap(t *testing.T) {
 			"test #%d: %+v", n, test)
 	}
 }
+
+func TestRulesAddRule(t *testing.T) {
+	rules := Rules{
+		Accounts: map[string]string{
+			"acc1": "name1",
+			"acc2": "name2",
+		},
+		Rules: []map[string][]string{
+			map[string][]string{
+				"acc1": []string{"pat1"},
+			},
+		}}
+	require.NoError(t, rules.CompilePatterns())
+	assert.Error(t, rules.AddRule("acc3", "pat2"))
+	assert.Error(t, rules.AddRule("acc2", "pat2("))
+	require.NoError(t, rules.AddRule("acc2", "pat2"))
+	assert.Equal(t, "name2", rules.Map("pat2"))
+	assert.Equal(t, "name1", rules.Map("pat1"))
+	require.NoError(t, rules.Validate())
+}
+
+func TestReadWriteRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "rules.yaml")
+	require.NoError(t, ioutil.WriteFile(file, []byte(`accounts:
+  food: Expenses:Food
+# Shops
+rules:
+  - food: [bakery]  # daily
+`), 0600))
+
+	rules, err := ReadRules(file)
+	require.NoError(t, err)
+	require.NoError(t, rules.AddRule("food", "market"))
+	require.NoError(t, WriteRules(file, rules))
+	rules, err = ReadRules(file)
+	require.NoError(t, err)
+	assert.Equal(t, "Expenses:Food", rules.Map("market"))
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "# Shops\n")
+
+	// All problems are reported
+	require.NoError(t, ioutil.WriteFile(file, []byte(`accounts:
+  food: Expenses:Food
+ignore: ["("]
+rules:
+  - food: [bakery]
+  - fuel: [gas]
+`), 0600))
+	_, err = ReadRules(file)
+	require.Error(t, err)
+	assert.Equal(t, "2 problems:\n"+
+		"  "+file+":3: ignore[0]: invalid pattern \"(\": "+
+		"error parsing regexp: missing closing ): `(`\n"+
+		"  "+file+":6: rules[1].fuel: undefined account: \"fuel\"", err.Error())
+}
+
+func TestLoadRulesIncludeOverlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name, data string) string {
+		file := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0700))
+		require.NoError(t, ioutil.WriteFile(file, []byte(data), 0600))
+		return file
+	}
+	base := write("rules.yaml", `include: [rules.d/*.yaml]
+accounts:
+  food: Expenses:Food
+rules:
+  - food: [bakery]
+`)
+	write("rules.d/fuel.yaml", `accounts:
+  fuel: Expenses:Fuel
+ignore: [refund]
+rules:
+  - fuel: [gas]
+`)
+	write("rules.d/shops.yaml", `rules:
+  - food: [market, shop]
+`)
+	overlay := write("business.yaml", `accounts:
+  food: Expenses:Business:Food
+  office: Expenses:Office
+rules:
+  - office: [shop]
+`)
+
+	rules, err := LoadRules(&config.Config{RulesPath: base})
+	require.NoError(t, err)
+	assert.Equal(t, "Expenses:Fuel", rules.Map("gas station"))
+	assert.Equal(t, "Expenses:Food", rules.Map("shop"))
+	assert.True(t, rules.IsIgnored("refund"))
+
+	cfg := &config.Config{RulesPath: base, RulesOverlay: overlay}
+	rules, err = LoadRules(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "Expenses:Office", rules.Map("shop"))
+	assert.Equal(t, "Expenses:Business:Food", rules.Map("bakery"))
+	assert.Equal(t, "Expenses:Fuel", rules.Map("gas"))
+
+	// New rules are saved to the overlay only
+	require.NoError(t, rules.AddRule("fuel", "petrol"))
+	require.NoError(t, rules.Save())
+	data, err := ioutil.ReadFile(overlay)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "petrol")
+	assert.NotContains(t, string(data), "bakery")
+	rules, err = LoadRules(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "Expenses:Fuel", rules.Map("petrol"))
+	rules, err = ReadRules(base)
+	require.NoError(t, err)
+	assert.Equal(t, "", rules.Map("petrol"))
+
+	// Problems are located in included files
+	bad := write("rules.d/bad.yaml", `rules:
+  - taxi: [uber]
+`)
+	_, err = ReadRules(base)
+	require.Error(t, err)
+	assert.Equal(t, bad+":2: rules[0].taxi: undefined account: \"taxi\"", err.Error())
+	require.NoError(t, os.Remove(bad))
+
+	write("rules.d/loop.yaml", `include: [../rules.yaml]
+`)
+	_, err = ReadRules(base)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "include cycle")
+}
@@ -14,10 +14,14 @@ type Sorter struct {
 	rules *Rules
 }
 
+// Prefix of the merchant category code matched against
+// patterns, e.g. "^mcc:5411$" matches grocery stores.
+const MCCPrefix = "mcc:"
+
 // Create new Sorter instance
 func New(cfg *config.Config) (*Sorter, error) {
 	s := &Sorter{config: *cfg}
-	rules, err := ReadRules(cfg.RulesPath)
+	rules, err := LoadRules(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("create rules: %w", err)
 	}
@@ -25,54 +29,95 @@ func New(cfg *config.Config) (*Sorter, error) {
 	return s, nil
 }
 
+// Accounts returns mapping of account short IDs
+// to GnuCash Account IDs.
+func (s *Sorter) Accounts() map[string]string {
+	return s.rules.Accounts
+}
+
+// Outcome of the transaction sorting
+type Outcome string
+
+// Valid outcomes
+const (
+	Ignored  Outcome = "ignored"
+	Sorted   Outcome = "sorted"
+	Unsorted Outcome = "unsorted"
+)
+
+// Result of the transaction classification
+type Result struct {
+	Outcome Outcome
+	// GnuCash Account ID. Set for sorted transactions only.
+	Account string
+	// Pattern matched. Set for sorted and ignored transactions.
+	Rule string
+	// Why the transaction is invalid. Invalid transactions
+	// are always unsorted.
+	Reason string
+}
+
+// Classify the transaction according to rules.
+func (s *Sorter) Classify(tran schema.Transaction) Result {
+	// Check transaction
+	if tran.Raw != nil || tran.Error != "" {
+		return Result{Outcome: Unsorted, Reason: tran.Error}
+	} else if tran.SrcVal >= 0 {
+		return Result{Outcome: Unsorted, Reason: "deposits are not implemented"}
+	} else if tran.SrcCur != tran.DstCur {
+		return Result{Outcome: Unsorted, Reason: "currencies differ"}
+	}
+
+	fields := []string{tran.Dst, tran.Note}
+	// Counterparty details are set by some sources only
+	for _, field := range []string{tran.DstCode, tran.DstAccount} {
+		if field != "" {
+			fields = append(fields, field)
+		}
+	}
+	if tran.MCC != "" {
+		fields = append(fields, MCCPrefix+tran.MCC)
+	}
+	for _, field := range fields {
+		if pattern := s.rules.MatchIgnore(field); pattern != "" {
+			return Result{Outcome: Ignored, Rule: pattern}
+		}
+	}
+
+	// Map transaction
+	for _, field := range fields {
+		if account, pattern := s.rules.Match(field); account != "" {
+			return Result{Outcome: Sorted, Account: account, Rule: pattern}
+		}
+	}
+	return Result{Outcome: Unsorted}
+}
+
 // Sort transactions according to rules.
 func (s *Sorter) Sort(trans []schema.Transaction) (
 	ignore []schema.Transaction,
 	mapped []schema.Transaction,
 	unmapped []schema.Transaction,
 ) {
-	var (
-		good []schema.Transaction
-		bad  []schema.Transaction
-	)
 	for _, tran := range trans {
-		// Check transaction
-		if tran.Raw != nil {
-			bad = append(bad, tran)
-			continue
-		} else if tran.Error != "" {
-			bad = append(bad, tran)
-			continue
-		} else if tran.SrcVal >= 0 {
-			tran.Error = "deposits are not implemented"
-			bad = append(bad, tran)
-			continue
-		} else if tran.SrcCur != tran.DstCur {
-			tran.Error = "currencies differ"
-			bad = append(bad, tran)
-			continue
-		}
-
-		if s.rules.IsIgnored(tran.Dst) || s.rules.IsIgnored(tran.Note) {
+		res := s.Classify(tran)
+		switch res.Outcome {
+		case Ignored:
 			ignore = append(ignore, tran)
-			continue
-		}
-
-		// Map transaction
-		var dstAcc string
-		if n := s.rules.Map(tran.Dst); n != "" {
-			dstAcc = n
-		} else if n := s.rules.Map(tran.Note); n != "" {
-			dstAcc = n
-		} else {
-			bad = append(bad, tran)
-			continue
+		case Sorted:
+			mapped = append(mapped, Assign(tran, res.Account))
+		default:
+			tran.Error = res.Reason
+			unmapped = append(unmapped, tran)
 		}
-
-		// Convert transaction
-		tran.Note = fmt.Sprintf("%s: %s", tran.Dst, tran.Note)
-		tran.Dst = dstAcc
-		good = append(good, tran)
 	}
-	return ignore, good, bad
+	return ignore, mapped, unmapped
+}
+
+// Assign converts the transaction to be exported to the account.
+// The origin beneficiary name is kept in the transaction note.
+func Assign(tran schema.Transaction, account string) schema.Transaction {
+	tran.Note = fmt.Sprintf("%s: %s", tran.Dst, tran.Note)
+	tran.Dst = account
+	return tran
 }
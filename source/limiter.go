@@ -0,0 +1,64 @@
+package source
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// APIs limit request rate per client, so all requests made
+// for the same source identity are serialised and separated
+// by the minimal interval of the source.
+var apiLimiter = newLimiter()
+
+type limiter struct {
+	// Guards the slots map
+	mu sync.Mutex
+	// Per key state
+	slots map[string]*slot
+}
+
+type slot struct {
+	// Semaphore. Holds a value while the slot is acquired.
+	sem chan struct{}
+	// Time of the last release. Accessed only by the
+	// goroutine holding the semaphore.
+	last time.Time
+}
+
+func newLimiter() *limiter {
+	return &limiter{slots: map[string]*slot{}}
+}
+
+// Acquire waits until the call with given key is allowed, that is
+// the interval passed since the previous call with the key finished.
+// The returned function must be called when the call is finished.
+func (l *limiter) Acquire(ctx context.Context, key string, interval time.Duration) (func(), error) {
+	l.mu.Lock()
+	s, ok := l.slots[key]
+	if !ok {
+		s = &slot{sem: make(chan struct{}, 1)}
+		l.slots[key] = s
+	}
+	l.mu.Unlock()
+
+	select {
+	case s.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if wait := time.Until(s.last.Add(interval)); !s.last.IsZero() && wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			<-s.sem
+			return nil, ctx.Err()
+		}
+	}
+	return func() {
+		s.last = time.Now()
+		<-s.sem
+	}, nil
+}
@@ -0,0 +1,81 @@
+// Package source defines the interface of bank APIs
+// transactions are fetched from.
+//
+// Transactions of all sources are returned in the form of the
+// Privat24 merchant API, so the rest of the pipeline (deduplication,
+// sorting, exporting and archiving) does not depend on the source.
+package source
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/tuxofil/p24fetch/schema"
+)
+
+// Source of transactions of a single account
+type Source interface {
+	// Identity of the API client. Requests of sources
+	// with the same identity share the rate limit.
+	Identity() string
+	// Limits of requests to the API
+	Limits() Limits
+	// Fetch transactions made in the period, the oldest first.
+	// The period is never longer than Limits().MaxPeriod.
+	Fetch(ctx context.Context, from, to time.Time) ([]schema.XMLTransaction, error)
+}
+
+// Limits of requests to the API
+type Limits struct {
+	// Minimal interval between requests with the same identity
+	Interval time.Duration
+	// Longest period fetched with one request. Zero for no limit.
+	MaxPeriod time.Duration
+}
+
+// FetchLog fetches transactions of the last days, the oldest
+// first. Longer periods than the source allows are fetched
+// with several requests, waiting as the rate limit requires.
+func FetchLog(ctx context.Context, src Source, days int) ([]schema.XMLTransaction, error) {
+	limits := src.Limits()
+	to := time.Now()
+	from := to.AddDate(0, 0, -days)
+	var res []schema.XMLTransaction
+	for start := from; start.Before(to); {
+		end := to
+		if limits.MaxPeriod > 0 && end.Sub(start) > limits.MaxPeriod {
+			end = start.Add(limits.MaxPeriod)
+		}
+		trans, err := fetch(ctx, src, limits, start, end)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, trans...)
+		start = end
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].TranDate+res[i].TranTime < res[j].TranDate+res[j].TranTime
+	})
+	return res, nil
+}
+
+// Fetch the period holding the rate limiter.
+func fetch(ctx context.Context, src Source, limits Limits, from, to time.Time) ([]schema.XMLTransaction, error) {
+	release, err := apiLimiter.Acquire(ctx, src.Identity(), limits.Interval)
+	if err != nil {
+		return nil, fmt.Errorf("wait for rate limiter: %w", err)
+	}
+	defer release()
+	return src.Fetch(ctx, from, to)
+}
+
+// SecretKey returns an identity derived from the secret
+// (e.g. an API token) without disclosing it.
+func SecretKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:8])
+}
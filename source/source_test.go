@@ -0,0 +1,96 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tuxofil/p24fetch/schema"
+)
+
+func TestLimiter(t *testing.T) {
+	const interval = 50 * time.Millisecond
+	l := newLimiter()
+	ctx := context.Background()
+
+	// Calls with the same key are separated by the interval
+	release, err := l.Acquire(ctx, "a", interval)
+	require.NoError(t, err)
+	released := time.Now()
+	release()
+	release, err = l.Acquire(ctx, "a", interval)
+	require.NoError(t, err)
+	assert.True(t, time.Since(released) >= interval)
+
+	// Calls with other keys are not blocked
+	started := time.Now()
+	release2, err := l.Acquire(ctx, "b", interval)
+	require.NoError(t, err)
+	assert.True(t, time.Since(started) < interval)
+	release2()
+
+	// Waiting is interrupted by the context
+	cancelCtx, cancel := context.WithTimeout(ctx, interval/5)
+	defer cancel()
+	_, err = l.Acquire(cancelCtx, "a", interval)
+	assert.Error(t, err)
+	release()
+}
+
+type fakeSource struct {
+	limits  Limits
+	periods [][2]time.Time
+	err     error
+}
+
+func (s *fakeSource) Identity() string { return "fake" }
+
+func (s *fakeSource) Limits() Limits { return s.limits }
+
+func (s *fakeSource) Fetch(ctx context.Context, from, to time.Time) ([]schema.XMLTransaction, error) {
+	s.periods = append(s.periods, [2]time.Time{from, to})
+	if s.err != nil {
+		return nil, s.err
+	}
+	return []schema.XMLTransaction{{
+		TranDate: from.Format("2006-01-02"),
+		TranTime: from.Format("15:04:05"),
+	}}, nil
+}
+
+func TestFetchLog(t *testing.T) {
+	// The period is split according to the limits
+	src := &fakeSource{limits: Limits{MaxPeriod: 10 * 24 * time.Hour}}
+	trans, err := FetchLog(context.Background(), src, 25)
+	require.NoError(t, err)
+	require.Len(t, src.periods, 3)
+	assert.Len(t, trans, 3)
+	for i, period := range src.periods {
+		assert.True(t, period[1].Sub(period[0]) <= src.limits.MaxPeriod)
+		if i > 0 {
+			assert.Equal(t, src.periods[i-1][1], period[0])
+		}
+	}
+	assert.True(t, trans[0].TranDate < trans[2].TranDate)
+
+	// Single request without the limit
+	src = &fakeSource{}
+	_, err = FetchLog(context.Background(), src, 90)
+	require.NoError(t, err)
+	assert.Len(t, src.periods, 1)
+
+	// Errors are returned
+	src = &fakeSource{err: errors.New("failed")}
+	_, err = FetchLog(context.Background(), src, 1)
+	assert.EqualError(t, err, "failed")
+}
+
+func TestSecretKey(t *testing.T) {
+	assert.Len(t, SecretKey("token"), 16)
+	assert.Equal(t, SecretKey("token"), SecretKey("token"))
+	assert.NotEqual(t, SecretKey("token"), SecretKey("other"))
+}
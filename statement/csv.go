@@ -0,0 +1,59 @@
+package statement
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"unicode/utf8"
+)
+
+// Read rows of the CSV file. Fields are separated by
+// semicolons or commas, whichever is found more often in the
+// first lines. Files not in UTF-8 are read as Windows-1251.
+func readCSV(path string) ([][]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		data = decodeWindows1251(data)
+	}
+	head := data
+	if len(head) > 4096 {
+		head = head[:4096]
+	}
+	r := csv.NewReader(bytes.NewReader(data))
+	if bytes.Count(head, []byte(";")) > bytes.Count(head, []byte(",")) {
+		r.Comma = ';'
+	}
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parse csv: %w", err)
+	}
+	return rows, nil
+}
+
+// Characters of Windows-1251 from 0x80 to 0xBF.
+// Characters from 0xC0 are Cyrillic letters А-я.
+var windows1251 = []rune("ЂЃ‚ѓ„…†‡€‰Љ‹ЊЌЋЏђ‘’“”•–—\ufffd™љ›њќћџ" +
+	"\u00a0ЎўЈ¤Ґ¦§Ё©Є«¬\u00ad®Ї°±Ііґµ¶·ё№є»јЅѕї")
+
+func decodeWindows1251(data []byte) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(data) * 2)
+	for _, b := range data {
+		switch {
+		case b < 0x80:
+			buf.WriteByte(b)
+		case b < 0xc0:
+			buf.WriteRune(windows1251[b-0x80])
+		default:
+			buf.WriteRune(rune(b-0xc0) + 'А')
+		}
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,431 @@
+// Package statement reads card statements downloaded
+// from Privat24 web as XLSX or CSV files.
+//
+// Transactions are converted to the form returned by the Privat24
+// merchant API, so they are deduplicated, sorted, exported and
+// archived the same way as fetched transactions.
+package statement
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tuxofil/p24fetch/schema"
+	"github.com/tuxofil/p24fetch/source"
+)
+
+// Statement read from one or more files
+type Statement struct {
+	// Files the statement is read from
+	Paths []string
+	// Transactions, the oldest first. Card is set
+	// as given in the file, usually masked.
+	Transactions []schema.XMLTransaction
+}
+
+// Columns of the statement
+const (
+	colDate = iota
+	colTime
+	colCategory
+	colCard
+	colDescription
+	colCardAmount
+	colCardCurrency
+	colAmount
+	colCurrency
+	colRest
+	colRestCurrency
+	colCount
+)
+
+// Column headers of statements in Ukrainian and English
+var headers = map[string]int{
+	"дата":                    colDate,
+	"date":                    colDate,
+	"час":                     colTime,
+	"time":                    colTime,
+	"категорія":               colCategory,
+	"category":                colCategory,
+	"картка":                  colCard,
+	"card":                    colCard,
+	"опис операції":           colDescription,
+	"description":             colDescription,
+	"сума в валюті картки":    colCardAmount,
+	"card amount":             colCardAmount,
+	"amount in card currency": colCardAmount,
+	"валюта картки":           colCardCurrency,
+	"card currency":           colCardCurrency,
+	"сума в валюті транзакції":       colAmount,
+	"transaction amount":             colAmount,
+	"amount in transaction currency": colAmount,
+	"валюта транзакції":              colCurrency,
+	"transaction currency":           colCurrency,
+	"залишок на кінець періоду":      colRest,
+	"balance":          colRest,
+	"валюта залишку":   colRestCurrency,
+	"balance currency": colRestCurrency,
+}
+
+// Columns every statement must have
+var requiredColumns = []int{colDate, colDescription, colCardAmount, colCardCurrency}
+
+// Read the statement file. The format is chosen
+// by the file extension: .xlsx or .csv.
+func Read(path string) (*Statement, error) {
+	var (
+		rows [][]string
+		err  error
+	)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".xlsx":
+		rows, err = readXLSX(path)
+	case ".csv":
+		rows, err = readCSV(path)
+	default:
+		return nil, fmt.Errorf("%s: unsupported statement format", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	trans, err := parseRows(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &Statement{Paths: []string{path}, Transactions: trans}, nil
+}
+
+// ReadAll reads statement files and merges them.
+func ReadAll(paths []string) (*Statement, error) {
+	res := &Statement{}
+	for _, path := range paths {
+		s, err := Read(path)
+		if err != nil {
+			return nil, err
+		}
+		res.Merge(s)
+	}
+	return res, nil
+}
+
+// Merge adds transactions of the other statement. Statements
+// downloaded for overlapping periods share transactions, so
+// a transaction is added only as many times as the other statement
+// has it more than this one.
+func (s *Statement) Merge(other *Statement) {
+	count := map[schema.XMLTransaction]int{}
+	for _, tran := range s.Transactions {
+		count[tran]++
+	}
+	for _, tran := range other.Transactions {
+		if count[tran] > 0 {
+			count[tran]--
+			continue
+		}
+		s.Transactions = append(s.Transactions, tran)
+	}
+	s.Paths = append(s.Paths, other.Paths...)
+	sortTransactions(s.Transactions)
+}
+
+// Cards returns last four digits of card numbers of transactions
+// mapped to the number of transactions. Transactions without
+// a card number are counted with the empty key.
+func (s *Statement) Cards() map[string]int {
+	res := map[string]int{}
+	for _, tran := range s.Transactions {
+		res[Last4(tran.Card)]++
+	}
+	return res
+}
+
+// Last4 returns the last four digits of the card number,
+// which may be masked (e.g. 5168 **** **** 1234).
+func Last4(card string) string {
+	var digits []rune
+	for _, r := range card {
+		if r >= '0' && r <= '9' {
+			digits = append(digits, r)
+		}
+	}
+	if len(digits) < 4 {
+		return ""
+	}
+	return string(digits[len(digits)-4:])
+}
+
+// Source of transactions of the card read from the statement
+type Source struct {
+	// Statement files
+	paths []string
+	// Transactions of the card, the oldest first
+	trans []schema.XMLTransaction
+}
+
+// Source returns transactions of the card with the last four
+// digits as a source. Card numbers are replaced with the card.
+// Transactions without a card number are included when all is set.
+func (s *Statement) Source(last4, card string, all bool) *Source {
+	res := &Source{paths: s.Paths}
+	for _, tran := range s.Transactions {
+		if tranLast4 := Last4(tran.Card); tranLast4 == last4 || (all && tranLast4 == "") {
+			tran.Card = card
+			res.trans = append(res.trans, tran)
+		}
+	}
+	return res
+}
+
+// Len returns the number of transactions of the source.
+func (s *Source) Len() int {
+	return len(s.trans)
+}
+
+// Days returns the number of days since the oldest transaction,
+// so the source is fully fetched with source.FetchLog.
+func (s *Source) Days() int {
+	if len(s.trans) == 0 {
+		return 1
+	}
+	oldest, err := time.Parse("2006-01-02", s.trans[0].TranDate)
+	if err != nil {
+		return 1
+	}
+	return int(time.Since(oldest).Hours()/24) + 2
+}
+
+// Identity of the statement files. Not rate limited.
+func (s *Source) Identity() string {
+	return "statement:" + strings.Join(s.paths, ",")
+}
+
+// Limits of the statement files. Not limited.
+func (s *Source) Limits() source.Limits {
+	return source.Limits{}
+}
+
+// Fetch transactions made in the period, the oldest first.
+// Only dates are compared, so the whole days of the period are included.
+func (s *Source) Fetch(ctx context.Context, from, to time.Time) ([]schema.XMLTransaction, error) {
+	fromDate, toDate := from.Format("2006-01-02"), to.Format("2006-01-02")
+	var res []schema.XMLTransaction
+	for _, tran := range s.trans {
+		if tran.TranDate >= fromDate && tran.TranDate <= toDate {
+			res = append(res, tran)
+		}
+	}
+	return res, nil
+}
+
+// Parse rows of the statement. Rows before the header
+// (e.g. the title of the statement) and rows with no date
+// after the transactions (e.g. totals) are skipped.
+func parseRows(rows [][]string) ([]schema.XMLTransaction, error) {
+	header := -1
+	var columns []int
+	for i, row := range rows {
+		if columns = parseHeader(row); columns != nil {
+			header = i
+			break
+		}
+	}
+	if header < 0 {
+		return nil, fmt.Errorf("no statement header found")
+	}
+	var res []schema.XMLTransaction
+	for i, row := range rows[header+1:] {
+		cells := make([]string, colCount)
+		for col, idx := range columns {
+			if idx >= 0 && idx < len(row) {
+				cells[col] = strings.TrimSpace(row[idx])
+			}
+		}
+		if cells[colDate] == "" {
+			continue
+		}
+		tran, err := parseRow(cells)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", header+i+2, err)
+		}
+		res = append(res, tran)
+	}
+	sortTransactions(res)
+	return res, nil
+}
+
+// Parse the header row. Returns indexes of columns
+// in the row, or nil if the row is not a header.
+func parseHeader(row []string) []int {
+	columns := make([]int, colCount)
+	for i := range columns {
+		columns[i] = -1
+	}
+	for i, cell := range row {
+		name := strings.ToLower(strings.Join(strings.Fields(cell), " "))
+		if col, ok := headers[name]; ok && columns[col] < 0 {
+			columns[col] = i
+		}
+	}
+	for _, col := range requiredColumns {
+		if columns[col] < 0 {
+			return nil
+		}
+	}
+	return columns
+}
+
+// Parse cells of the transaction row.
+func parseRow(cells []string) (schema.XMLTransaction, error) {
+	date, err := parseDate(cells[colDate], cells[colTime])
+	if err != nil {
+		return schema.XMLTransaction{}, err
+	}
+	cardAmount, err := parseNumber(cells[colCardAmount])
+	if err != nil {
+		return schema.XMLTransaction{}, fmt.Errorf("invalid card amount: %w", err)
+	}
+	amount, currency := cardAmount, cells[colCardCurrency]
+	if cells[colAmount] != "" {
+		if amount, err = parseNumber(cells[colAmount]); err != nil {
+			return schema.XMLTransaction{}, fmt.Errorf("invalid amount: %w", err)
+		}
+	}
+	if cells[colCurrency] != "" {
+		currency = cells[colCurrency]
+	}
+	var rest string
+	if cells[colRest] != "" {
+		value, err := parseNumber(cells[colRest])
+		if err != nil {
+			return schema.XMLTransaction{}, fmt.Errorf("invalid balance: %w", err)
+		}
+		restCurrency := cells[colRestCurrency]
+		if restCurrency == "" {
+			restCurrency = cells[colCardCurrency]
+		}
+		rest = formatAmount(value, restCurrency)
+	}
+	return schema.XMLTransaction{
+		Card:        cells[colCard],
+		TranDate:    date.Format("2006-01-02"),
+		TranTime:    date.Format("15:04:05"),
+		Amount:      formatAmount(math.Abs(amount), currency),
+		CardAmount:  formatAmount(cardAmount, cells[colCardCurrency]),
+		Rest:        rest,
+		Terminal:    cells[colDescription],
+		Description: cells[colCategory],
+	}, nil
+}
+
+// Date formats of statements
+var dateFormats = []string{
+	"02.01.2006 15:04:05",
+	"02.01.2006 15:04",
+	"02.01.2006",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// Time formats of the time column
+var timeFormats = []string{
+	"15:04:05",
+	"15:04",
+}
+
+// Parse date and time of the transaction. The time is given either
+// with the date or in the time column. Dates and times of XLSX files
+// may be Excel serial numbers. Transactions with no time are refused,
+// as the deduplicator would drop transactions of the same day.
+func parseDate(date, tm string) (time.Time, error) {
+	day, hasTime, err := parseDay(date)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if tm == "" {
+		if !hasTime {
+			return time.Time{}, fmt.Errorf("no transaction time: %#v", date)
+		}
+		return day, nil
+	}
+	clock, err := parseTime(tm)
+	if err != nil {
+		return time.Time{}, err
+	}
+	year, month, mday := day.Date()
+	return time.Date(year, month, mday, 0, 0, 0, 0, time.UTC).Add(clock), nil
+}
+
+// Parse the date cell. Reports whether the cell has the time as well.
+// Excel serial numbers with no fractional part are dates only.
+func parseDay(date string) (time.Time, bool, error) {
+	if serial, err := strconv.ParseFloat(date, 64); err == nil {
+		return excelTime(serial), serial != math.Trunc(serial), nil
+	}
+	for _, format := range dateFormats {
+		if t, err := time.Parse(format, date); err == nil {
+			return t, strings.Contains(format, "15"), nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("invalid date: %#v", date)
+}
+
+// Parse the time cell to the time since midnight.
+func parseTime(tm string) (time.Duration, error) {
+	if serial, err := strconv.ParseFloat(tm, 64); err == nil {
+		return sinceMidnight(excelTime(serial - math.Trunc(serial))), nil
+	}
+	for _, format := range timeFormats {
+		if t, err := time.Parse(format, tm); err == nil {
+			return sinceMidnight(t), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid time: %#v", tm)
+}
+
+func sinceMidnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+}
+
+// Convert Excel serial date number to time.
+func excelTime(serial float64) time.Time {
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	return epoch.Add(time.Duration(math.Round(serial*86400)) * time.Second)
+}
+
+// Parse a number with optional thousands separators
+// and a decimal comma, e.g. "-1 234,56".
+func parseNumber(s string) (float64, error) {
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\u00a0', '\u202f':
+			return -1
+		case ',':
+			return '.'
+		}
+		return r
+	}, s)
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("not a number: %#v", s)
+	}
+	return value, nil
+}
+
+func formatAmount(value float64, currency string) string {
+	return strconv.FormatFloat(value, 'f', 2, 64) + " " + strings.ToUpper(currency)
+}
+
+// Sort transactions from the oldest one.
+func sortTransactions(trans []schema.XMLTransaction) {
+	sort.SliceStable(trans, func(i, j int) bool {
+		return trans[i].TranDate+trans[i].TranTime < trans[j].TranDate+trans[j].TranTime
+	})
+}
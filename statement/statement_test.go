@@ -0,0 +1,209 @@
+package statement
+
+import (
+	"archive/zip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tuxofil/p24fetch/schema"
+)
+
+const csvStatement = `Виписка з 01.03.2021 по 03.03.2021
+Дата;Категорія;Картка;Опис операції;Сума в валюті картки;Валюта картки;Сума в валюті транзакції;Валюта транзакції;Залишок на кінець періоду;Валюта залишку
+03.03.2021 18:05:00;Супермаркети;5168 **** **** 1234;Сільпо;-1 234,50;UAH;-1 234,50;UAH;8 765,50;UAH
+01.03.2021 09:30:00;Подорожі;5168 **** **** 1234;Booking.com;-280,00;UAH;-10,00;USD;10 000,00;UAH
+;;;;Всього;;;;;
+`
+
+func TestReadCSV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statement")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// UTF-8 and Windows-1251 files are read the same way
+	utf8Path := filepath.Join(dir, "utf8.csv")
+	require.NoError(t, ioutil.WriteFile(utf8Path, []byte("\xef\xbb\xbf"+csvStatement), 0600))
+	cp1251Path := filepath.Join(dir, "cp1251.csv")
+	require.NoError(t, ioutil.WriteFile(cp1251Path, encodeWindows1251(t, csvStatement), 0600))
+
+	for _, path := range []string{utf8Path, cp1251Path} {
+		s, err := Read(path)
+		require.NoError(t, err)
+		assert.Equal(t, []schema.XMLTransaction{
+			{
+				Card:        "5168 **** **** 1234",
+				TranDate:    "2021-03-01",
+				TranTime:    "09:30:00",
+				Amount:      "10.00 USD",
+				CardAmount:  "-280.00 UAH",
+				Rest:        "10000.00 UAH",
+				Terminal:    "Booking.com",
+				Description: "Подорожі",
+			},
+			{
+				Card:        "5168 **** **** 1234",
+				TranDate:    "2021-03-03",
+				TranTime:    "18:05:00",
+				Amount:      "1234.50 UAH",
+				CardAmount:  "-1234.50 UAH",
+				Rest:        "8765.50 UAH",
+				Terminal:    "Сільпо",
+				Description: "Супермаркети",
+			},
+		}, s.Transactions, path)
+	}
+
+	// Errors are reported with the row number
+	badPath := filepath.Join(dir, "bad.csv")
+	require.NoError(t, ioutil.WriteFile(badPath, []byte(
+		"Date,Description,Card amount,Card currency\n01.03.2021 10:00,Shop,abc,UAH\n"), 0600))
+	_, err = Read(badPath)
+	assert.EqualError(t, err, badPath+`: row 2: invalid card amount: not a number: "abc"`)
+
+	_, err = Read(filepath.Join(dir, "statement.pdf"))
+	assert.Error(t, err)
+}
+
+func TestReadXLSX(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statement")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "statement.xlsx")
+	writeZip(t, path, map[string]string{
+		"xl/sharedStrings.xml": `<sst><si><t>Date</t></si><si><t>Description</t></si>` +
+			`<si><t>Card amount</t></si><si><t>Card currency</t></si>` +
+			`<si><r><t>Coffee </t></r><r><t>shop</t></r></si><si><t>UAH</t></si></sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData>` +
+			`<row r="1"><c r="A1" t="inlineStr"><is><t>Statement</t></is></c></row>` +
+			`<row r="2"><c r="A2" t="s"><v>0</v></c><c r="B2" t="s"><v>1</v></c>` +
+			`<c r="C2" t="s"><v>2</v></c><c r="D2" t="s"><v>3</v></c></row>` +
+			`<row r="3"><c r="A3"><v>44256.5</v></c><c r="B3" t="s"><v>4</v></c>` +
+			`<c r="C3"><v>-55.5</v></c><c r="D3" t="s"><v>5</v></c></row>` +
+			`</sheetData></worksheet>`,
+	})
+	s, err := Read(path)
+	require.NoError(t, err)
+	assert.Equal(t, []schema.XMLTransaction{{
+		TranDate:   "2021-03-01",
+		TranTime:   "12:00:00",
+		Amount:     "55.50 UAH",
+		CardAmount: "-55.50 UAH",
+		Terminal:   "Coffee shop",
+	}}, s.Transactions)
+}
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		date, tm string
+		expected string
+	}{
+		{"03.03.2021 18:05:00", "", "2021-03-03 18:05:00"},
+		{"03.03.2021", "18:05", "2021-03-03 18:05:00"},
+		{"2021-03-03", "18:05:00", "2021-03-03 18:05:00"},
+		// Excel serial numbers
+		{"44256.5", "", "2021-03-01 12:00:00"},
+		{"44256", "0.75", "2021-03-01 18:00:00"},
+		{"44256", "18:00", "2021-03-01 18:00:00"},
+		{"44256.5", "0.75", "2021-03-01 18:00:00"},
+	}
+	for _, test := range tests {
+		date, err := parseDate(test.date, test.tm)
+		require.NoError(t, err, test.date)
+		assert.Equal(t, test.expected, date.Format("2006-01-02 15:04:05"), test.date)
+	}
+
+	// Transactions with no time are refused
+	_, err := parseDate("03.03.2021", "")
+	assert.EqualError(t, err, `no transaction time: "03.03.2021"`)
+	_, err = parseDate("44256", "")
+	assert.EqualError(t, err, `no transaction time: "44256"`)
+	_, err = parseDate("03.03.2021", "noon")
+	assert.EqualError(t, err, `invalid time: "noon"`)
+}
+
+func TestMerge(t *testing.T) {
+	a := schema.XMLTransaction{TranDate: "2021-03-01", Terminal: "a"}
+	b := schema.XMLTransaction{TranDate: "2021-03-02", Terminal: "b"}
+	c := schema.XMLTransaction{TranDate: "2021-03-03", Terminal: "c"}
+	s := &Statement{Paths: []string{"1.csv"}, Transactions: []schema.XMLTransaction{a, b}}
+	// Shared transactions are added as many times
+	// as the other statement has them more
+	s.Merge(&Statement{Paths: []string{"2.csv"}, Transactions: []schema.XMLTransaction{b, b, c}})
+	assert.Equal(t, []schema.XMLTransaction{a, b, b, c}, s.Transactions)
+	assert.Equal(t, []string{"1.csv", "2.csv"}, s.Paths)
+}
+
+func TestSource(t *testing.T) {
+	s := &Statement{Transactions: []schema.XMLTransaction{
+		{Card: "5168 **** **** 1234", TranDate: "2021-03-01", Terminal: "a"},
+		{Card: "", TranDate: "2021-03-02", Terminal: "b"},
+		{Card: "4149 **** **** 5678", TranDate: "2021-03-03", Terminal: "c"},
+	}}
+	assert.Equal(t, map[string]int{"1234": 1, "": 1, "5678": 1}, s.Cards())
+
+	src := s.Source("1234", "5168000000001234", false)
+	assert.Equal(t, 1, src.Len())
+	src = s.Source("1234", "5168000000001234", true)
+	require.Equal(t, 2, src.Len())
+	assert.True(t, src.Days() > 2)
+
+	from := time.Date(2021, 3, 2, 12, 0, 0, 0, time.UTC)
+	trans, err := src.Fetch(context.Background(), from, from.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	require.Len(t, trans, 1)
+	assert.Equal(t, "b", trans[0].Terminal)
+	assert.Equal(t, "5168000000001234", trans[0].Card)
+}
+
+func TestLast4(t *testing.T) {
+	assert.Equal(t, "1234", Last4("5168 **** **** 1234"))
+	assert.Equal(t, "1234", Last4("5168000000001234"))
+	assert.Equal(t, "", Last4("*123"))
+}
+
+func encodeWindows1251(t *testing.T, s string) []byte {
+	var res []byte
+	for _, r := range s {
+		switch {
+		case r < 0x80:
+			res = append(res, byte(r))
+		case r >= 'А' && r <= 'я':
+			res = append(res, byte(r-'А'+0xc0))
+		default:
+			i := indexRune(windows1251, r)
+			require.True(t, i >= 0, "no Windows-1251 character for %q", r)
+			res = append(res, byte(0x80+i))
+		}
+	}
+	return res
+}
+
+func indexRune(runes []rune, r rune) int {
+	for i, x := range runes {
+		if x == r {
+			return i
+		}
+	}
+	return -1
+}
+
+func writeZip(t *testing.T, path string, files map[string]string) {
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+}
@@ -0,0 +1,138 @@
+package statement
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Shared strings part of XLSX workbook
+type xlsxSharedStrings struct {
+	Items []xlsxString `xml:"si"`
+}
+
+// Rich or plain text
+type xlsxString struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (s xlsxString) String() string {
+	if len(s.Runs) == 0 {
+		return s.Text
+	}
+	var b strings.Builder
+	for _, r := range s.Runs {
+		b.WriteString(r.Text)
+	}
+	return b.String()
+}
+
+// Worksheet part of XLSX workbook
+type xlsxSheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref    string     `xml:"r,attr"`
+			Type   string     `xml:"t,attr"`
+			Value  string     `xml:"v"`
+			Inline xlsxString `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// Read cells of the first worksheet of the XLSX file.
+func readXLSX(path string) ([][]string, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("open xlsx: %w", err)
+	}
+	defer zr.Close()
+	parts := map[string]*zip.File{}
+	var sheets []string
+	for _, f := range zr.File {
+		parts[f.Name] = f
+		if strings.HasPrefix(f.Name, "xl/worksheets/sheet") && strings.HasSuffix(f.Name, ".xml") {
+			sheets = append(sheets, f.Name)
+		}
+	}
+	if len(sheets) == 0 {
+		return nil, fmt.Errorf("no worksheets found")
+	}
+	// The first sheet is sheet1.xml, sorted by number
+	sort.Slice(sheets, func(i, j int) bool {
+		if len(sheets[i]) != len(sheets[j]) {
+			return len(sheets[i]) < len(sheets[j])
+		}
+		return sheets[i] < sheets[j]
+	})
+	var shared xlsxSharedStrings
+	if f, ok := parts["xl/sharedStrings.xml"]; ok {
+		if err := decodePart(f, &shared); err != nil {
+			return nil, fmt.Errorf("read shared strings: %w", err)
+		}
+	}
+	var sheet xlsxSheet
+	if err := decodePart(parts[sheets[0]], &sheet); err != nil {
+		return nil, fmt.Errorf("read worksheet: %w", err)
+	}
+	rows := make([][]string, 0, len(sheet.Rows))
+	for _, r := range sheet.Rows {
+		var row []string
+		for _, c := range r.Cells {
+			col := len(row)
+			if c.Ref != "" {
+				if col, err = columnIndex(c.Ref); err != nil {
+					return nil, err
+				}
+			}
+			for len(row) <= col {
+				row = append(row, "")
+			}
+			switch c.Type {
+			case "s":
+				idx, err := strconv.Atoi(c.Value)
+				if err != nil || idx < 0 || idx >= len(shared.Items) {
+					return nil, fmt.Errorf("cell %s: invalid shared string: %#v", c.Ref, c.Value)
+				}
+				row[col] = shared.Items[idx].String()
+			case "inlineStr":
+				row[col] = c.Inline.String()
+			default:
+				row[col] = c.Value
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func decodePart(f *zip.File, v interface{}) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return xml.NewDecoder(r).Decode(v)
+}
+
+// Convert cell reference (e.g. "AB12") to zero based column index.
+func columnIndex(ref string) (int, error) {
+	col := 0
+	n := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A'+1)
+		n++
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("invalid cell reference: %#v", ref)
+	}
+	return col - 1, nil
+}
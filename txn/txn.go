@@ -0,0 +1,375 @@
+// Package txn implements crash-safe file writes.
+//
+// Every file is written to a temporary file in the same directory,
+// synced to disk and renamed over the target, so readers see either
+// the old or the new content. Writes to several files are grouped
+// with Tx: a journal listing prepared temporary files is written
+// before any of them is renamed, so an interrupted commit is
+// completed by Recover on the next start.
+package txn
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Permissions of files written
+const filePerm = 0600
+
+// Guards concurrent writes to the same file from
+// different goroutines. Maps file path to *sync.Mutex.
+var fileLocks sync.Map
+
+// Lock the file for exclusive writing within the process.
+// Returns a function releasing the lock.
+func Lock(path string) func() {
+	mu, _ := fileLocks.LoadOrStore(path, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// Staged file write
+type op struct {
+	// Target file path
+	path string
+	// Data to write
+	data []byte
+	// Append data to the existing content
+	append bool
+	// Written first when appending to a new or empty file
+	header []byte
+	// Remove the file
+	remove bool
+}
+
+// Tx is a set of file writes committed together.
+// All methods of a nil *Tx write files immediately.
+type Tx struct {
+	// Path to the journal file
+	journal string
+	// Staged writes in order of staging
+	ops []op
+}
+
+// Journal entry: a prepared temporary file to be renamed
+type journalEntry struct {
+	Temp string `json:"temp,omitempty"`
+	Path string `json:"path"`
+	// The file is removed instead
+	Remove bool `json:"remove,omitempty"`
+}
+
+// Begin starts a new transaction. The journal path must be
+// the same for all transactions touching the same files.
+func Begin(journal string) *Tx {
+	return &Tx{journal: journal}
+}
+
+// WriteFile stages replacing content of the file.
+func (tx *Tx) WriteFile(path string, data []byte) error {
+	if tx == nil {
+		return WriteFile(path, data)
+	}
+	tx.ops = append(tx.ops, op{path: path, data: data})
+	return nil
+}
+
+// AppendFile stages appending data to the file.
+// The header is written first when the file is new or empty.
+func (tx *Tx) AppendFile(path string, header, data []byte) error {
+	if tx == nil {
+		return AppendFile(path, header, data)
+	}
+	tx.ops = append(tx.ops, op{path: path, data: data, append: true, header: header})
+	return nil
+}
+
+// Remove stages removing the file. A missing file is not an error.
+func (tx *Tx) Remove(path string) error {
+	if tx == nil {
+		defer Lock(path)()
+		return apply([]journalEntry{{Path: path, Remove: true}})
+	}
+	tx.ops = append(tx.ops, op{path: path, remove: true})
+	return nil
+}
+
+// Staged reports whether a write to the file is staged.
+func (tx *Tx) Staged(path string) bool {
+	if tx == nil {
+		return false
+	}
+	for _, op := range tx.ops {
+		if op.path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Commit writes all staged files. On error, either none of the
+// files were changed or the journal is left for Recover.
+func (tx *Tx) Commit() error {
+	if tx == nil || len(tx.ops) == 0 {
+		return nil
+	}
+	// Lock files in a stable order to avoid deadlocks
+	paths := make([]string, 0, len(tx.ops))
+	locked := map[string]bool{}
+	for _, op := range tx.ops {
+		if !locked[op.path] {
+			locked[op.path] = true
+			paths = append(paths, op.path)
+		}
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		defer Lock(path)()
+	}
+
+	entries, err := tx.prepare()
+	if err != nil {
+		for _, entry := range entries {
+			_ = os.Remove(entry.Temp)
+		}
+		return err
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("encode journal: %w", err)
+	}
+	if err := WriteFile(tx.journal, data); err != nil {
+		for _, entry := range entries {
+			_ = os.Remove(entry.Temp)
+		}
+		return fmt.Errorf("write journal: %w", err)
+	}
+	if err := apply(entries); err != nil {
+		return err
+	}
+	if err := removeSync(tx.journal); err != nil {
+		return fmt.Errorf("remove journal: %w", err)
+	}
+	tx.ops = nil
+	return nil
+}
+
+// Rollback discards all staged writes.
+func (tx *Tx) Rollback() {
+	if tx != nil {
+		tx.ops = nil
+	}
+}
+
+// Prepare temporary files for all staged writes.
+// Writes to the same file are merged.
+func (tx *Tx) prepare() ([]journalEntry, error) {
+	var (
+		entries []journalEntry
+		// Index of the entry by target path
+		idx = map[string]int{}
+	)
+	for _, op := range tx.ops {
+		i, staged := idx[op.path]
+		if op.remove {
+			if !staged {
+				idx[op.path] = len(entries)
+				entries = append(entries, journalEntry{Path: op.path, Remove: true})
+				continue
+			}
+			_ = os.Remove(entries[i].Temp)
+			entries[i] = journalEntry{Path: op.path, Remove: true}
+			continue
+		}
+		base := op.path
+		if staged {
+			// Build on the previous staged content,
+			// a removed file is treated as empty
+			base = entries[i].Temp
+		}
+		temp, err := prepareFile(base, op)
+		if err != nil {
+			return entries, fmt.Errorf("prepare %s: %w", op.path, err)
+		}
+		if staged {
+			if !entries[i].Remove {
+				_ = os.Remove(entries[i].Temp)
+			}
+			entries[i] = journalEntry{Temp: temp, Path: op.path}
+			continue
+		}
+		idx[op.path] = len(entries)
+		entries = append(entries, journalEntry{Temp: temp, Path: op.path})
+	}
+	return entries, nil
+}
+
+// Write a temporary file next to the op target with the content
+// of the base file (when appending) and the op data. The file
+// is synced to disk.
+func prepareFile(base string, op op) (string, error) {
+	fd, err := ioutil.TempFile(filepath.Dir(op.path), "."+filepath.Base(op.path)+".tmp")
+	if err != nil {
+		return "", err
+	}
+	temp := fd.Name()
+	fail := func(err error) (string, error) {
+		_ = fd.Close()
+		_ = os.Remove(temp)
+		return "", err
+	}
+	if err := fd.Chmod(filePerm); err != nil {
+		return fail(err)
+	}
+	if op.append {
+		n, err := copyFile(fd, base)
+		if err != nil {
+			return fail(err)
+		}
+		if n == 0 {
+			if _, err := fd.Write(op.header); err != nil {
+				return fail(err)
+			}
+		}
+	}
+	if _, err := fd.Write(op.data); err != nil {
+		return fail(err)
+	}
+	if err := fd.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := fd.Close(); err != nil {
+		_ = os.Remove(temp)
+		return "", err
+	}
+	return temp, nil
+}
+
+// Copy content of the file to w. A missing file is treated as empty.
+func copyFile(w io.Writer, path string) (int64, error) {
+	if path == "" {
+		return 0, nil
+	}
+	fd, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer fd.Close()
+	return io.Copy(w, fd)
+}
+
+// Rename prepared temporary files over targets and remove files
+// staged for removal. Entries already applied (with no temporary
+// file or no file to remove) are skipped.
+func apply(entries []journalEntry) error {
+	dirs := map[string]bool{}
+	for _, entry := range entries {
+		if entry.Remove {
+			if err := os.Remove(entry.Path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("remove %s: %w", entry.Path, err)
+			}
+			dirs[filepath.Dir(entry.Path)] = true
+			continue
+		}
+		if err := os.Rename(entry.Temp, entry.Path); err != nil {
+			if os.IsNotExist(err) {
+				if _, err := os.Stat(entry.Path); err == nil {
+					continue
+				}
+			}
+			return fmt.Errorf("rename %s: %w", entry.Path, err)
+		}
+		dirs[filepath.Dir(entry.Path)] = true
+	}
+	for dir := range dirs {
+		if err := syncDir(dir); err != nil {
+			return fmt.Errorf("sync %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// Recover completes a transaction interrupted during commit.
+// Does nothing when there is no journal.
+func Recover(journal string) error {
+	data, err := ioutil.ReadFile(journal)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read journal: %w", err)
+	}
+	var entries []journalEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("parse journal: %w", err)
+	}
+	if err := apply(entries); err != nil {
+		return err
+	}
+	if err := removeSync(journal); err != nil {
+		return fmt.Errorf("remove journal: %w", err)
+	}
+	return nil
+}
+
+// WriteFile atomically replaces content of the file.
+func WriteFile(path string, data []byte) error {
+	defer Lock(path)()
+	temp, err := prepareFile(path, op{path: path, data: data})
+	if err != nil {
+		return err
+	}
+	return apply([]journalEntry{{Temp: temp, Path: path}})
+}
+
+// CreateFile atomically creates new file with the data.
+// Fails with an error satisfying os.IsExist when the file exists.
+func CreateFile(path string, data []byte) error {
+	temp, err := prepareFile(path, op{path: path, data: data})
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp)
+	if err := os.Link(temp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// AppendFile atomically appends data to the file.
+// The header is written first when the file is new or empty.
+func AppendFile(path string, header, data []byte) error {
+	defer Lock(path)()
+	temp, err := prepareFile(path, op{path: path, data: data, append: true, header: header})
+	if err != nil {
+		return err
+	}
+	return apply([]journalEntry{{Temp: temp, Path: path}})
+}
+
+// Remove the file and sync its directory.
+func removeSync(path string) error {
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// Sync directory entries to disk.
+func syncDir(dir string) error {
+	fd, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	return fd.Sync()
+}
@@ -0,0 +1,125 @@
+package txn
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readFile(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestCommit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "txn")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	a := filepath.Join(dir, "a.qif")
+	b := filepath.Join(dir, "b.json")
+	journal := filepath.Join(dir, "card.txn")
+	require.NoError(t, ioutil.WriteFile(b, []byte("old"), 0600))
+
+	tx := Begin(journal)
+	require.NoError(t, tx.AppendFile(a, []byte("header\n"), []byte("one\n")))
+	require.NoError(t, tx.AppendFile(a, []byte("header\n"), []byte("two\n")))
+	require.NoError(t, tx.WriteFile(b, []byte("new")))
+	assert.True(t, tx.Staged(a))
+	assert.False(t, tx.Staged(journal))
+
+	// Nothing is written before commit
+	_, err = os.Stat(a)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, "old", readFile(t, b))
+
+	require.NoError(t, tx.Commit())
+	assert.Equal(t, "header\none\ntwo\n", readFile(t, a))
+	assert.Equal(t, "new", readFile(t, b))
+	_, err = os.Stat(journal)
+	assert.True(t, os.IsNotExist(err))
+
+	// No temporary files left
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	// Rolled back writes are discarded
+	tx = Begin(journal)
+	require.NoError(t, tx.WriteFile(b, []byte("discarded")))
+	tx.Rollback()
+	require.NoError(t, tx.Commit())
+	assert.Equal(t, "new", readFile(t, b))
+
+	// Removed files are written again from scratch
+	tx = Begin(journal)
+	require.NoError(t, tx.Remove(b))
+	require.NoError(t, tx.Remove(a))
+	require.NoError(t, tx.AppendFile(a, []byte("header\n"), []byte("three\n")))
+	require.NoError(t, tx.Commit())
+	assert.Equal(t, "header\nthree\n", readFile(t, a))
+	_, err = os.Stat(b)
+	assert.True(t, os.IsNotExist(err))
+	files, err = ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "txn")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	journal := filepath.Join(dir, "card.txn")
+
+	// No journal
+	require.NoError(t, Recover(journal))
+
+	// Commit interrupted after the first rename
+	require.NoError(t, ioutil.WriteFile(a, []byte("a"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".b.tmp1"), []byte("b"), 0600))
+	require.NoError(t, ioutil.WriteFile(journal, []byte(`[
+		{"temp": "`+filepath.Join(dir, ".a.tmp1")+`", "path": "`+a+`"},
+		{"temp": "`+filepath.Join(dir, ".b.tmp1")+`", "path": "`+b+`"}
+	]`), 0600))
+	require.NoError(t, Recover(journal))
+	assert.Equal(t, "a", readFile(t, a))
+	assert.Equal(t, "b", readFile(t, b))
+	_, err = os.Stat(journal)
+	assert.True(t, os.IsNotExist(err))
+
+	// Broken journal
+	require.NoError(t, ioutil.WriteFile(journal, []byte("[{"), 0600))
+	assert.Error(t, Recover(journal))
+}
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "txn")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	a := filepath.Join(dir, "a")
+
+	// Nil transaction writes immediately
+	var tx *Tx
+	require.NoError(t, tx.AppendFile(a, []byte("h\n"), []byte("1\n")))
+	require.NoError(t, tx.AppendFile(a, []byte("h\n"), []byte("2\n")))
+	assert.Equal(t, "h\n1\n2\n", readFile(t, a))
+	require.NoError(t, tx.WriteFile(a, []byte("x")))
+	assert.Equal(t, "x", readFile(t, a))
+	require.NoError(t, tx.Commit())
+
+	info, err := os.Stat(a)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(filePerm), info.Mode().Perm())
+
+	err = CreateFile(a, []byte("y"))
+	assert.True(t, os.IsExist(err), err)
+	assert.Equal(t, "x", readFile(t, a))
+	require.NoError(t, CreateFile(filepath.Join(dir, "b"), []byte("y")))
+	assert.Equal(t, "y", readFile(t, filepath.Join(dir, "b")))
+}
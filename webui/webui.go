@@ -0,0 +1,237 @@
+// Package webui implements an embedded HTTP user interface for
+// reviewing and categorising unsorted transactions.
+package webui
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/logger"
+	"github.com/tuxofil/p24fetch/review"
+	"github.com/tuxofil/p24fetch/sorter"
+)
+
+// UI is an HTTP handler serving the user interface.
+type UI struct {
+	// Unsorted transactions manager
+	reviewer *review.Reviewer
+	// HTTP request router
+	mux *http.ServeMux
+	// Token every form is submitted with, so other sites
+	// can not submit forms on behalf of the user
+	csrfToken string
+}
+
+// Account as shown in the choice list
+type account struct {
+	ShortID string
+	Name    string
+}
+
+// Template data for a single unsorted transaction
+type row struct {
+	*review.Item
+	// Accounts to choose from
+	Accounts []account
+	// Pattern suggested for the new rule
+	Pattern string
+	// Not empty when accounts can not be loaded
+	Error string
+}
+
+// New creates new UI instance.
+func New(reviewer *review.Reviewer) *UI {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		panic(err)
+	}
+	ui := &UI{
+		reviewer:  reviewer,
+		mux:       http.NewServeMux(),
+		csrfToken: hex.EncodeToString(token),
+	}
+	ui.mux.HandleFunc("/", ui.handleIndex)
+	ui.mux.HandleFunc("/categorize", ui.handleCategorize)
+	return ui
+}
+
+// CheckAddr checks the address to serve the UI on is a loopback
+// one, as the UI has no authentication.
+func CheckAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %#v: %w", addr, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("web UI must listen on a loopback address, not %#v", addr)
+	}
+	return nil
+}
+
+// ServeHTTP implements http.Handler interface. Requests must name
+// a loopback host, so pages of other sites can not reach the UI
+// with DNS names resolving to the loopback address.
+func (ui *UI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !isLoopbackHost(r.Host) {
+		http.Error(w, "invalid host", http.StatusForbidden)
+		return
+	}
+	ui.mux.ServeHTTP(w, r)
+}
+
+// Check the host (with optional port) is localhost or a loopback IP.
+func isLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (ui *UI) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	items, err := ui.reviewer.Unsorted()
+	if err != nil {
+		logger.Default().Errorf("list unsorted: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	accounts := map[*config.Config][]account{}
+	rows := make([]row, len(items))
+	for i, item := range items {
+		rows[i] = row{Item: item, Pattern: regexp.QuoteMeta(item.Tran.Dst)}
+		if !item.CanCategorize() {
+			continue
+		}
+		if _, ok := accounts[item.Config]; !ok {
+			list, err := readAccounts(item.Config)
+			if err != nil {
+				rows[i].Error = err.Error()
+				continue
+			}
+			accounts[item.Config] = list
+		}
+		rows[i].Accounts = accounts[item.Config]
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = indexTemplate.Execute(w, map[string]interface{}{
+		"Rows":      rows,
+		"Message":   r.URL.Query().Get("msg"),
+		"CSRFToken": ui.csrfToken,
+	})
+	if err != nil {
+		logger.Default().Errorf("render index: %s", err)
+	}
+}
+
+func (ui *UI) handleCategorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := r.PostFormValue("csrf_token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(ui.csrfToken)) != 1 {
+		http.Error(w, "invalid CSRF token", http.StatusForbidden)
+		return
+	}
+	var pattern string
+	if r.PostFormValue("add_rule") != "" {
+		pattern = r.PostFormValue("pattern")
+	}
+	msg := "Transaction categorised"
+	item, err := ui.reviewer.Categorize(r.PostFormValue("id"),
+		r.PostFormValue("account"), pattern)
+	if err != nil {
+		msg = "Error: " + err.Error()
+	} else {
+		item.Config.Log().Infof("transaction %s categorised as %s",
+			item.ID, r.PostFormValue("account"))
+	}
+	http.Redirect(w, r, "/?msg="+url.QueryEscape(msg), http.StatusSeeOther)
+}
+
+// Read accounts from the merchant's rules file.
+func readAccounts(cfg *config.Config) ([]account, error) {
+	rules, err := sorter.LoadRules(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var res []account
+	for shortID, name := range rules.Accounts {
+		res = append(res, account{ShortID: shortID, Name: name})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res, nil
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>p24fetch: unsorted transactions</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: .4em; text-align: left; vertical-align: top; }
+td.amount { text-align: right; white-space: nowrap; }
+.message { background: #eef; padding: .5em; margin-bottom: 1em; }
+.error { color: #a00; }
+input[name=pattern] { width: 16em; }
+</style>
+</head>
+<body>
+<h1>Unsorted transactions</h1>
+{{if .Message}}<div class="message">{{.Message}}</div>{{end}}
+{{if not .Rows}}<p>No unsorted transactions.</p>{{else}}
+<table>
+<tr><th>Date</th><th>Merchant</th><th>Amount</th><th>Beneficiary</th><th>Note</th><th>Account</th></tr>
+{{range .Rows}}
+<tr>
+<td>{{.Tran.Date.Format "2006-01-02 15:04"}}</td>
+<td>{{.Config.MerchantName}}</td>
+<td class="amount">{{printf "%.2f" .Tran.SrcVal}} {{.Tran.SrcCur}}</td>
+<td>{{.Tran.Dst}}</td>
+<td>{{.Tran.Note}}</td>
+<td>
+{{if .Tran.Error}}<span class="error">{{.Tran.Error}}</span>
+{{else if .Error}}<span class="error">{{.Error}}</span>
+{{else}}
+<form method="post" action="/categorize">
+<input type="hidden" name="id" value="{{.ID}}">
+<input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+<select name="account">
+{{range .Accounts}}<option value="{{.ShortID}}">{{.Name}}</option>{{end}}
+</select>
+<label><input type="checkbox" name="add_rule" value="1"> add rule</label>
+<input type="text" name="pattern" value="{{.Pattern}}">
+<button type="submit">Save</button>
+</form>
+{{end}}
+</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,60 @@
+package webui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tuxofil/p24fetch/review"
+)
+
+func TestCheckAddr(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:8024", "[::1]:8024", "localhost:8024"} {
+		assert.NoError(t, CheckAddr(addr), addr)
+	}
+	for _, addr := range []string{":8024", "0.0.0.0:8024", "192.168.1.1:8024", "8024"} {
+		assert.Error(t, CheckAddr(addr), addr)
+	}
+}
+
+func TestHost(t *testing.T) {
+	ui := New(review.New(nil))
+	for host, code := range map[string]int{
+		"127.0.0.1:8024":     http.StatusOK,
+		"[::1]:8024":         http.StatusOK,
+		"localhost":          http.StatusOK,
+		"LocalHost:8024":     http.StatusOK,
+		"attacker.com:8024":  http.StatusForbidden,
+		"attacker.com":       http.StatusForbidden,
+		"192.168.1.1:8024":   http.StatusForbidden,
+		"localhost.evil.com": http.StatusForbidden,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Host = host
+		w := httptest.NewRecorder()
+		ui.ServeHTTP(w, r)
+		assert.Equal(t, code, w.Code, host)
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	ui := New(review.New(nil))
+	post := func(token string) *httptest.ResponseRecorder {
+		form := url.Values{"id": {"nonexistent"}, "account": {"food"}, "csrf_token": {token}}
+		r := httptest.NewRequest(http.MethodPost, "/categorize", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Host = "127.0.0.1:8024"
+		w := httptest.NewRecorder()
+		ui.ServeHTTP(w, r)
+		return w
+	}
+	assert.Equal(t, http.StatusForbidden, post("").Code)
+	assert.Equal(t, http.StatusForbidden, post("invalid").Code)
+	w := post(ui.csrfToken)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Contains(t, w.Header().Get("Location"), "transaction+not+found")
+}
@@ -0,0 +1,34 @@
+//go:build ignore
+// +build ignore
+
+// Generates rules_example.go with the content of
+// etc/rules.json.example, so the wizard writes the same rules.
+package main
+
+import (
+	"go/format"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+func main() {
+	data, err := ioutil.ReadFile("../etc/rules.json.example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if strings.Contains(string(data), "`") {
+		log.Fatal("rules example can't contain backquotes")
+	}
+	src := "// Code generated by gen_rules.go from etc/rules.json.example. DO NOT EDIT.\n\n" +
+		"package wizard\n\n" +
+		"// Content of etc/rules.json.example\n" +
+		"const rulesExample = `" + string(data) + "`\n"
+	formatted, err := format.Source([]byte(src))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("rules_example.go", formatted, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
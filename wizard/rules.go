@@ -0,0 +1,20 @@
+package wizard
+
+import (
+	"encoding/json"
+
+	"github.com/tuxofil/p24fetch/sorter"
+)
+
+//go:generate go run gen_rules.go
+
+// StarterRules returns rules written by the wizard: the rules
+// of etc/rules.json.example, which is not available at runtime
+// and is compiled in by go generate.
+func StarterRules() *sorter.Rules {
+	var rules sorter.Rules
+	if err := json.Unmarshal([]byte(rulesExample), &rules); err != nil {
+		panic(err)
+	}
+	return &rules
+}
@@ -0,0 +1,264 @@
+// Package wizard interactively asks for settings of a merchant
+// and builds a starter configuration out of the answers.
+package wizard
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/tuxofil/p24fetch/conffile"
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/schema"
+	"github.com/tuxofil/p24fetch/sorter"
+	"github.com/tuxofil/p24fetch/source"
+)
+
+// Settings written to the merchant entry. Others go to defaults.
+var merchantFields = map[string]bool{
+	"merchant_name":     true,
+	"merchant_id":       true,
+	"merchant_password": true,
+	"card_number":       true,
+	"src_account_name":  true,
+}
+
+type Wizard struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// New creates a wizard reading answers from in
+// and writing questions to out.
+func New(in io.Reader, out io.Writer) *Wizard {
+	return &Wizard{in: bufio.NewReader(in), out: out}
+}
+
+// Merchant asks for settings of the merchant. Values already
+// set in cfg are offered as defaults. Every answer is checked
+// with Config.Validate and asked again when invalid. Rules path
+// is not asked for, as the rules file may not exist yet.
+func (w *Wizard) Merchant(cfg *config.Config) error {
+	if cfg.Days == 0 {
+		cfg.Days = 30
+	}
+	if cfg.ExportFormat == "" {
+		cfg.ExportFormat = schema.JSON
+	}
+	steps := []struct {
+		field    string
+		question string
+		value    func() string
+		set      func(string) error
+	}{
+		{"merchant_name", "Merchant name", func() string { return cfg.MerchantName },
+			func(s string) error { cfg.MerchantName = s; return nil }},
+		{"merchant_id", "Merchant ID", func() string { return itoa(cfg.MerchantID) },
+			func(s string) (err error) { cfg.MerchantID, err = atoi(s); return err }},
+		{"merchant_password", "Merchant password (or env:NAME, file:PATH, pass:NAME)",
+			func() string { return cfg.MerchantPassword },
+			func(s string) error { cfg.MerchantPassword = s; return nil }},
+		{"card_number", "Card number", func() string { return cfg.CardNumber },
+			func(s string) error { cfg.CardNumber = s; return nil }},
+		{"days", "Fetch history for days", func() string { return itoa(cfg.Days) },
+			func(s string) (err error) { cfg.Days, err = atoi(s); return err }},
+		{"dedup_dir", "Deduplicator state directory", func() string { return cfg.DedupDir },
+			func(s string) error { cfg.DedupDir = s; return nil }},
+		{"results_dir", "Directory for exported files", func() string { return cfg.ResultsDir },
+			func(s string) error { cfg.ResultsDir = s; return nil }},
+		{"export_format", "Export format (JSON or QIF)",
+			func() string { return string(cfg.ExportFormat) },
+			func(s string) error { cfg.ExportFormat = schema.Format(strings.ToUpper(s)); return nil }},
+	}
+	for _, step := range steps {
+		if err := w.askField(cfg, step.field, step.question, step.value(), step.set); err != nil {
+			return err
+		}
+	}
+	if cfg.ExportFormat != schema.QIF {
+		return nil
+	}
+	if err := w.askField(cfg, "src_account_name", "GnuCash account of the card",
+		cfg.SrcAccountName,
+		func(s string) error { cfg.SrcAccountName = s; return nil }); err != nil {
+		return err
+	}
+	if cfg.ComissionAccountName == "" {
+		cfg.ComissionAccountName = "Expenses:Comissions"
+	}
+	return w.askField(cfg, "comission_account_name", "GnuCash account for comissions",
+		cfg.ComissionAccountName,
+		func(s string) error { cfg.ComissionAccountName = s; return nil })
+}
+
+// Accounts asks for the GnuCash expenses account and
+// moves accounts of the rules under it.
+func (w *Wizard) Accounts(rules *sorter.Rules) error {
+	const prefix = "Expenses"
+	answer, err := w.ask("GnuCash expenses account", prefix)
+	if err != nil {
+		return err
+	}
+	answer = strings.TrimSuffix(answer, ":")
+	if answer == prefix {
+		return nil
+	}
+	for shortID, account := range rules.Accounts {
+		if strings.HasPrefix(account, prefix+":") {
+			rules.Accounts[shortID] = answer + strings.TrimPrefix(account, prefix)
+		}
+	}
+	return nil
+}
+
+// Check tests credentials of the merchant by fetching
+// its transactions of the last days from the source.
+func (w *Wizard) Check(ctx context.Context, src source.Source, days int) error {
+	trans, err := source.FetchLog(ctx, src, days)
+	if err != nil {
+		return fmt.Errorf("fetch: %w", err)
+	}
+	fmt.Fprintf(w.out, "Credentials are valid: %d transactions in the last %d days\n",
+		len(trans), days)
+	return nil
+}
+
+// Confirm asks a yes/no question.
+func (w *Wizard) Confirm(question string, def bool) (bool, error) {
+	defAnswer := "n"
+	if def {
+		defAnswer = "y"
+	}
+	for {
+		answer, err := w.ask(question+" (y/n)", defAnswer)
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+		fmt.Fprintln(w.out, "Please answer y or n")
+	}
+}
+
+// Ask the field value until it is valid.
+func (w *Wizard) askField(cfg *config.Config, field, question, def string, set func(string) error) error {
+	for {
+		answer, err := w.ask(question, def)
+		if err != nil {
+			return err
+		}
+		if err := set(answer); err != nil {
+			fmt.Fprintf(w.out, "Invalid value: %s\n", err)
+			continue
+		}
+		problems := fieldProblems(cfg.Validate(), field)
+		if len(problems) == 0 {
+			return nil
+		}
+		for _, p := range problems {
+			fmt.Fprintf(w.out, "Invalid value: %s\n", p.Message)
+		}
+	}
+}
+
+// Ask the question. Returns def on empty answer.
+func (w *Wizard) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(w.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", question)
+	}
+	line, err := w.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		if err == io.EOF {
+			return "", errors.New("no answer")
+		}
+		return "", fmt.Errorf("read answer: %w", err)
+	}
+	if answer := strings.TrimSpace(line); answer != "" {
+		return answer, nil
+	}
+	return def, nil
+}
+
+// Problems of the validation error related to the field.
+func fieldProblems(err error, field string) []conffile.Problem {
+	var cerr *conffile.Error
+	if !errors.As(err, &cerr) {
+		return nil
+	}
+	var res []conffile.Problem
+	for _, p := range cerr.Problems {
+		if p.Field == field {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// Document returns content of the merchants file configuring
+// the merchant. Settings shared by merchants go to defaults,
+// settings not set are omitted.
+func Document(cfg *config.Config) (map[string]interface{}, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var settings map[string]interface{}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, err
+	}
+	entry := map[string]interface{}{}
+	defaults := map[string]interface{}{}
+	for key, value := range settings {
+		switch v := value.(type) {
+		case nil:
+			continue
+		case string:
+			if v == "" {
+				continue
+			}
+		case float64:
+			if v == 0 {
+				continue
+			}
+		case bool:
+			if !v {
+				continue
+			}
+		}
+		if merchantFields[key] {
+			entry[key] = value
+		} else {
+			defaults[key] = value
+		}
+	}
+	return map[string]interface{}{
+		"defaults":  defaults,
+		"merchants": []interface{}{entry},
+	}, nil
+}
+
+func itoa(i int) string {
+	if i == 0 {
+		return ""
+	}
+	return strconv.Itoa(i)
+}
+
+func atoi(s string) (int, error) {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("not a number: %#v", s)
+	}
+	return i, nil
+}
@@ -0,0 +1,104 @@
+package wizard
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tuxofil/p24fetch/config"
+	"github.com/tuxofil/p24fetch/merchant"
+	"github.com/tuxofil/p24fetch/schema"
+	"github.com/tuxofil/p24fetch/sorter"
+)
+
+func TestWizard(t *testing.T) {
+	answers := []string{
+		"", "main", // name is mandatory
+		"abc", "0", "123", // ID is a positive number
+		"env:P24_PASSWORD",
+		"1234",
+		"", // default days
+		"run/dedup",
+		"run/results",
+		"qif",
+		"Assets:Card",
+		"", // default comission account
+		"Spending:",
+		"maybe", "y",
+	}
+	var out bytes.Buffer
+	w := New(strings.NewReader(strings.Join(answers, "\n")+"\n"), &out)
+	cfg := &config.Config{}
+	require.NoError(t, w.Merchant(cfg))
+	assert.Equal(t, "main", cfg.MerchantName)
+	assert.Equal(t, 123, cfg.MerchantID)
+	assert.Equal(t, 30, cfg.Days)
+	assert.Equal(t, schema.QIF, cfg.ExportFormat)
+	assert.Equal(t, "Expenses:Comissions", cfg.ComissionAccountName)
+	assert.Contains(t, out.String(), "Invalid value: no merchant name\n")
+	assert.Contains(t, out.String(), "Invalid value: not a number: \"abc\"\n")
+	assert.Contains(t, out.String(), "Invalid value: invalid merchant ID: 0\n")
+
+	rules := StarterRules()
+	require.NoError(t, w.Accounts(rules))
+	assert.Equal(t, "Spending:Food", rules.Accounts["food"])
+
+	ok, err := w.Confirm("Test credentials?", false)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	_, err = w.Confirm("More?", false)
+	assert.Error(t, err)
+
+	doc, err := Document(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"days":                   float64(30),
+		"dedup_dir":              "run/dedup",
+		"results_dir":            "run/results",
+		"export_format":          "QIF",
+		"comission_account_name": "Expenses:Comissions",
+	}, doc["defaults"])
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"merchant_name":     "main",
+		"merchant_id":       float64(123),
+		"merchant_password": "env:P24_PASSWORD",
+		"card_number":       "1234",
+		"src_account_name":  "Assets:Card",
+	}}, doc["merchants"])
+}
+
+func TestCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<response><data><info><statements status="excellent">
+<statement card="1234" trandate="2020-01-01" amount="5.00 UAH" description="first"/>
+</statements></info></data></response>`))
+	}))
+	defer server.Close()
+	var out bytes.Buffer
+	w := New(strings.NewReader(""), &out)
+	m, err := merchant.New(&config.Config{MerchantID: 1, APIURL: server.URL})
+	require.NoError(t, err)
+	require.NoError(t, w.Check(context.Background(), m, 30))
+	assert.Equal(t, "Credentials are valid: 1 transactions in the last 30 days\n", out.String())
+}
+
+func TestStarterRules(t *testing.T) {
+	// Run go generate when the example is changed
+	data, err := ioutil.ReadFile("../etc/rules.json.example")
+	require.NoError(t, err)
+	assert.Equal(t, string(data), rulesExample)
+
+	example, err := sorter.ReadRules("../etc/rules.json.example")
+	require.NoError(t, err)
+	rules := StarterRules()
+	assert.Equal(t, example.Accounts, rules.Accounts)
+	assert.Equal(t, example.Ignore, rules.Ignore)
+	assert.Equal(t, example.Rules, rules.Rules)
+}